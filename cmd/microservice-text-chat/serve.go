@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/archive"
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/cli"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+	"github.com/Ubivius/microservice-text-chat/pkg/expiry"
+	"github.com/Ubivius/microservice-text-chat/pkg/handlers"
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+	"github.com/Ubivius/microservice-text-chat/pkg/router"
+	"github.com/Ubivius/microservice-text-chat/pkg/schedule"
+	"github.com/Ubivius/microservice-text-chat/pkg/unfurl"
+	"github.com/Ubivius/pkg-telemetry/metrics"
+	"github.com/Ubivius/pkg-telemetry/tracing"
+)
+
+// runServe starts the servers and the background workers of the service until it is interrupted
+func runServe(args []string) int {
+	flags, loader := cli.NewFlagSet("serve", "serve")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Error(err, "Invalid configuration")
+		return 2
+	}
+
+	// Starting tracer provider
+	tp := tracing.CreateTracerProvider(cfg.Services.JaegerEndpoint, "microservice-text-chat-traces")
+
+	// Starting metrics exporter
+	metrics.StartPrometheusExporterWithName("text_chat")
+	if err := retention.RegisterViews(); err != nil {
+		log.Error(err, "Failed to register retention metric views")
+	}
+
+	// Database init
+	db, err := cli.ConnectDatabase(cfg)
+	if err != nil {
+		return 1
+	}
+
+	// The pending migrations are applied before the service starts, the other replicas wait for them
+	if migrator, ok := db.(database.Migrator); ok && cfg.Features.MigrateOnStartup {
+		_, err = migrator.Migrate(context.Background())
+		if err != nil {
+			log.Error(err, "Failed to migrate the database")
+			db.CloseDB()
+			return 1
+		}
+	}
+
+	// Blob store for the content of the attachments
+	blobStore := cli.NewBlobStore(cfg)
+
+	// Event broker delivering the conversation events to the connected clients
+	broker := events.NewBroker()
+
+	// Background worker creating the link previews of the messages
+	workerContext, stopWorkers := context.WithCancel(context.Background())
+	// The messages are not unfurled when the link previews are disabled
+	unfurlWorker := unfurl.NewWorker(db, broker, unfurl.NewFetcher(cfg.Fetcher()))
+	var previews *unfurl.Worker
+	if cfg.Features.LinkPreviews {
+		previews = unfurlWorker
+		unfurlWorker.Start(workerContext)
+	}
+
+	// Background worker archiving the inactive game conversations
+	inactivity := cfg.Features.AutoArchiveAfter
+	archiver := archive.NewArchiver(db, inactivity)
+	if inactivity > 0 {
+		archiver.Start(workerContext)
+	}
+
+	// Background worker purging the expired ephemeral messages
+	sweeper := expiry.NewSweeper(db, attachments.NewService(db, blobStore), broker, cfg.Limits.ExpiryInterval)
+	sweeper.Start(workerContext)
+
+	// Background worker sending the scheduled messages when they are due
+	dispatcher := schedule.NewDispatcher(db, broker, previews, cfg.Limits.ScheduleInterval)
+	dispatcher.Start(workerContext)
+
+	// Background worker removing the messages older than the retention policy
+	policy := cfg.Features.RetentionPolicy
+	retentionWorker := retention.NewWorker(db, attachments.NewService(db, blobStore), policy, cfg.Features.RetentionDryRun)
+	retentionWorker.Start(workerContext)
+
+	// Creating handlers
+	textChatHandler := handlers.NewTextChatHandler(db,
+		handlers.WithBlobStore(blobStore),
+		handlers.WithEventBroker(broker),
+		handlers.WithUnfurlWorker(previews),
+		handlers.WithUserServiceURL(cfg.Services.UserURL),
+		handlers.WithSpectatorDelay(cfg.Features.SpectatorDelay),
+		handlers.WithRetentionPolicy(policy),
+		handlers.WithErasurePolicy(cfg.Features.ErasurePolicy),
+		handlers.WithImportTimeout(cfg.Limits.ImportTimeout),
+	)
+
+	// Mux route handling with gorilla/mux
+	r := router.New(textChatHandler)
+
+	// Server setup
+	server := &http.Server{
+		Addr:              cfg.Server.Address,
+		Handler:           r,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+	}
+
+	go func() {
+		log.Info("Starting server", "port", server.Addr)
+		err := server.ListenAndServe()
+		if err != nil {
+			log.Error(err, "Server error")
+		}
+	}()
+
+	// Mux route that is only exposed internally for the game server
+	internalRouter := router.NewInternalRouter(textChatHandler)
+	internalServer := &http.Server{
+		Addr:              cfg.InternalServer.Address,
+		Handler:           internalRouter,
+		IdleTimeout:       cfg.InternalServer.IdleTimeout,
+		ReadHeaderTimeout: cfg.InternalServer.ReadHeaderTimeout,
+		ReadTimeout:       cfg.InternalServer.ReadTimeout,
+		ConnContext:       handlers.ConnContext,
+	}
+
+	go func() {
+		log.Info("Starting internal server", "port", internalServer.Addr)
+		err := internalServer.ListenAndServe()
+		if err != nil {
+			log.Error(err, "Internal server error")
+		}
+	}()
+
+	// Handle shutdown signals from operating system
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	receivedSignal := <-signalChannel
+
+	log.Info("Received terminate, beginning graceful shutdown", "received_signal", receivedSignal.String())
+
+	// Background workers shutdown
+	stopWorkers()
+	unfurlWorker.Wait()
+	archiver.Wait()
+	sweeper.Wait()
+	dispatcher.Wait()
+	retentionWorker.Wait()
+
+	// DB connection shutdown
+	db.CloseDB()
+
+	// Context cancelling
+	timeoutContext, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Cleanly shutdown and flush telemetry on shutdown
+	defer func(ctx context.Context) {
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Error(err, "Error shutting down tracer provider")
+		}
+	}(timeoutContext)
+
+	// Server shutdown
+	_ = server.Shutdown(timeoutContext)
+	_ = internalServer.Shutdown(timeoutContext)
+	return 0
+}
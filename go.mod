@@ -11,9 +11,11 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/leodido/go-urn v1.2.1 // indirect
 	go.mongodb.org/mongo-driver v1.7.4
+	go.opencensus.io v0.23.0
 	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.26.1
 	go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo v0.26.1
 	go.opentelemetry.io/otel v1.1.0
+	golang.org/x/net v0.0.0-20211008194852-3b03d305991f
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	sigs.k8s.io/controller-runtime v0.10.2
 )
@@ -0,0 +1,47 @@
+package archive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+func TestArchiveInactiveConversations(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	lobby, err := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeLobby, UserID: []string{"a", "b"}, GameID: "game"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := db.AddConversation(ctx, &data.Conversation{UserID: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archiver := NewArchiver(db, time.Hour)
+	archived, err := archiver.ArchiveInactive(ctx, time.Now())
+	if err != nil || archived != 0 {
+		t.Fatalf("Expected active conversations to be kept, archived %d: %v", archived, err)
+	}
+
+	// Only game conversations are archived automatically
+	archived, err = archiver.ArchiveInactive(ctx, time.Now().Add(2*time.Hour))
+	if err != nil || archived != 1 {
+		t.Fatalf("Expected the lobby to be archived, archived %d: %v", archived, err)
+	}
+	lobby, _ = db.GetConversationByID(ctx, lobby.ID)
+	group, _ = db.GetConversationByID(ctx, group.ID)
+	if !lobby.Archived || group.Archived {
+		t.Errorf("Expected only the lobby to be archived, got lobby %t and group %t", lobby.Archived, group.Archived)
+	}
+
+	// Archived conversations are read-only
+	err = db.AddSystemMessage(ctx, data.NewSystemMessage(lobby.ID, "GG"))
+	if err != data.ErrorConversationArchived {
+		t.Errorf("Expected %v, got %v", data.ErrorConversationArchived, err)
+	}
+}
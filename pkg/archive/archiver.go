@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// DefaultInactivity is the inactivity period after which a game conversation is archived
+const DefaultInactivity = 72 * time.Hour
+
+// DefaultTypes are the conversation types archived automatically
+// Direct and group conversations are kept until their members archive them
+var DefaultTypes = []string{data.ConversationTypeLobby, data.ConversationTypeTeam, data.ConversationTypeMatch}
+
+// Archiver periodically archives the conversations without activity
+type Archiver struct {
+	db         database.TextChatDB
+	types      []string
+	inactivity time.Duration
+	interval   time.Duration
+	wait       sync.WaitGroup
+}
+
+// NewArchiver creates an archiver for the game conversations inactive for the given period
+func NewArchiver(db database.TextChatDB, inactivity time.Duration) *Archiver {
+	return &Archiver{
+		db:         db,
+		types:      DefaultTypes,
+		inactivity: inactivity,
+		interval:   time.Hour,
+	}
+}
+
+// Start runs the archiver until the context is cancelled
+func (archiver *Archiver) Start(ctx context.Context) {
+	archiver.wait.Add(1)
+	go func() {
+		defer archiver.wait.Done()
+		ticker := time.NewTicker(archiver.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = archiver.ArchiveInactive(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the archiver is stopped
+func (archiver *Archiver) Wait() {
+	archiver.wait.Wait()
+}
+
+// ArchiveInactive archives the conversations without activity for the inactivity period before now
+func (archiver *Archiver) ArchiveInactive(ctx context.Context, now time.Time) (int64, error) {
+	archived, err := archiver.db.ArchiveInactiveConversations(ctx, archiver.types, now.Add(-archiver.inactivity))
+	if err != nil {
+		log.Error(err, "Error archiving inactive conversations")
+		return 0, err
+	}
+	if archived > 0 {
+		log.Info("Archived inactive conversations", "count", archived)
+	}
+	return archived, nil
+}
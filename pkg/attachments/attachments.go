@@ -0,0 +1,134 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/google/uuid"
+)
+
+// sniffLength is the number of bytes used to detect the content type
+const sniffLength = 512
+
+// Service manages the attachments metadata in the database and their content in the blob store
+type Service struct {
+	db    database.TextChatDB
+	store BlobStore
+}
+
+// NewService creates an attachment service
+func NewService(db database.TextChatDB, store BlobStore) *Service {
+	return &Service{db: db, store: store}
+}
+
+// Upload stores the content of a new attachment and returns its metadata
+// The content type is sniffed from the content, the one declared by the client is ignored
+func (service *Service) Upload(ctx context.Context, userID string, fileName string, content io.Reader) (*data.Attachment, error) {
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+
+	contentType := http.DetectContentType(header)
+	if !data.IsAllowedAttachmentType(contentType) {
+		return nil, data.ErrorAttachmentType
+	}
+
+	attachment := &data.Attachment{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		FileName:    filepath.Base(fileName),
+		ContentType: contentType,
+	}
+
+	// Reading one byte more than allowed detects content that is too large
+	limited := io.LimitReader(io.MultiReader(bytes.NewReader(header), content), data.MaxAttachmentSize+1)
+	size, err := service.store.Put(ctx, attachment.ID, limited)
+	if err != nil {
+		return nil, err
+	}
+	if size > data.MaxAttachmentSize {
+		service.deleteBlob(ctx, attachment.ID)
+		return nil, data.ErrorAttachmentTooLarge
+	}
+	attachment.Size = size
+
+	err = service.db.AddAttachment(ctx, attachment)
+	if err != nil {
+		service.deleteBlob(ctx, attachment.ID)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Open returns the metadata and the content of an attachment
+func (service *Service) Open(ctx context.Context, id string) (*data.Attachment, io.ReadCloser, error) {
+	attachment, err := service.db.GetAttachmentByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := service.store.Get(ctx, attachment.ID)
+	if err == ErrorBlobNotFound {
+		return nil, nil, data.ErrorAttachmentNotFound
+	}
+	return attachment, content, err
+}
+
+// Resolve replaces the attachments referenced by the message with their stored metadata
+// Only attachments uploaded by the sender and not yet used in another message can be referenced
+func (service *Service) Resolve(ctx context.Context, message *data.Message) error {
+	for i, reference := range message.Attachments {
+		attachment, err := service.db.GetAttachmentByID(ctx, reference.ID)
+		if err != nil {
+			return err
+		}
+		if attachment.UserID != message.UserID || attachment.MessageID != "" {
+			return data.ErrorAttachmentUnavailable
+		}
+		stored := *attachment
+		message.Attachments[i] = &stored
+	}
+	return nil
+}
+
+// Link marks the attachments of a stored message as used by the message
+func (service *Service) Link(ctx context.Context, message *data.Message) error {
+	for _, attachment := range message.Attachments {
+		err := service.db.LinkAttachment(ctx, attachment.ID, message.ID)
+		if err != nil {
+			return err
+		}
+		attachment.MessageID = message.ID
+	}
+	return nil
+}
+
+// CleanupMessage deletes the attachments of a deleted message
+func (service *Service) CleanupMessage(ctx context.Context, messageID string) error {
+	attachments, err := service.db.GetAttachmentsByMessageID(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		service.deleteBlob(ctx, attachment.ID)
+		err = service.db.DeleteAttachment(ctx, attachment.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (service *Service) deleteBlob(ctx context.Context, key string) {
+	err := service.store.Delete(ctx, key)
+	if err != nil && err != ErrorBlobNotFound {
+		log.Error(err, "Error deleting attachment content", "key", key)
+	}
+}
@@ -0,0 +1,112 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+const userID = "a2181017-5c53-422b-b6bc-036b27c04fc8"
+
+// A minimal PNG signature is enough for content type sniffing
+var pngContent = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+
+func newService(t *testing.T) (*Service, *LocalBlobStore) {
+	store := NewLocalBlobStore(t.TempDir())
+	return NewService(database.NewMockTextChat(), store), store
+}
+
+func TestLocalBlobStoreRejectsPathTraversal(t *testing.T) {
+	store := NewLocalBlobStore(t.TempDir())
+	_, err := store.Put(context.Background(), "../escape", strings.NewReader("content"))
+	if err != ErrorInvalidBlobKey {
+		t.Errorf("Expected invalid key error but got %v", err)
+	}
+}
+
+func TestUploadSniffsContentType(t *testing.T) {
+	service, _ := newService(t)
+
+	attachment, err := service.Upload(context.Background(), userID, "../../screenshot.png", bytes.NewReader(pngContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.ContentType != "image/png" || attachment.Size != int64(len(pngContent)) || attachment.FileName != "screenshot.png" {
+		t.Errorf("Unexpected attachment metadata %+v", attachment)
+	}
+
+	_, content, err := service.Open(context.Background(), attachment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	stored, _ := ioutil.ReadAll(content)
+	if !bytes.Equal(stored, pngContent) {
+		t.Error("Stored content doesn't match uploaded content")
+	}
+}
+
+func TestUploadRejectsTypeAndSize(t *testing.T) {
+	service, _ := newService(t)
+
+	_, err := service.Upload(context.Background(), userID, "page.html", strings.NewReader("<html><script>alert(1)</script></html>"))
+	if err != data.ErrorAttachmentType {
+		t.Errorf("Expected content type error but got %v", err)
+	}
+
+	_, err = service.Upload(context.Background(), userID, "payload.exe", bytes.NewReader([]byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00")))
+	if err != data.ErrorAttachmentType {
+		t.Errorf("Expected binary data to be rejected but got %v", err)
+	}
+
+	tooLarge := append(append([]byte{}, pngContent...), make([]byte, data.MaxAttachmentSize)...)
+	_, err = service.Upload(context.Background(), userID, "huge.png", bytes.NewReader(tooLarge))
+	if err != data.ErrorAttachmentTooLarge {
+		t.Errorf("Expected too large error but got %v", err)
+	}
+}
+
+func TestResolveLinkAndCleanup(t *testing.T) {
+	service, store := newService(t)
+	ctx := context.Background()
+
+	attachment, err := service.Upload(ctx, userID, "screenshot.png", bytes.NewReader(pngContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &data.Message{ID: "message-id", UserID: "someone-else", Attachments: []*data.Attachment{{ID: attachment.ID}}}
+	if service.Resolve(ctx, message) != data.ErrorAttachmentUnavailable {
+		t.Error("Expected attachment of another user to be unavailable")
+	}
+
+	message.UserID = userID
+	err = service.Resolve(ctx, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Attachments[0].ContentType != "image/png" {
+		t.Error("Expected attachment metadata to be resolved")
+	}
+	err = service.Link(ctx, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &data.Message{ID: "other-message-id", UserID: userID, Attachments: []*data.Attachment{{ID: attachment.ID}}}
+	if err = service.Link(ctx, other); err != data.ErrorAttachmentLinked {
+		t.Errorf("Expected linked attachment not to be claimed again but got %v", err)
+	}
+
+	err = service.CleanupMessage(ctx, message.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(ctx, attachment.ID); err != ErrorBlobNotFound {
+		t.Errorf("Expected blob to be deleted but got %v", err)
+	}
+}
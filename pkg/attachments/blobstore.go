@@ -0,0 +1,100 @@
+package attachments
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrorBlobNotFound : Blob store specific errors
+var ErrorBlobNotFound = fmt.Errorf("blob not found")
+
+// ErrorInvalidBlobKey : The key can't be used to store a blob
+var ErrorInvalidBlobKey = fmt.Errorf("invalid blob key")
+
+// The interface that any kind of blob store must implement
+// Keys are opaque identifiers, an S3-compatible store can use them as object keys
+type BlobStore interface {
+	Put(ctx context.Context, key string, content io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore stores blobs as files in a directory of the local filesystem
+type LocalBlobStore struct {
+	directory string
+}
+
+// NewLocalBlobStore creates a blob store in the directory, the directory is created when needed
+func NewLocalBlobStore(directory string) *LocalBlobStore {
+	if directory == "" {
+		directory = filepath.Join(os.TempDir(), "text-chat-attachments")
+	}
+	return &LocalBlobStore{directory: directory}
+}
+
+func (store *LocalBlobStore) Put(ctx context.Context, key string, content io.Reader) (int64, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(store.directory, 0750)
+	if err != nil {
+		return 0, err
+	}
+
+	// Write to a temporary file first so a partial upload is never visible
+	file, err := os.CreateTemp(store.directory, key+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, content)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return 0, err
+	}
+
+	return size, os.Rename(file.Name(), path)
+}
+
+func (store *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrorBlobNotFound
+	}
+	return file, err
+}
+
+func (store *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrorBlobNotFound
+	}
+	return err
+}
+
+// path returns the file of the blob, keys can't escape the store directory
+func (store *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", ErrorInvalidBlobKey
+	}
+	return filepath.Join(store.directory, key), nil
+}
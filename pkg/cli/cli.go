@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/config"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// command is a command of the microservice, it returns the exit code of the process
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+// commands returns the commands of the microservice, they share the configuration and the database of the service
+// The serve command is given by the main package, which holds the servers of the service
+func commands(serve func(args []string) int) []command {
+	return []command{
+		{name: "serve", usage: "serve", run: serve},
+		{name: "migrate", usage: "migrate [-status]", run: runMigrate},
+		{name: "inspect", usage: "inspect conversation <id>", run: runInspect},
+		{name: "purge", usage: "purge [-dry-run]", run: runPurge},
+		{name: "export", usage: exportUsage, run: runExport},
+		{name: "import", usage: "import [-resume-from line] <file.ndjson|->", run: runImport},
+		{name: "reindex", usage: "reindex", run: runReindex},
+	}
+}
+
+// Run runs the command named by the first argument, the service is started with serve when the arguments don't name a command
+// It returns the exit code of the process
+func Run(serve func(args []string) int, args []string) int {
+	return dispatch(commands(serve), args)
+}
+
+// dispatch runs the command of the list named by the first argument, serve when the arguments don't name a command
+func dispatch(commands []command, args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, command := range commands {
+		if command.name == name {
+			return command.run(args)
+		}
+	}
+	printUsage(commands)
+	if name == "help" {
+		return 0
+	}
+	return 2
+}
+
+// printUsage prints the usage of every command
+func printUsage(commands []command) {
+	fmt.Fprintln(os.Stderr, "Usage: microservice <command> [arguments]")
+	for _, command := range commands {
+		fmt.Fprintln(os.Stderr, "  microservice "+command.usage)
+	}
+}
+
+// NewFlagSet creates the flags of a command with the flags of the configuration, the usage is printed when they can't be parsed
+func NewFlagSet(name string, usage string) (*flag.FlagSet, *config.Loader) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: microservice "+usage)
+		flags.PrintDefaults()
+	}
+	return flags, config.NewLoader(flags)
+}
+
+// openDatabase loads the configuration of the command and connects to the database of the service
+func openDatabase(loader *config.Loader) (*config.Config, database.TextChatDB, error) {
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Error(err, "Invalid configuration")
+		return nil, nil, err
+	}
+	db, err := ConnectDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, db, nil
+}
+
+// ConnectDatabase connects to the database of the configuration
+func ConnectDatabase(cfg *config.Config) (database.TextChatDB, error) {
+	mongoConfig, err := cfg.Mongo()
+	if err != nil {
+		log.Error(err, "Invalid database configuration")
+		return nil, err
+	}
+	db, err := database.NewMongoTextChat(mongoConfig)
+	if err != nil {
+		log.Error(err, "Failed to connect to the database")
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewBlobStore creates the blob store of the content of the attachments
+func NewBlobStore(cfg *config.Config) attachments.BlobStore {
+	return attachments.NewLocalBlobStore(cfg.AttachmentsPath)
+}
+
+// commandContext returns a context cancelled when the command is interrupted
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	go func() {
+		select {
+		case <-signalChannel:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signalChannel)
+	}()
+	return ctx, cancel
+}
+
+// printJSON prints the result of a command on the standard output
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		log.Error(err, "Error printing the result of the command")
+	}
+}
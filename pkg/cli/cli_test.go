@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		expectedCode int
+		expectedRun  string
+		expectedArgs []string
+	}{
+		{name: "default command", args: []string{}, expectedCode: 0, expectedRun: "serve", expectedArgs: []string{}},
+		{name: "flags of the default command", args: []string{"-http-address", ":8080"}, expectedCode: 0, expectedRun: "serve", expectedArgs: []string{"-http-address", ":8080"}},
+		{name: "named command", args: []string{"migrate", "-status"}, expectedCode: 0, expectedRun: "migrate", expectedArgs: []string{"-status"}},
+		{name: "help", args: []string{"help"}, expectedCode: 0},
+		{name: "unknown command", args: []string{"unknown"}, expectedCode: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ran string
+			var ranArgs []string
+			fake := func(name string) command {
+				return command{name: name, run: func(args []string) int {
+					ran, ranArgs = name, args
+					return 0
+				}}
+			}
+
+			code := dispatch([]command{fake("serve"), fake("migrate")}, test.args)
+			if code != test.expectedCode || ran != test.expectedRun {
+				t.Errorf("Expected %q to exit with %d, got %q with %d", test.expectedRun, test.expectedCode, ran, code)
+			}
+			if test.expectedRun != "" && !reflect.DeepEqual(ranArgs, test.expectedArgs) {
+				t.Errorf("Expected the arguments %v, got %v", test.expectedArgs, ranArgs)
+			}
+		})
+	}
+}
+
+func TestCommandArguments(t *testing.T) {
+	// A missing configuration file fails after the arguments are validated, before connecting to the database
+	missingConfig := filepath.Join(t.TempDir(), "missing.json")
+	tests := []struct {
+		name         string
+		run          func(args []string) int
+		args         []string
+		expectedCode int
+	}{
+		{name: "inspect without arguments", run: runInspect, args: []string{}, expectedCode: 2},
+		{name: "inspect without id", run: runInspect, args: []string{"conversation"}, expectedCode: 2},
+		{name: "inspect of an unknown kind", run: runInspect, args: []string{"user", "id"}, expectedCode: 2},
+		{name: "inspect with an unknown flag", run: runInspect, args: []string{"-unknown", "conversation", "id"}, expectedCode: 2},
+		{name: "inspect", run: runInspect, args: []string{"-config", missingConfig, "conversation", "id"}, expectedCode: 1},
+		{name: "export without arguments", run: runExport, args: []string{}, expectedCode: 2},
+		{name: "export of an unknown kind", run: runExport, args: []string{"match", "id"}, expectedCode: 2},
+		{name: "export without id", run: runExport, args: []string{"user"}, expectedCode: 2},
+		{name: "export with an invalid format", run: runExport, args: []string{"conversation", "-format", "pdf", "id"}, expectedCode: 2},
+		{name: "export with an invalid time zone", run: runExport, args: []string{"conversation", "-tz", "Mars/Olympus", "id"}, expectedCode: 2},
+		{name: "export of a user", run: runExport, args: []string{"user", "-config", missingConfig, "id"}, expectedCode: 1},
+		{name: "export of a conversation", run: runExport, args: []string{"conversation", "-config", missingConfig, "-format", "csv", "-tz", "UTC", "id"}, expectedCode: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := test.run(test.args); code != test.expectedCode {
+				t.Errorf("Expected the exit code %d, got %d", test.expectedCode, code)
+			}
+		})
+	}
+}
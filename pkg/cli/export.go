@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/export"
+	"github.com/Ubivius/microservice-text-chat/pkg/mentions"
+)
+
+// exportUsage is the usage of the export command
+const exportUsage = "export user [-o file] <id> | export conversation [-format json|ndjson|csv|txt|html] [-tz zone] [-o file] <id>"
+
+// runExport writes the archive of a user or the transcript of a conversation to a file or the standard output
+func runExport(args []string) int {
+	if len(args) == 0 || (args[0] != "user" && args[0] != "conversation") {
+		fmt.Fprintln(os.Stderr, "Usage: microservice "+exportUsage)
+		return 2
+	}
+	kind := args[0]
+	flags, loader := NewFlagSet("export", exportUsage)
+	path := flags.String("o", "-", "output file, - for the standard output")
+	format := flags.String("format", export.FormatJSON, "format of the conversation transcript")
+	timeZone := flags.String("tz", "UTC", "time zone of the timestamps of the conversation transcript")
+	if flags.Parse(args[1:]) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	id := flags.Arg(0)
+	location, err := time.LoadLocation(*timeZone)
+	if err != nil {
+		log.Error(err, "Invalid time zone", "tz", *timeZone)
+		return 2
+	}
+	if _, err = export.TranscriptContentType(*format); err != nil {
+		log.Error(err, "Invalid transcript format", "format", *format)
+		return 2
+	}
+
+	var output io.Writer = os.Stdout
+	if *path != "-" {
+		file, err := os.Create(*path)
+		if err != nil {
+			log.Error(err, "Failed to create export file", "path", *path)
+			return 1
+		}
+		defer file.Close()
+		output = file
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	cfg, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	if kind == "user" {
+		err = export.WriteUserArchive(ctx, db, id, time.Now(), output)
+	} else {
+		conversation, getErr := db.GetConversationByID(ctx, id)
+		err = getErr
+		if err == nil {
+			options := export.TranscriptOptions{Format: *format, Location: location, Now: time.Now()}
+			err = export.WriteTranscript(ctx, db, mentions.NewHTTPUserDirectory(cfg.Services.UserURL), conversation, options, output)
+		}
+	}
+	if err != nil {
+		log.Error(err, "Export failed", "kind", kind, "id", id)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"io"
+	"os"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/importer"
+)
+
+// runImport imports an NDJSON file of conversations and messages and prints the report
+// An interrupted import prints the line to resume it from
+func runImport(args []string) int {
+	flags, loader := NewFlagSet("import", "import [-resume-from line] <file.ndjson|->")
+	resumeFrom := flags.Int("resume-from", 1, "first line of the file to import")
+	if flags.Parse(args) != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Error(err, "Failed to open import file", "path", path)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	_, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	report, err := importer.NewImporter(db).Import(ctx, input, *resumeFrom)
+	printJSON(report)
+	if err != nil {
+		log.Error(err, "Import stopped, run the import again with -resume-from", "resume_from", report.ResumeFrom)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// conversationInspection is a conversation with a summary of its messages
+type conversationInspection struct {
+	Conversation   *data.Conversation `json:"conversation"`
+	DirectKey      string             `json:"direct_key,omitempty"`
+	Messages       int                `json:"messages"`
+	Tombstones     int                `json:"tombstones"`
+	Ephemeral      int                `json:"ephemeral"`
+	Authors        int                `json:"authors"`
+	FirstMessageOn *time.Time         `json:"first_message_on,omitempty"`
+	LastMessageOn  *time.Time         `json:"last_message_on,omitempty"`
+}
+
+// runInspect prints a conversation with a summary of its messages
+func runInspect(args []string) int {
+	flags, loader := NewFlagSet("inspect", "inspect conversation <id>")
+	if flags.Parse(args) != nil || flags.NArg() != 2 || flags.Arg(0) != "conversation" {
+		flags.Usage()
+		return 2
+	}
+	id := flags.Arg(1)
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	_, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	conversation, err := db.GetConversationByID(ctx, id)
+	if err != nil {
+		log.Error(err, "Failed to get conversation", "id", id)
+		return 1
+	}
+
+	inspection := &conversationInspection{Conversation: conversation, DirectKey: conversation.DirectKey}
+	authors := map[string]bool{}
+	now := time.Now()
+	err = db.StreamMessagesByConversationID(ctx, id, func(message *data.Message) error {
+		inspection.Messages++
+		if message.IsTombstone() {
+			inspection.Tombstones++
+		}
+		if message.ExpiresAt != nil && !message.IsExpired(now) {
+			inspection.Ephemeral++
+		}
+		authors[message.UserID] = true
+		createdOn := message.CreatedOn
+		if inspection.FirstMessageOn == nil {
+			inspection.FirstMessageOn = &createdOn
+		}
+		inspection.LastMessageOn = &createdOn
+		return nil
+	})
+	if err != nil {
+		log.Error(err, "Failed to read the messages of the conversation", "id", id)
+		return 1
+	}
+	inspection.Authors = len(authors)
+	printJSON(inspection)
+	return 0
+}
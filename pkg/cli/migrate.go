@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// runMigrate applies the pending migrations of the database and prints them
+// With -status the migrations are only listed, with the time they were applied
+func runMigrate(args []string) int {
+	flags, loader := NewFlagSet("migrate", "migrate [-status]")
+	status := flags.Bool("status", false, "only list the migrations and the time they were applied")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	_, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	migrator, ok := db.(database.Migrator)
+	if !ok {
+		log.Info("The database has no migration")
+		return 0
+	}
+	var migrations []*database.MigrationStatus
+	if *status {
+		migrations, err = migrator.MigrationStatus(ctx)
+	} else {
+		migrations, err = migrator.Migrate(ctx)
+	}
+	if err != nil {
+		log.Error(err, "Migration failed")
+		return 1
+	}
+	printJSON(migrations)
+	return 0
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+	"github.com/Ubivius/microservice-text-chat/pkg/expiry"
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+)
+
+// purgeReport is the result of the purge command
+type purgeReport struct {
+	Retention *retention.Report `json:"retention"`
+	Expired   int               `json:"expired"`
+}
+
+// runPurge applies the retention policy and deletes the expired ephemeral messages now, instead of waiting for the background workers
+// In dry-run mode only the messages the retention policy would remove are reported
+func runPurge(args []string) int {
+	flags, loader := NewFlagSet("purge", "purge [-dry-run]")
+	dryRun := flags.Bool("dry-run", false, "only report the messages the retention policy would remove")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	cfg, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	report := &purgeReport{}
+	now := time.Now()
+	attachmentService := attachments.NewService(db, NewBlobStore(cfg))
+	report.Retention, err = retention.NewWorker(db, attachmentService, cfg.Features.RetentionPolicy, *dryRun).Run(ctx, now)
+	if err == nil && !*dryRun {
+		// No client is connected to the events of the command
+		report.Expired, err = expiry.NewSweeper(db, attachmentService, events.NewBroker(), cfg.Limits.ExpiryInterval).Sweep(ctx, now)
+	}
+	printJSON(report)
+	if err != nil {
+		log.Error(err, "Purge failed")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// runReindex drops the indexes of the collections and creates the indexes of the service again
+func runReindex(args []string) int {
+	flags, loader := NewFlagSet("reindex", "reindex")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	_, db, err := openDatabase(loader)
+	if err != nil {
+		return 1
+	}
+	defer db.CloseDB()
+
+	indexManager, ok := db.(database.IndexManager)
+	if !ok {
+		log.Info("The database has no index to rebuild")
+		return 0
+	}
+	err = indexManager.Reindex(ctx)
+	if err != nil {
+		log.Error(err, "Reindex failed")
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+const (
+	moderatorID    = "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	memberID       = "2aee2975-6b76-4340-b679-e81661b1cdb5"
+	otherMemberID  = "3a1c152e-f172-41de-a5ab-ca21f6573bf3"
+	conversationID = "e2382ea2-b5fa-4506-aa9d-d338aa52af44"
+)
+
+func newCommandMessage(userID string, text string) *data.Message {
+	return &data.Message{
+		UserID:         userID,
+		ConversationID: conversationID,
+		Text:           text,
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	args, err := ParseArgs(`invite  @player "two words" last`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"invite", "@player", "two words", "last"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v but got %v", expected, args)
+	}
+
+	_, err = ParseArgs(`me "unterminated`)
+	if err != ErrorUnterminatedQuote {
+		t.Errorf("Expected unterminated quote error but got %v", err)
+	}
+}
+
+func TestIsCommand(t *testing.T) {
+	if !IsCommand("/roll") {
+		t.Error("Expected /roll to be a command")
+	}
+	if IsCommand("//roll") || IsCommand("/") || IsCommand("hello /roll") {
+		t.Error("Expected escaped and plain messages not to be commands")
+	}
+	if Unescape("//roll") != "/roll" {
+		t.Error("Expected escaped message to be unescaped")
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+	_, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/dance"))
+	if err != ErrorUnknownCommand {
+		t.Errorf("Expected unknown command error but got %v", err)
+	}
+}
+
+func TestHelpListsAllowedCommands(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+
+	result, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/help"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Reply, "/roll") || strings.Contains(result.Reply, "/mute") {
+		t.Error("Expected help for a member to list /roll but not /mute, got : ", result.Reply)
+	}
+	if result.Message != nil {
+		t.Error("Expected help to only reply privately")
+	}
+
+	result, err = registry.Execute(context.Background(), newCommandMessage(moderatorID, "/help"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(result.Reply, "/mute") {
+		t.Error("Expected help for a moderator to list /mute, got : ", result.Reply)
+	}
+}
+
+func TestRollPostsSystemMessage(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+
+	result, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/roll 2d6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message == nil || result.Message.Kind != data.MessageKindSystem {
+		t.Fatal("Expected roll to post a system message")
+	}
+	if !strings.Contains(result.Message.Text, "(2d6)") {
+		t.Error("Unexpected roll message : ", result.Message.Text)
+	}
+
+	result, err = registry.Execute(context.Background(), newCommandMessage(memberID, "/roll 0d1"))
+	if err != ErrorInvalidArguments || result == nil || result.Reply == "" {
+		t.Errorf("Expected invalid arguments with usage reply but got %v", err)
+	}
+}
+
+func TestRollConcurrently(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				if roll := registry.intn(6); roll < 0 || roll >= 6 {
+					t.Errorf("Unexpected roll %d", roll)
+				}
+			}
+		}()
+	}
+	wait.Wait()
+}
+
+func TestMeSendsEmote(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+
+	result, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/me waves at everyone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Message == nil || result.Message.Text != "waves at everyone" {
+		t.Fatal("Expected an emote message")
+	}
+	if result.Message.Render.Style != data.RenderStyleEmote || result.Message.UserID != memberID {
+		t.Error("Expected the emote to be a user message rendered as an emote")
+	}
+
+	_, err = registry.Execute(context.Background(), newCommandMessage(memberID, "/me"))
+	if err != ErrorInvalidArguments {
+		t.Errorf("Expected invalid arguments but got %v", err)
+	}
+}
+
+func TestMuteRequiresModerator(t *testing.T) {
+	db := database.NewMockTextChat()
+	registry := NewDefaultRegistry(db)
+
+	_, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/mute @"+otherMemberID))
+	if err != ErrorPermissionDenied {
+		t.Fatalf("Expected permission denied but got %v", err)
+	}
+
+	_, err = registry.Execute(context.Background(), newCommandMessage(moderatorID, "/mute @"+otherMemberID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AddMessage(context.Background(), newCommandMessage(otherMemberID, "Can anyone hear me?"))
+	if err != data.ErrorUserMuted {
+		t.Errorf("Expected muted user to be rejected but got %v", err)
+	}
+	_, err = registry.Execute(context.Background(), newCommandMessage(otherMemberID, "/me waves"))
+	if err != data.ErrorUserMuted {
+		t.Errorf("Expected muted user not to run commands but got %v", err)
+	}
+
+	_, err = registry.Execute(context.Background(), newCommandMessage(moderatorID, "/unmute @"+otherMemberID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AddMessage(context.Background(), newCommandMessage(otherMemberID, "Thanks"))
+	if err != nil {
+		t.Errorf("Expected unmuted user to send messages but got %v", err)
+	}
+}
+
+func TestInviteAddsUser(t *testing.T) {
+	db := database.NewMockTextChat()
+	registry := NewDefaultRegistry(db)
+
+	_, err := registry.Execute(context.Background(), newCommandMessage(memberID, "/invite @newPlayer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := db.GetConversationByID(context.Background(), conversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !conversation.HasUser("newPlayer") {
+		t.Error("Expected invited user to be added to the conversation")
+	}
+}
+
+func TestCommandFromNonMember(t *testing.T) {
+	registry := NewDefaultRegistry(database.NewMockTextChat())
+	_, err := registry.Execute(context.Background(), newCommandMessage("e2382ea2-b5fa-4506-aa9d-d338aa52af44", "/roll"))
+	if err != ErrorPermissionDenied {
+		t.Errorf("Expected permission denied for non member but got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+const (
+	maxDice  = 100
+	maxSides = 1000
+)
+
+// registerDefaults adds the built-in commands to the registry
+func registerDefaults(registry *Registry) {
+	registry.Register(&Command{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "List the available commands",
+		MaxArgs:     0,
+		Permission:  data.PermissionSendMessage,
+		Handler:     helpCommand,
+	})
+	registry.Register(&Command{
+		Name:        "roll",
+		Usage:       "/roll [NdM]",
+		Description: "Roll N dice of M sides, 1d100 by default",
+		MaxArgs:     1,
+		Permission:  data.PermissionSendMessage,
+		Handler:     registry.rollCommand,
+	})
+	registry.Register(&Command{
+		Name:        "me",
+		Usage:       "/me <action>",
+		Description: "Send an emote",
+		MinArgs:     1,
+		MaxArgs:     -1,
+		Permission:  data.PermissionSendMessage,
+		Handler:     meCommand,
+	})
+	registry.Register(&Command{
+		Name:        "mute",
+		Usage:       "/mute @player",
+		Description: "Prevent a player from sending messages in this conversation",
+		MinArgs:     1,
+		MaxArgs:     1,
+		Permission:  data.PermissionModerate,
+		Handler:     muteCommand,
+	})
+	registry.Register(&Command{
+		Name:        "unmute",
+		Usage:       "/unmute @player",
+		Description: "Allow a muted player to send messages again",
+		MinArgs:     1,
+		MaxArgs:     1,
+		Permission:  data.PermissionModerate,
+		Handler:     unmuteCommand,
+	})
+	registry.Register(&Command{
+		Name:        "invite",
+		Usage:       "/invite @player",
+		Description: "Add a player to this conversation",
+		MinArgs:     1,
+		MaxArgs:     1,
+		Permission:  data.PermissionAddMember,
+		Handler:     inviteCommand,
+	})
+}
+
+func helpCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	var builder strings.Builder
+	builder.WriteString("Available commands:")
+	for _, command := range invocation.Registry.Commands() {
+		if !invocation.Registry.isAllowed(command, invocation.Conversation, invocation.Message.UserID) {
+			continue
+		}
+		builder.WriteString("\n" + command.Usage + " : " + command.Description)
+	}
+	return &Result{Reply: builder.String()}, nil
+}
+
+func (registry *Registry) rollCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	dice, sides := 1, 100
+	if len(invocation.Args) == 1 {
+		var err error
+		dice, sides, err = parseDice(invocation.Args[0])
+		if err != nil {
+			return &Result{Reply: "Usage: /roll [NdM]"}, ErrorInvalidArguments
+		}
+	}
+
+	total := 0
+	for i := 0; i < dice; i++ {
+		total += registry.intn(sides) + 1
+	}
+
+	text := fmt.Sprintf("%s rolled %d (%dd%d)", invocation.Message.UserID, total, dice, sides)
+	return postSystemMessage(ctx, db, invocation.Conversation.ID, text)
+}
+
+func meCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	message := &data.Message{
+		UserID:         invocation.Message.UserID,
+		ConversationID: invocation.Conversation.ID,
+		Text:           strings.Join(invocation.Args, " "),
+		Render:         &data.RenderMetadata{Style: data.RenderStyleEmote},
+	}
+	err := db.AddMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Message: message}, nil
+}
+
+func muteCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	userID := userArg(invocation.Args[0])
+	conversation := invocation.Conversation
+	switch {
+	case userID == invocation.Message.UserID:
+		return &Result{Reply: "You can't mute yourself"}, ErrorInvalidArguments
+	case !conversation.HasUser(userID):
+		return &Result{Reply: userID + " is not in this conversation"}, ErrorInvalidArguments
+	case conversation.IsMuted(userID):
+		return &Result{Reply: userID + " is already muted"}, nil
+	}
+
+	updatedConversation := *conversation
+	updatedConversation.MutedUserID = append(append([]string{}, conversation.MutedUserID...), userID)
+	err := db.UpdateConversation(ctx, &updatedConversation)
+	if err != nil {
+		return nil, err
+	}
+	return postSystemMessage(ctx, db, conversation.ID, userID+" was muted")
+}
+
+func unmuteCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	userID := userArg(invocation.Args[0])
+	conversation := invocation.Conversation
+	if !conversation.IsMuted(userID) {
+		return &Result{Reply: userID + " is not muted"}, nil
+	}
+
+	updatedConversation := *conversation
+	updatedConversation.MutedUserID = []string{}
+	for _, id := range conversation.MutedUserID {
+		if id != userID {
+			updatedConversation.MutedUserID = append(updatedConversation.MutedUserID, id)
+		}
+	}
+	err := db.UpdateConversation(ctx, &updatedConversation)
+	if err != nil {
+		return nil, err
+	}
+	return postSystemMessage(ctx, db, conversation.ID, userID+" was unmuted")
+}
+
+func inviteCommand(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error) {
+	userID := userArg(invocation.Args[0])
+	conversation := invocation.Conversation
+	if conversation.IsGameBound() {
+		return &Result{Reply: "Players can't be invited to game conversations"}, nil
+	}
+	if conversation.HasUser(userID) {
+		return &Result{Reply: userID + " is already in this conversation"}, nil
+	}
+
+	updatedConversation := *conversation
+	updatedConversation.UserID = append(append([]string{}, conversation.UserID...), userID)
+	err := db.AddUserToConversation(ctx, &updatedConversation)
+	if err != nil {
+		return nil, err
+	}
+	return postSystemMessage(ctx, db, conversation.ID, invocation.Message.UserID+" invited "+userID)
+}
+
+// postSystemMessage posts a system message in the conversation and returns it as the command result
+func postSystemMessage(ctx context.Context, db database.TextChatDB, conversationID string, text string) (*Result, error) {
+	message := data.NewSystemMessage(conversationID, text)
+	err := db.AddSystemMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Message: message}, nil
+}
+
+// parseDice parses a dice expression like 2d6
+func parseDice(expression string) (int, int, error) {
+	parts := strings.SplitN(strings.ToLower(expression), "d", 2)
+	if len(parts) != 2 {
+		return 0, 0, ErrorInvalidArguments
+	}
+	dice := 1
+	if parts[0] != "" {
+		var err error
+		dice, err = strconv.Atoi(parts[0])
+		if err != nil {
+			return 0, 0, ErrorInvalidArguments
+		}
+	}
+	sides, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, ErrorInvalidArguments
+	}
+	if dice < 1 || dice > maxDice || sides < 2 || sides > maxSides {
+		return 0, 0, ErrorInvalidArguments
+	}
+	return dice, sides, nil
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrorUnterminatedQuote : Argument parsing errors
+var ErrorUnterminatedQuote = fmt.Errorf("unterminated quote in command arguments")
+
+// ParseArgs splits the command text into arguments
+// Arguments are separated by whitespace and can be grouped with double quotes
+func ParseArgs(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	hasArg := false
+
+	for _, r := range text {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case unicode.IsSpace(r) && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+
+	if inQuotes {
+		return nil, ErrorUnterminatedQuote
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// userArg returns the user ID referenced by an @player argument
+func userArg(arg string) string {
+	return strings.TrimPrefix(arg, "@")
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// ErrorUnknownCommand : Command specific errors
+var ErrorUnknownCommand = fmt.Errorf("unknown command")
+
+// ErrorPermissionDenied : The user is not allowed to run the command
+var ErrorPermissionDenied = data.ErrorPermissionDenied
+
+// ErrorInvalidArguments : The command received the wrong arguments
+var ErrorInvalidArguments = fmt.Errorf("invalid arguments")
+
+// Prefix is the first character of a message that is interpreted as a command
+const Prefix = "/"
+
+// Invocation holds everything a command needs to run
+type Invocation struct {
+	Name         string
+	Args         []string
+	Message      *data.Message
+	Conversation *data.Conversation
+	Registry     *Registry
+}
+
+// Result is the outcome of a command
+// Reply is private and only returned to the user who ran the command,
+// Message is the message that was posted in the conversation, if any
+type Result struct {
+	Command string        `json:"command"`
+	Reply   string        `json:"reply,omitempty"`
+	Message *data.Message `json:"message,omitempty"`
+}
+
+// HandlerFunc runs a command
+type HandlerFunc func(ctx context.Context, db database.TextChatDB, invocation *Invocation) (*Result, error)
+
+// Command defines a chat command
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	MinArgs     int
+	// MaxArgs is the maximum number of arguments, -1 means no limit
+	MaxArgs int
+	// Permission is the permission of the conversation the user needs to run the command
+	Permission data.Permission
+	Handler    HandlerFunc
+}
+
+// Registry holds the commands that can be run from the chat
+type Registry struct {
+	db       database.TextChatDB
+	commands map[string]*Command
+	// random is shared by the concurrent requests, randomMutex protects it
+	random      *rand.Rand
+	randomMutex sync.Mutex
+}
+
+// NewRegistry creates an empty command registry
+func NewRegistry(db database.TextChatDB) *Registry {
+	return &Registry{
+		db:       db,
+		commands: make(map[string]*Command),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NewDefaultRegistry creates a command registry with all the built-in commands
+func NewDefaultRegistry(db database.TextChatDB) *Registry {
+	registry := NewRegistry(db)
+	registerDefaults(registry)
+	return registry
+}
+
+// intn returns a random number in [0, n)
+func (registry *Registry) intn(n int) int {
+	registry.randomMutex.Lock()
+	defer registry.randomMutex.Unlock()
+	return registry.random.Intn(n)
+}
+
+// Register adds a command to the registry, replacing any command with the same name
+func (registry *Registry) Register(command *Command) {
+	registry.commands[strings.ToLower(command.Name)] = command
+}
+
+// Commands returns the registered commands sorted by name
+func (registry *Registry) Commands() []*Command {
+	commands := make([]*Command, 0, len(registry.commands))
+	for _, command := range registry.commands {
+		commands = append(commands, command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// IsCommand returns true when the text of the message should be interpreted as a command
+// A text starting with a double prefix is an escaped message and not a command
+func IsCommand(text string) bool {
+	return strings.HasPrefix(text, Prefix) && !strings.HasPrefix(text, Prefix+Prefix) && len(text) > len(Prefix)
+}
+
+// Unescape removes the escaping prefix of a message that starts with a double prefix
+func Unescape(text string) string {
+	if strings.HasPrefix(text, Prefix+Prefix) {
+		return strings.TrimPrefix(text, Prefix)
+	}
+	return text
+}
+
+// Execute parses and runs the command contained in the message text
+func (registry *Registry) Execute(ctx context.Context, message *data.Message) (*Result, error) {
+	args, err := ParseArgs(strings.TrimPrefix(message.Text, Prefix))
+	if err != nil || len(args) == 0 {
+		return nil, ErrorInvalidArguments
+	}
+	name := strings.ToLower(args[0])
+	args = args[1:]
+
+	command, ok := registry.commands[name]
+	if !ok {
+		return nil, ErrorUnknownCommand
+	}
+
+	conversation, err := registry.db.GetConversationByID(ctx, message.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !registry.isAllowed(command, conversation, message.UserID) {
+		return nil, ErrorPermissionDenied
+	}
+	if conversation.IsMuted(message.UserID) {
+		return nil, data.ErrorUserMuted
+	}
+
+	if len(args) < command.MinArgs || (command.MaxArgs >= 0 && len(args) > command.MaxArgs) {
+		return &Result{Command: command.Name, Reply: "Usage: " + command.Usage}, ErrorInvalidArguments
+	}
+
+	log.Info("Executing command", "command", command.Name, "user_id", message.UserID, "conversation_id", conversation.ID)
+	invocation := &Invocation{
+		Name:         command.Name,
+		Args:         args,
+		Message:      message,
+		Conversation: conversation,
+		Registry:     registry,
+	}
+	result, err := command.Handler(ctx, registry.db, invocation)
+	if result != nil {
+		result.Command = command.Name
+	}
+	return result, err
+}
+
+// isAllowed checks the permission of the command against the role of the user
+func (registry *Registry) isAllowed(command *Command, conversation *data.Conversation, userID string) bool {
+	return conversation.Can(userID, command.Permission)
+}
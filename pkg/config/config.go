@@ -0,0 +1,231 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/archive"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/erasure"
+	"github.com/Ubivius/microservice-text-chat/pkg/expiry"
+	"github.com/Ubivius/microservice-text-chat/pkg/importer"
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+	"github.com/Ubivius/microservice-text-chat/pkg/schedule"
+	"github.com/Ubivius/microservice-text-chat/pkg/unfurl"
+)
+
+// Config is the configuration of the microservice
+type Config struct {
+	Database        DatabaseConfig
+	Services        ServicesConfig
+	Server          ServerConfig
+	InternalServer  ServerConfig
+	ShutdownTimeout time.Duration
+	// AttachmentsPath is the directory of the local blob store of the content of the attachments, a temporary directory by default
+	AttachmentsPath string
+	Limits          LimitsConfig
+	Features        FeaturesConfig
+}
+
+// DatabaseConfig is the configuration of MongoDB
+// The URI is built from the hostname, port and credentials when it is not set
+type DatabaseConfig struct {
+	URI            string
+	Hostname       string
+	Port           string
+	Username       string
+	Password       string
+	Name           string
+	MaxPoolSize    int64
+	MinPoolSize    int64
+	ConnectTimeout time.Duration
+}
+
+// ServicesConfig holds the URLs of the services used by the microservice
+type ServicesConfig struct {
+	UserURL        string
+	JaegerEndpoint string
+}
+
+// ServerConfig is the configuration of an HTTP server
+// A ReadHeaderTimeout of 0 uses the ReadTimeout
+type ServerConfig struct {
+	Address           string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+}
+
+// LimitsConfig holds the limits of the link previews and the imports and the intervals of the background workers
+type LimitsConfig struct {
+	LinkPreviewTimeout      time.Duration
+	LinkPreviewMaxBodySize  int64
+	LinkPreviewMaxRedirects int64
+	ExpiryInterval          time.Duration
+	ScheduleInterval        time.Duration
+	ImportTimeout           time.Duration
+}
+
+// FeaturesConfig holds the features that can be disabled or tuned
+// An AutoArchiveAfter or a SpectatorDelay of 0 disables the feature
+type FeaturesConfig struct {
+	LinkPreviews     bool
+	AutoArchiveAfter time.Duration
+	SpectatorDelay   time.Duration
+	RetentionPolicy  retention.Policy
+	RetentionDryRun  bool
+	ErasurePolicy    string
+	MigrateOnStartup bool
+}
+
+// Default returns the default configuration, the database hostname and credentials have no default
+func Default() *Config {
+	fetcher := unfurl.DefaultFetcherConfig()
+	return &Config{
+		Database: DatabaseConfig{
+			Name:           "ubivius",
+			MaxPoolSize:    100,
+			ConnectTimeout: 10 * time.Second,
+		},
+		Services: ServicesConfig{
+			UserURL: "http://microservice-user:9090",
+		},
+		Server: ServerConfig{
+			Address:     ":9090",
+			ReadTimeout: 1 * time.Second,
+			IdleTimeout: 120 * time.Second,
+		},
+		InternalServer: ServerConfig{
+			Address:     ":9091",
+			ReadTimeout: 1 * time.Second,
+			IdleTimeout: 120 * time.Second,
+		},
+		ShutdownTimeout: 5 * time.Second,
+		Limits: LimitsConfig{
+			LinkPreviewTimeout:      fetcher.Timeout,
+			LinkPreviewMaxBodySize:  fetcher.MaxBodySize,
+			LinkPreviewMaxRedirects: int64(fetcher.MaxRedirects),
+			ExpiryInterval:          expiry.DefaultInterval,
+			ScheduleInterval:        schedule.DefaultInterval,
+			ImportTimeout:           importer.DefaultTimeout,
+		},
+		Features: FeaturesConfig{
+			LinkPreviews:     true,
+			AutoArchiveAfter: archive.DefaultInactivity,
+			SpectatorDelay:   data.DefaultSpectatorDelay,
+			RetentionPolicy:  retention.DefaultPolicy,
+			ErasurePolicy:    erasure.DefaultPolicy,
+			MigrateOnStartup: true,
+		},
+	}
+}
+
+// Validate checks the configuration, the error names the invalid setting
+func (config *Config) Validate() error {
+	db := config.Database
+	if db.URI == "" && (db.Hostname == "" || db.Port == "" || db.Username == "" || db.Password == "") {
+		return fmt.Errorf("%w: DB_URI or DB_HOSTNAME, DB_PORT, DB_USERNAME and DB_PASSWORD are required", ErrorInvalidSetting)
+	}
+	if db.Name == "" {
+		return invalidSetting("DB_NAME", "must not be empty")
+	}
+	if db.MaxPoolSize < 0 || db.MinPoolSize < 0 {
+		return invalidSetting("DB_MAX_POOL_SIZE", "pool sizes must not be negative")
+	}
+	if db.MaxPoolSize > 0 && db.MinPoolSize > db.MaxPoolSize {
+		return invalidSetting("DB_MIN_POOL_SIZE", "must not be greater than DB_MAX_POOL_SIZE")
+	}
+	if db.ConnectTimeout <= 0 {
+		return invalidSetting("DB_CONNECT_TIMEOUT", "must be positive")
+	}
+
+	userURL, err := url.Parse(config.Services.UserURL)
+	if err != nil || (userURL.Scheme != "http" && userURL.Scheme != "https") || userURL.Host == "" {
+		return invalidSetting("USER_SERVICE_URL", "must be an http or https URL")
+	}
+
+	if config.Server.Address == "" {
+		return invalidSetting("HTTP_ADDRESS", "must not be empty")
+	}
+	if config.InternalServer.Address == "" || config.InternalServer.Address == config.Server.Address {
+		return invalidSetting("INTERNAL_HTTP_ADDRESS", "must be set and differ from HTTP_ADDRESS")
+	}
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", config.Server.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", config.Server.ReadHeaderTimeout},
+		{"HTTP_IDLE_TIMEOUT", config.Server.IdleTimeout},
+		{"INTERNAL_HTTP_READ_TIMEOUT", config.InternalServer.ReadTimeout},
+		{"INTERNAL_HTTP_READ_HEADER_TIMEOUT", config.InternalServer.ReadHeaderTimeout},
+		{"INTERNAL_HTTP_IDLE_TIMEOUT", config.InternalServer.IdleTimeout},
+		{"AUTO_ARCHIVE_AFTER", config.Features.AutoArchiveAfter},
+		{"SPECTATOR_DELAY", config.Features.SpectatorDelay},
+	} {
+		if timeout.value < 0 {
+			return invalidSetting(timeout.key, "must not be negative")
+		}
+	}
+	for _, interval := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"SHUTDOWN_TIMEOUT", config.ShutdownTimeout},
+		{"LINK_PREVIEW_TIMEOUT", config.Limits.LinkPreviewTimeout},
+		{"EXPIRY_INTERVAL", config.Limits.ExpiryInterval},
+		{"SCHEDULE_INTERVAL", config.Limits.ScheduleInterval},
+		{"IMPORT_TIMEOUT", config.Limits.ImportTimeout},
+	} {
+		if interval.value <= 0 {
+			return invalidSetting(interval.key, "must be positive")
+		}
+	}
+
+	if config.Limits.LinkPreviewMaxBodySize <= 0 {
+		return invalidSetting("LINK_PREVIEW_MAX_BODY_SIZE", "must be positive")
+	}
+	if config.Limits.LinkPreviewMaxRedirects < 0 {
+		return invalidSetting("LINK_PREVIEW_MAX_REDIRECTS", "must not be negative")
+	}
+	if !erasure.IsValidPolicy(config.Features.ErasurePolicy) {
+		return invalidSetting("ERASURE_POLICY", erasure.ErrorInvalidPolicy.Error())
+	}
+	return nil
+}
+
+// Mongo returns the configuration of the connection to MongoDB
+func (config *Config) Mongo() (database.MongoConfig, error) {
+	uri := config.Database.URI
+	if uri == "" {
+		var err error
+		uri, err = database.MongoURI(config.Database.Hostname, config.Database.Port, config.Database.Username, config.Database.Password)
+		if err != nil {
+			return database.MongoConfig{}, err
+		}
+	}
+	return database.MongoConfig{
+		URI:            uri,
+		Database:       config.Database.Name,
+		MaxPoolSize:    uint64(config.Database.MaxPoolSize),
+		MinPoolSize:    uint64(config.Database.MinPoolSize),
+		ConnectTimeout: config.Database.ConnectTimeout,
+		UserServiceURL: config.Services.UserURL,
+	}, nil
+}
+
+// Fetcher returns the limits of the fetcher of the link previews
+func (config *Config) Fetcher() unfurl.FetcherConfig {
+	fetcher := unfurl.DefaultFetcherConfig()
+	fetcher.Timeout = config.Limits.LinkPreviewTimeout
+	fetcher.MaxBodySize = config.Limits.LinkPreviewMaxBodySize
+	fetcher.MaxRedirects = int(config.Limits.LinkPreviewMaxRedirects)
+	return fetcher
+}
+
+// invalidSetting is the error of a setting with an invalid value
+func invalidSetting(key string, reason string) error {
+	return fmt.Errorf("%w: %s %s", ErrorInvalidSetting, key, reason)
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+)
+
+// ErrorInvalidSetting : A setting of the configuration has an invalid value
+var ErrorInvalidSetting = fmt.Errorf("invalid setting")
+
+// ErrorUnknownSetting : The configuration file has a setting that doesn't exist
+var ErrorUnknownSetting = fmt.Errorf("unknown setting")
+
+// FileVariable is the environment variable of the path of the configuration file
+const FileVariable = "CONFIG_FILE"
+
+// setting is a value of the configuration, its key is the name of its environment variable and of its field in the file
+// Empty values are ignored, unless the empty value has a meaning for the setting
+type setting struct {
+	key         string
+	usage       string
+	allowsEmpty bool
+	apply       func(config *Config, value string) error
+}
+
+// settings returns the settings of the configuration
+func settings() []setting {
+	return []setting{
+		stringSetting("DB_URI", "URI of MongoDB, replaces the hostname, port and credentials", func(config *Config) *string { return &config.Database.URI }),
+		stringSetting("DB_HOSTNAME", "hostname of MongoDB", func(config *Config) *string { return &config.Database.Hostname }),
+		stringSetting("DB_PORT", "port of MongoDB", func(config *Config) *string { return &config.Database.Port }),
+		stringSetting("DB_USERNAME", "user of MongoDB", func(config *Config) *string { return &config.Database.Username }),
+		stringSetting("DB_PASSWORD", "password of the user of MongoDB", func(config *Config) *string { return &config.Database.Password }),
+		stringSetting("DB_NAME", "name of the database", func(config *Config) *string { return &config.Database.Name }),
+		intSetting("DB_MAX_POOL_SIZE", "maximum number of connections to MongoDB, 0 for no limit", func(config *Config) *int64 { return &config.Database.MaxPoolSize }),
+		intSetting("DB_MIN_POOL_SIZE", "minimum number of connections to MongoDB", func(config *Config) *int64 { return &config.Database.MinPoolSize }),
+		durationSetting("DB_CONNECT_TIMEOUT", "timeout of the connection to MongoDB", func(config *Config) *time.Duration { return &config.Database.ConnectTimeout }),
+		stringSetting("USER_SERVICE_URL", "base URL of microservice-user", func(config *Config) *string { return &config.Services.UserURL }),
+		stringSetting("JAEGER_ENDPOINT", "endpoint of the traces collector", func(config *Config) *string { return &config.Services.JaegerEndpoint }),
+		stringSetting("HTTP_ADDRESS", "address of the server", func(config *Config) *string { return &config.Server.Address }),
+		durationSetting("HTTP_READ_TIMEOUT", "timeout of the reading of the requests of the server", func(config *Config) *time.Duration { return &config.Server.ReadTimeout }),
+		durationSetting("HTTP_READ_HEADER_TIMEOUT", "timeout of the reading of the headers of the requests of the server", func(config *Config) *time.Duration { return &config.Server.ReadHeaderTimeout }),
+		durationSetting("HTTP_IDLE_TIMEOUT", "timeout of the idle connections of the server", func(config *Config) *time.Duration { return &config.Server.IdleTimeout }),
+		stringSetting("INTERNAL_HTTP_ADDRESS", "address of the internal server", func(config *Config) *string { return &config.InternalServer.Address }),
+		durationSetting("INTERNAL_HTTP_READ_TIMEOUT", "timeout of the reading of the requests of the internal server", func(config *Config) *time.Duration { return &config.InternalServer.ReadTimeout }),
+		durationSetting("INTERNAL_HTTP_READ_HEADER_TIMEOUT", "timeout of the reading of the headers of the requests of the internal server", func(config *Config) *time.Duration { return &config.InternalServer.ReadHeaderTimeout }),
+		durationSetting("INTERNAL_HTTP_IDLE_TIMEOUT", "timeout of the idle connections of the internal server", func(config *Config) *time.Duration { return &config.InternalServer.IdleTimeout }),
+		durationSetting("SHUTDOWN_TIMEOUT", "timeout of the graceful shutdown", func(config *Config) *time.Duration { return &config.ShutdownTimeout }),
+		stringSetting("ATTACHMENTS_PATH", "directory of the content of the attachments", func(config *Config) *string { return &config.AttachmentsPath }),
+		durationSetting("LINK_PREVIEW_TIMEOUT", "timeout of the fetch of a link preview", func(config *Config) *time.Duration { return &config.Limits.LinkPreviewTimeout }),
+		intSetting("LINK_PREVIEW_MAX_BODY_SIZE", "maximum size in bytes of a page fetched for a link preview", func(config *Config) *int64 { return &config.Limits.LinkPreviewMaxBodySize }),
+		intSetting("LINK_PREVIEW_MAX_REDIRECTS", "maximum number of redirects of a link preview", func(config *Config) *int64 { return &config.Limits.LinkPreviewMaxRedirects }),
+		durationSetting("EXPIRY_INTERVAL", "interval of the purge of the expired ephemeral messages", func(config *Config) *time.Duration { return &config.Limits.ExpiryInterval }),
+		durationSetting("SCHEDULE_INTERVAL", "interval of the dispatch of the scheduled messages", func(config *Config) *time.Duration { return &config.Limits.ScheduleInterval }),
+		durationSetting("IMPORT_TIMEOUT", "time given to an import request of the internal server to send its body", func(config *Config) *time.Duration { return &config.Limits.ImportTimeout }),
+		boolSetting("LINK_PREVIEWS", "create the previews of the links of the messages", func(config *Config) *bool { return &config.Features.LinkPreviews }),
+		durationSetting("AUTO_ARCHIVE_AFTER", "inactivity after which game conversations are archived, 0 disables the archival", func(config *Config) *time.Duration { return &config.Features.AutoArchiveAfter }),
+		durationSetting("SPECTATOR_DELAY", "delay of the all-chat seen by spectators, 0 disables the delay", func(config *Config) *time.Duration { return &config.Features.SpectatorDelay }),
+		{
+			key:         "RETENTION_POLICY",
+			usage:       "retention rules of the messages, empty keeps every message",
+			allowsEmpty: true,
+			apply: func(config *Config, value string) error {
+				policy, err := retention.ParsePolicy(value)
+				config.Features.RetentionPolicy = policy
+				return err
+			},
+		},
+		boolSetting("RETENTION_DRY_RUN", "only report the messages the retention policy would remove", func(config *Config) *bool { return &config.Features.RetentionDryRun }),
+		stringSetting("ERASURE_POLICY", "policy applied to the messages of an erased user, anonymize or delete", func(config *Config) *string { return &config.Features.ErasurePolicy }),
+		boolSetting("MIGRATE_ON_STARTUP", "apply the pending migrations of the database when the service starts", func(config *Config) *bool { return &config.Features.MigrateOnStartup }),
+	}
+}
+
+func stringSetting(key string, usage string, field func(config *Config) *string) setting {
+	return setting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		*field(config) = value
+		return nil
+	}}
+}
+
+func intSetting(key string, usage string, field func(config *Config) *int64) setting {
+	return setting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		number, err := strconv.ParseInt(value, 10, 64)
+		*field(config) = number
+		return err
+	}}
+}
+
+func boolSetting(key string, usage string, field func(config *Config) *bool) setting {
+	return setting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		enabled, err := strconv.ParseBool(value)
+		*field(config) = enabled
+		return err
+	}}
+}
+
+func durationSetting(key string, usage string, field func(config *Config) *time.Duration) setting {
+	return setting{key: key, usage: usage, apply: func(config *Config, value string) error {
+		duration, err := time.ParseDuration(value)
+		*field(config) = duration
+		return err
+	}}
+}
+
+// flagName returns the name of the flag of a setting
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Loader reads the configuration from the defaults, a file, the environment and the flags of a command
+// The flags take precedence over the environment, which takes precedence over the file
+type Loader struct {
+	flags     *flag.FlagSet
+	file      *string
+	values    map[string]*string
+	lookupEnv func(key string) (string, bool)
+}
+
+// NewLoader adds the flags of the configuration to the flags of a command
+func NewLoader(flags *flag.FlagSet) *Loader {
+	loader := &Loader{
+		flags:     flags,
+		file:      flags.String("config", "", "JSON configuration file, or "+FileVariable),
+		values:    map[string]*string{},
+		lookupEnv: os.LookupEnv,
+	}
+	for _, setting := range settings() {
+		loader.values[setting.key] = flags.String(flagName(setting.key), "", setting.usage+", or "+setting.key)
+	}
+	return loader
+}
+
+// Load reads and validates the configuration, once the flags of the command are parsed
+func (loader *Loader) Load() (*Config, error) {
+	values := map[string]string{}
+
+	path := *loader.file
+	if path == "" {
+		path, _ = loader.lookupEnv(FileVariable)
+	}
+	if path != "" {
+		err := readFile(path, values)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, setting := range settings() {
+		value, ok := loader.lookupEnv(setting.key)
+		if ok && (value != "" || setting.allowsEmpty) {
+			values[setting.key] = value
+		}
+	}
+	loader.flags.Visit(func(set *flag.Flag) {
+		for _, setting := range settings() {
+			if flagName(setting.key) == set.Name {
+				values[setting.key] = *loader.values[setting.key]
+			}
+		}
+	})
+
+	config := Default()
+	for _, setting := range settings() {
+		value, ok := values[setting.key]
+		if !ok || (value == "" && !setting.allowsEmpty) {
+			continue
+		}
+		err := setting.apply(config, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s=%q: %v", ErrorInvalidSetting, setting.key, value, err)
+		}
+	}
+	return config, config.Validate()
+}
+
+// readFile reads the settings of a configuration file, a JSON object of the settings by key
+// The values are strings, numbers or booleans
+func readFile(path string, values map[string]string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fields := map[string]interface{}{}
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	err = decoder.Decode(&fields)
+	if err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	for _, setting := range settings() {
+		known[setting.key] = true
+	}
+	for key, value := range fields {
+		if !known[key] {
+			return fmt.Errorf("%w: %s in %s", ErrorUnknownSetting, key, path)
+		}
+		switch value := value.(type) {
+		case string:
+			values[key] = value
+		case json.Number:
+			values[key] = value.String()
+		case bool:
+			values[key] = strconv.FormatBool(value)
+		default:
+			return fmt.Errorf("%w: %s in %s must be a string, a number or a boolean", ErrorInvalidSetting, key, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorAttachmentNotFound : Attachment specific errors
+var ErrorAttachmentNotFound = fmt.Errorf("attachment not found")
+
+// ErrorAttachmentTooLarge : The attachment is bigger than the allowed size
+var ErrorAttachmentTooLarge = fmt.Errorf("attachment too large")
+
+// ErrorAttachmentType : The content type of the attachment is not allowed
+var ErrorAttachmentType = fmt.Errorf("attachment content type not allowed")
+
+// ErrorAttachmentUnavailable : The attachment can't be linked to the message
+var ErrorAttachmentUnavailable = fmt.Errorf("attachment unavailable")
+
+// ErrorAttachmentLinked : The attachment is already linked to another message
+var ErrorAttachmentLinked = fmt.Errorf("attachment already linked to a message")
+
+// MaxAttachmentSize is the maximum size of an attachment in bytes
+const MaxAttachmentSize = 8 << 20
+
+// MaxAttachmentsPerMessage is the maximum number of attachments in a single message
+const MaxAttachmentsPerMessage = 4
+
+// AllowedAttachmentTypes are the sniffed content types accepted for attachments
+// Arbitrary binary data is rejected, replay files must be uploaded as archives
+var AllowedAttachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/zip",
+	"application/x-gzip",
+	"text/plain; charset=utf-8",
+}
+
+// Attachment defines the structure for an API attachment.
+// The content of the attachment is kept in a blob store, only its metadata is stored with the messages
+type Attachment struct {
+	ID          string    `json:"id" bson:"_id" validate:"required"`
+	UserID      string    `json:"user_id" bson:"user_id"`
+	MessageID   string    `json:"message_id,omitempty" bson:"message_id,omitempty"`
+	FileName    string    `json:"file_name" bson:"file_name"`
+	ContentType string    `json:"content_type" bson:"content_type"`
+	Size        int64     `json:"size" bson:"size"`
+	CreatedOn   time.Time `json:"created_on"`
+}
+
+// Attachments is a collection of Attachment
+type Attachments []*Attachment
+
+// IsAllowedAttachmentType returns true when attachments of the content type are accepted
+func IsAllowedAttachmentType(contentType string) bool {
+	for _, allowed := range AllowedAttachmentTypes {
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
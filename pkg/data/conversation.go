@@ -2,6 +2,9 @@ package data
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
 // ErrorConversationNotFound : Conversation specific errors
@@ -10,14 +13,300 @@ var ErrorConversationNotFound = fmt.Errorf("conversation not found")
 // ErrorGameNotFound : Game specific errors
 var ErrorGameNotFound = fmt.Errorf("game not found")
 
+// ErrorConversationType : The operation is not allowed for the type of the conversation
+var ErrorConversationType = fmt.Errorf("operation not allowed for this conversation type")
+
+// ErrorConversationFull : The conversation has reached its maximum size
+var ErrorConversationFull = fmt.Errorf("conversation is full")
+
+// ErrorConversationConflict : The conversation was changed since it was read
+var ErrorConversationConflict = fmt.Errorf("conversation was modified by another request")
+
+// ErrorConversationExists : A conversation with the same ID already exists
+var ErrorConversationExists = fmt.Errorf("conversation already exists")
+
+// ErrorDirectConversationExists : A direct conversation already exists for the pair of users
+var ErrorDirectConversationExists = fmt.Errorf("direct conversation already exists for these users")
+
+// ErrorConversationArchived : The conversation is archived and read-only
+var ErrorConversationArchived = fmt.Errorf("conversation is archived")
+
+// ErrorChannelNotFound : The user has no conversation for the channel in the game
+var ErrorChannelNotFound = fmt.Errorf("channel not found")
+
+// ErrorPinLimit : The conversation has reached its maximum number of pinned messages
+var ErrorPinLimit = fmt.Errorf("too many pinned messages")
+
+// Channels of the conversations of a game
+// Every player of a game sees the all channel, the team channel is only visible to the members of the team
+// The spectator channel is only visible to the spectators of a match
+const (
+	ChannelAll       = "all"
+	ChannelTeam      = "team"
+	ChannelSpectator = "spectator"
+)
+
+// Conversation types
+// Lobby, team, match and spectator conversations are bound to a game and managed by the game server
+const (
+	ConversationTypeDirect = "direct"
+	ConversationTypeGroup  = "group"
+	ConversationTypeLobby  = "lobby"
+	ConversationTypeTeam   = "team"
+	ConversationTypeMatch  = "match"
+	// ConversationTypeSpectator is the conversation of the spectators of a match
+	ConversationTypeSpectator = "spectator"
+)
+
+// MaxGroupSize is the maximum number of members of a group conversation
+const MaxGroupSize = 50
+
+// Limits of the metadata of a conversation
+const (
+	MaxTitleLength          = 100
+	MaxTopicLength          = 500
+	MaxAttributes           = 20
+	MaxAttributeKeyLength   = 64
+	MaxAttributeValueLength = 256
+)
+
+// MaxPins is the maximum number of pinned messages of a conversation
+const MaxPins = 25
+
 // Conversation defines the structure for an API conversation.
+// DirectKey identifies the pair of users of a direct conversation, so there is only one per pair
+// Team is the name of the team of a team conversation
+// PinnedMessageID holds the pinned messages, in the order they were pinned
+// Archived conversations are read-only and hidden from the default listing
+// Version is incremented on every update, so concurrent updates of the same version are rejected
+// MessageTTL is the default lifetime of the messages of the conversation in seconds, 0 keeps them
 type Conversation struct {
-	ID        string   `json:"id" bson:"_id"`
-	UserID    []string `json:"user_id" validate:"required"`
-	GameID    string   `json:"game_id"`
-	CreatedOn string   `json:"created_on"`
-	UpdatedOn string   `json:"updated_on"`
+	ID              string            `json:"id" bson:"_id"`
+	Type            string            `json:"type" bson:"type,omitempty" validate:"omitempty,oneof=direct group lobby team match spectator"`
+	UserID          []string          `json:"user_id" validate:"required"`
+	GameID          string            `json:"game_id"`
+	DirectKey       string            `json:"-" bson:"direct_key,omitempty"`
+	Team            string            `json:"team,omitempty" bson:"team,omitempty"`
+	Title           string            `json:"title,omitempty" bson:"title,omitempty" validate:"max=100"`
+	Topic           string            `json:"topic,omitempty" bson:"topic,omitempty" validate:"max=500"`
+	AvatarURL       string            `json:"avatar_url,omitempty" bson:"avatar_url,omitempty" validate:"omitempty,url,max=2048"`
+	Attributes      map[string]string `json:"attributes,omitempty" bson:"attributes,omitempty" validate:"max=20,dive,keys,required,max=64,endkeys,max=256"`
+	Roles           map[string]string `json:"roles,omitempty" bson:"roles,omitempty"`
+	MutedUserID     []string          `json:"muted_user_id,omitempty" bson:"muted_user_id,omitempty"`
+	PinnedMessageID []string          `json:"pinned_message_id,omitempty" bson:"pinned_message_id,omitempty" validate:"max=25"`
+	Archived        bool              `json:"archived,omitempty" bson:"archived,omitempty"`
+	ArchivedOn      *time.Time        `json:"archived_on,omitempty" bson:"archived_on,omitempty"`
+	LastMessageOn   *time.Time        `json:"last_message_on,omitempty" bson:"last_message_on,omitempty"`
+	MessageTTL      int64             `json:"message_ttl,omitempty" bson:"message_ttl,omitempty" validate:"ttl"`
+	Version         int64             `json:"version" bson:"version"`
+	CreatedOn       time.Time         `json:"created_on"`
+	UpdatedOn       time.Time         `json:"updated_on"`
+}
+
+// ConversationPatch is a partial update of the metadata of a conversation
+// Fields that are not set are left unchanged, an attribute set to null is removed
+// Version must be the version of the conversation that was read before the update
+type ConversationPatch struct {
+	Title      *string            `json:"title"`
+	Topic      *string            `json:"topic"`
+	AvatarURL  *string            `json:"avatar_url"`
+	Attributes map[string]*string `json:"attributes"`
+	MessageTTL *int64             `json:"message_ttl"`
+	Version    *int64             `json:"version" validate:"required"`
 }
 
 // Conversations is a collection of Conversation
 type Conversations []*Conversation
+
+// HasUser returns true when the user is a member of the conversation
+func (conversation *Conversation) HasUser(userID string) bool {
+	for _, id := range conversation.UserID {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMuted returns true when the user is muted in the conversation
+func (conversation *Conversation) IsMuted(userID string) bool {
+	for _, id := range conversation.MutedUserID {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ConversationType returns the type of the conversation
+// Conversations created before types existed are group conversations
+func (conversation *Conversation) ConversationType() string {
+	if conversation.Type == "" {
+		return ConversationTypeGroup
+	}
+	return conversation.Type
+}
+
+// IsGameBound returns true when the conversation is bound to a game and managed by the game server
+func (conversation *Conversation) IsGameBound() bool {
+	switch conversation.ConversationType() {
+	case ConversationTypeLobby, ConversationTypeTeam, ConversationTypeMatch, ConversationTypeSpectator:
+		return true
+	default:
+		return false
+	}
+}
+
+// Channel returns the channel of a game conversation, or an empty string when the conversation is not bound to a game
+func (conversation *Conversation) Channel() string {
+	switch conversation.ConversationType() {
+	case ConversationTypeLobby, ConversationTypeMatch:
+		return ChannelAll
+	case ConversationTypeTeam:
+		return ChannelTeam
+	case ConversationTypeSpectator:
+		return ChannelSpectator
+	default:
+		return ""
+	}
+}
+
+// VisibleTo returns the conversations the user is a member of
+func (conversations Conversations) VisibleTo(userID string) Conversations {
+	visible := Conversations{}
+	for _, conversation := range conversations {
+		if conversation.HasUser(userID) {
+			visible = append(visible, conversation)
+		}
+	}
+	return visible
+}
+
+// ChannelConversation returns the conversation of the channel of a game the user is a member of
+// Active conversations are preferred to archived ones, an empty user ID is a service that sees every conversation
+func (conversations Conversations) ChannelConversation(userID string, channel string) (*Conversation, error) {
+	var archived *Conversation
+	for _, conversation := range conversations {
+		if conversation.Channel() != channel || (userID != "" && !conversation.HasUser(userID)) {
+			continue
+		}
+		if !conversation.Archived {
+			return conversation, nil
+		}
+		archived = conversation
+	}
+	if archived == nil {
+		return nil, ErrorChannelNotFound
+	}
+	return archived, nil
+}
+
+// ValidateMembers checks the members against the rules of the type of the conversation
+func (conversation *Conversation) ValidateMembers(userIDs []string) error {
+	switch conversation.ConversationType() {
+	case ConversationTypeDirect:
+		if len(userIDs) != 2 || userIDs[0] == userIDs[1] {
+			return ErrorConversationType
+		}
+	case ConversationTypeGroup:
+		if len(userIDs) > MaxGroupSize {
+			return ErrorConversationFull
+		}
+	default:
+		if conversation.GameID == "" {
+			return ErrorGameNotFound
+		}
+	}
+	return nil
+}
+
+// ValidateMemberChange checks the new members of an existing conversation
+// The members of a direct conversation identify it, so they can't change
+func (conversation *Conversation) ValidateMemberChange(userIDs []string) error {
+	if conversation.ConversationType() == ConversationTypeDirect && NewDirectKey(userIDs) != NewDirectKey(conversation.UserID) {
+		return ErrorConversationType
+	}
+	return conversation.ValidateMembers(userIDs)
+}
+
+// NewDirectKey returns the key identifying the direct conversation between two users
+func NewDirectKey(userIDs []string) string {
+	sorted := append([]string{}, userIDs...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ":")
+}
+
+// ApplyPatch updates the metadata of the conversation, the result must be validated
+func (conversation *Conversation) ApplyPatch(patch *ConversationPatch) {
+	if patch.Title != nil {
+		conversation.Title = *patch.Title
+	}
+	if patch.Topic != nil {
+		conversation.Topic = *patch.Topic
+	}
+	if patch.AvatarURL != nil {
+		conversation.AvatarURL = *patch.AvatarURL
+	}
+	if patch.MessageTTL != nil {
+		conversation.MessageTTL = *patch.MessageTTL
+	}
+	for key, value := range patch.Attributes {
+		if value == nil {
+			delete(conversation.Attributes, key)
+			continue
+		}
+		if conversation.Attributes == nil {
+			conversation.Attributes = map[string]string{}
+		}
+		conversation.Attributes[key] = *value
+	}
+}
+
+// IsPinned returns true when the message is pinned in the conversation
+func (conversation *Conversation) IsPinned(messageID string) bool {
+	for _, id := range conversation.PinnedMessageID {
+		if id == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// Pin adds the message at the end of the pinned messages, pinning a message twice has no effect
+func (conversation *Conversation) Pin(messageID string) error {
+	if conversation.IsPinned(messageID) {
+		return nil
+	}
+	if len(conversation.PinnedMessageID) >= MaxPins {
+		return ErrorPinLimit
+	}
+	conversation.PinnedMessageID = append(conversation.PinnedMessageID, messageID)
+	return nil
+}
+
+// Unpin removes the message from the pinned messages, it returns false when the message wasn't pinned
+func (conversation *Conversation) Unpin(messageID string) bool {
+	for i, id := range conversation.PinnedMessageID {
+		if id == messageID {
+			conversation.PinnedMessageID = append(conversation.PinnedMessageID[:i], conversation.PinnedMessageID[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Archive makes the conversation read-only
+func (conversation *Conversation) Archive() {
+	if conversation.Archived {
+		return
+	}
+	archivedOn := time.Now().UTC()
+	conversation.Archived = true
+	conversation.ArchivedOn = &archivedOn
+}
+
+// Restore makes an archived conversation writable again
+func (conversation *Conversation) Restore() {
+	conversation.Archived = false
+	conversation.ArchivedOn = nil
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// ErrorMatchNotFound : Match specific errors
+var ErrorMatchNotFound = fmt.Errorf("match not found")
+
+// ErrorTeamNotFound : The team doesn't exist in the match
+var ErrorTeamNotFound = fmt.Errorf("team not found")
+
+// matchNamespace is the namespace of the IDs of the match conversations
+var matchNamespace = uuid.MustParse("5b0d1c3e-8f3a-4d52-9a57-3c0e7f1b2a64")
+
+// MatchRoster is the list of players of each team of a match
+// Spectators only see the all-chat of the match
+type MatchRoster struct {
+	MatchID    string              `json:"match_id" validate:"required"`
+	Teams      map[string][]string `json:"teams" validate:"required,min=1,dive,keys,required,max=64,endkeys,required,dive,required"`
+	Spectators []string            `json:"spectators,omitempty" validate:"dive,required"`
+}
+
+// MatchPlayer is a player joining a team of a match, or a spectator of the match
+type MatchPlayer struct {
+	UserID    string `json:"user_id" validate:"required"`
+	Team      string `json:"team" validate:"required_without=Spectator"`
+	Spectator bool   `json:"spectator,omitempty"`
+}
+
+// Match holds the conversations of a match
+// The all-chat is visible to every player, each team has its own conversation
+// Spectators see the all-chat with a delay and talk in the spectator chat
+type Match struct {
+	MatchID         string            `json:"match_id"`
+	AllChatID       string            `json:"all_chat_id"`
+	TeamChatID      map[string]string `json:"team_chat_id"`
+	SpectatorChatID string            `json:"spectator_chat_id,omitempty"`
+	Ended           bool              `json:"ended"`
+}
+
+// MatchTranscript holds the messages of the conversations of an ended match, by conversation ID
+type MatchTranscript struct {
+	Match      *Match              `json:"match"`
+	Transcript map[string]Messages `json:"transcript,omitempty"`
+}
+
+// MatchConversationID returns the ID of a conversation of the match, the all-chat has no team
+// The IDs are derived from the match, so creating the conversations of a match is idempotent
+func MatchConversationID(matchID string, team string) string {
+	return uuid.NewSHA1(matchNamespace, []byte(matchID+"/"+team)).String()
+}
+
+// SpectatorConversationID returns the ID of the spectator conversation of the match
+func SpectatorConversationID(matchID string) string {
+	return uuid.NewSHA1(matchNamespace, []byte(matchID+"#spectator")).String()
+}
+
+// Players returns every player of the roster, without duplicates, ordered by team
+func (roster *MatchRoster) Players() []string {
+	var players []string
+	seen := make(map[string]bool)
+	for _, team := range roster.TeamNames() {
+		for _, userID := range roster.Teams[team] {
+			if !seen[userID] {
+				seen[userID] = true
+				players = append(players, userID)
+			}
+		}
+	}
+	return players
+}
+
+// TeamNames returns the names of the teams of the roster in alphabetical order
+func (roster *MatchRoster) TeamNames() []string {
+	teams := make([]string, 0, len(roster.Teams))
+	for team := range roster.Teams {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	return teams
+}
+
+// NewMatch returns the match of the conversations of a game
+func NewMatch(matchID string, conversations Conversations) *Match {
+	match := &Match{MatchID: matchID, TeamChatID: map[string]string{}}
+	for _, conversation := range conversations {
+		switch conversation.ID {
+		case MatchConversationID(matchID, ""):
+			match.AllChatID = conversation.ID
+			match.Ended = conversation.Archived
+		case SpectatorConversationID(matchID):
+			match.SpectatorChatID = conversation.ID
+		case MatchConversationID(matchID, conversation.Team):
+			match.TeamChatID[conversation.Team] = conversation.ID
+		}
+	}
+	return match
+}
+
+// ConversationIDs returns the IDs of every conversation of the match, starting with the all-chat
+func (match *Match) ConversationIDs() []string {
+	ids := []string{match.AllChatID}
+	teams := make([]string, 0, len(match.TeamChatID))
+	for team := range match.TeamChatID {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		ids = append(ids, match.TeamChatID[team])
+	}
+	if match.SpectatorChatID != "" {
+		ids = append(ids, match.SpectatorChatID)
+	}
+	return ids
+}
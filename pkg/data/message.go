@@ -2,25 +2,274 @@ package data
 
 import (
 	"fmt"
+	"time"
 )
 
 // ErrorMessageNotFound : Message specific errors
 var ErrorMessageNotFound = fmt.Errorf("message not found")
 
+// ErrorMessageExists : Message already sent with the same ID
+var ErrorMessageExists = fmt.Errorf("message already exists")
+
 // ErrorUserNotFound : User specific errors
 var ErrorUserNotFound = fmt.Errorf("user not found")
 
+// ErrorUserMuted : User is muted in the conversation
+var ErrorUserMuted = fmt.Errorf("user is muted in this conversation")
+
+// ErrorMessageKind : Message kind specific errors
+var ErrorMessageKind = fmt.Errorf("message kind not allowed")
+
+// Message kinds
+const (
+	MessageKindUser   = "user"
+	MessageKindSystem = "system"
+	MessageKindBot    = "bot"
+)
+
+// Sender types for messages that are not sent by a user
+const (
+	SenderTypeService = "service"
+	SenderTypeBot     = "bot"
+)
+
+// ServiceSenderID is the sender identity of the messages posted by this microservice
+const ServiceSenderID = "microservice-text-chat"
+
+// ErasedUserID replaces the identity of an erased user in the messages that are kept
+const ErasedUserID = "deleted-user"
+
+// Render styles used by the clients to display a message
+const (
+	RenderStyleDefault = "default"
+	RenderStyleSystem  = "system"
+	RenderStyleBot     = "bot"
+	RenderStyleEmote   = "emote"
+)
+
+// MaxMessageTTL is the longest lifetime of an ephemeral message, in seconds
+const MaxMessageTTL = 30 * 24 * 60 * 60
+
 // Message defines the structure for an API message.
+// A message of a game can be sent to a channel of the game instead of a conversation
+// An ephemeral message disappears at ExpiresAt, set from its TTL in seconds or from the message TTL of the conversation
+// A message with a future SendAt is scheduled instead of being sent
+// A tombstone is a message whose content was removed, DeletedOn is the time of the removal
 type Message struct {
-	ID             string `json:"id" bson:"_id"`
-	UserID         string `json:"user_id" validate:"required"`
-	ConversationID string `json:"conversation_id" bson:"conversation_id" validate:"required"`
-	Text           string `json:"text" validate:"required"`
-	CreatedOn      string `json:"created_on"`
-	UpdatedOn      string `json:"updated_on"`
+	ID             string          `json:"id" bson:"_id"`
+	UserID         string          `json:"user_id" validate:"required_without=Sender"`
+	ConversationID string          `json:"conversation_id" bson:"conversation_id" validate:"required_without=GameID"`
+	GameID         string          `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	Channel        string          `json:"channel,omitempty" bson:"channel,omitempty" validate:"required_with=GameID,omitempty,oneof=all team spectator"`
+	Text           string          `json:"text" validate:"required"`
+	Kind           string          `json:"kind" bson:"kind" validate:"omitempty,oneof=user system bot"`
+	Sender         *Sender         `json:"sender,omitempty" bson:"sender,omitempty"`
+	Render         *RenderMetadata `json:"render,omitempty" bson:"render,omitempty"`
+	Entities       []Entity        `json:"entities,omitempty" bson:"entities,omitempty"`
+	Attachments    []*Attachment   `json:"attachments,omitempty" bson:"attachments,omitempty" validate:"max=4,dive"`
+	Previews       []*Preview      `json:"previews,omitempty" bson:"previews,omitempty"`
+	Mentions       []Mention       `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	// MentionedUserID holds every user notified by the mentions of the message
+	MentionedUserID []string   `json:"mentioned_user_id,omitempty" bson:"mentioned_user_id,omitempty"`
+	TTL             int64      `json:"ttl,omitempty" bson:"-" validate:"ttl"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	SendAt          *time.Time `json:"send_at,omitempty" bson:"-"`
+	DeletedOn       *time.Time `json:"deleted_on,omitempty" bson:"deleted_on,omitempty"`
+	CreatedOn       time.Time  `json:"created_on"`
+	UpdatedOn       time.Time  `json:"updated_on"`
+}
+
+// Sender is the identity of a service account (game server, bot, integration)
+// that posts messages without being a member user of the conversation.
+type Sender struct {
+	ID          string `json:"id" bson:"id" validate:"required"`
+	Type        string `json:"type" bson:"type" validate:"required,oneof=service bot"`
+	DisplayName string `json:"display_name" bson:"display_name"`
+}
+
+// Entity types of the rich text content of a message
+const (
+	EntityTypeBold    = "bold"
+	EntityTypeItalic  = "italic"
+	EntityTypeCode    = "code"
+	EntityTypeSpoiler = "spoiler"
+	EntityTypeLink    = "link"
+)
+
+// Entity is a formatting entity in the text of a message
+// Offset and Length are counted in characters (unicode code points) of the text
+type Entity struct {
+	Type   string `json:"type" bson:"type"`
+	Offset int    `json:"offset" bson:"offset"`
+	Length int    `json:"length" bson:"length"`
+	URL    string `json:"url,omitempty" bson:"url,omitempty"`
+}
+
+// Mention types
+const (
+	MentionTypeUser     = "user"
+	MentionTypeEveryone = "everyone"
+	MentionTypeTeam     = "team"
+)
+
+// Mention is a mention entity in the text of a message
+// Offset and Length are counted in characters (unicode code points) of the text
+type Mention struct {
+	Offset int    `json:"offset" bson:"offset"`
+	Length int    `json:"length" bson:"length"`
+	Type   string `json:"type" bson:"type"`
+	UserID string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+}
+
+// RenderMetadata tells the clients how a message should be displayed
+type RenderMetadata struct {
+	Style string `json:"style" bson:"style"`
+	Badge string `json:"badge,omitempty" bson:"badge,omitempty"`
 }
 
 // Messages is a collection of Message
 type Messages []*Message
 
-const MicroserviceUserPath = "http://microservice-user:9090"
+// CreatedBefore returns the messages created before the time, in the same order
+func (messages Messages) CreatedBefore(before time.Time) Messages {
+	result := Messages{}
+	for _, message := range messages {
+		if message.CreatedOn.Before(before) {
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+// SetExpiry sets the expiration of an ephemeral message created at the given time
+// An expiration already set is kept, otherwise the TTL of the message is used before the default TTL of its conversation
+func (message *Message) SetExpiry(createdOn time.Time, defaultTTL int64) {
+	if message.ExpiresAt != nil {
+		return
+	}
+	ttl := message.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	expiresAt := createdOn.Add(time.Duration(ttl) * time.Second)
+	message.ExpiresAt = &expiresAt
+}
+
+// IsExpired returns true when the message is ephemeral and expired at the given time
+func (message *Message) IsExpired(now time.Time) bool {
+	return message.ExpiresAt != nil && !message.ExpiresAt.After(now)
+}
+
+// Unexpired returns the messages not expired at the given time, in the same order
+func (messages Messages) Unexpired(now time.Time) Messages {
+	result := Messages{}
+	for _, message := range messages {
+		if !message.IsExpired(now) {
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+// MessageKind returns the kind of the message, messages stored without kind are user messages
+func (message *Message) MessageKind() string {
+	if message.Kind == "" {
+		return MessageKindUser
+	}
+	return message.Kind
+}
+
+// IsTombstone returns true when the content of the message was removed
+func (message *Message) IsTombstone() bool {
+	return message.DeletedOn != nil
+}
+
+// Tombstone removes the content of the message, the message is kept in the conversation as a placeholder
+func (message *Message) Tombstone(deletedOn time.Time) {
+	message.Text = ""
+	message.Entities = nil
+	message.Attachments = nil
+	message.Previews = nil
+	message.Mentions = nil
+	message.MentionedUserID = nil
+	deletedOn = deletedOn.UTC()
+	message.DeletedOn = &deletedOn
+	message.UpdatedOn = deletedOn
+}
+
+// RemoveMentionsOf removes the user from the notified users of the message, the user mentions are kept as mentions of an erased user
+// It returns false when the message didn't mention the user
+func (message *Message) RemoveMentionsOf(userID string) bool {
+	removed := false
+	mentioned := message.MentionedUserID[:0]
+	for _, id := range message.MentionedUserID {
+		if id == userID {
+			removed = true
+			continue
+		}
+		mentioned = append(mentioned, id)
+	}
+	message.MentionedUserID = mentioned
+	for i := range message.Mentions {
+		if message.Mentions[i].UserID == userID {
+			message.Mentions[i].UserID = ErasedUserID
+			removed = true
+		}
+	}
+	return removed
+}
+
+// IsUserMessage returns true when the message was sent by a member user
+func (message *Message) IsUserMessage() bool {
+	return message.Kind == "" || message.Kind == MessageKindUser
+}
+
+// SetRenderMetadata fills the rendering metadata of the message from its kind and sender
+func (message *Message) SetRenderMetadata() {
+	switch message.Kind {
+	case MessageKindSystem:
+		message.Render = &RenderMetadata{Style: RenderStyleSystem}
+		if message.Sender != nil {
+			message.Render.Badge = message.Sender.DisplayName
+		}
+	case MessageKindBot:
+		message.Render = &RenderMetadata{Style: RenderStyleBot, Badge: "BOT"}
+	default:
+		// Emotes are user messages created by the /me command
+		if message.Render != nil && message.Render.Style == RenderStyleEmote {
+			message.Render = &RenderMetadata{Style: RenderStyleEmote}
+			return
+		}
+		message.Render = &RenderMetadata{Style: RenderStyleDefault}
+	}
+}
+
+// NewSystemMessage creates a system message posted by this microservice in a conversation
+func NewSystemMessage(conversationID string, text string) *Message {
+	return &Message{
+		ConversationID: conversationID,
+		Text:           text,
+		Kind:           MessageKindSystem,
+		Sender: &Sender{
+			ID:          ServiceSenderID,
+			Type:        SenderTypeService,
+			DisplayName: "Chat",
+		},
+	}
+}
+
+// LinkURLs returns the URLs of the link entities of the message, without duplicates
+func (message *Message) LinkURLs() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, entity := range message.Entities {
+		if entity.Type == EntityTypeLink && !seen[entity.URL] {
+			seen[entity.URL] = true
+			urls = append(urls, entity.URL)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,10 @@
+package data
+
+// Preview is the card displayed for a URL contained in a message
+type Preview struct {
+	URL         string `json:"url" bson:"url"`
+	Title       string `json:"title,omitempty" bson:"title,omitempty"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty" bson:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty" bson:"site_name,omitempty"`
+}
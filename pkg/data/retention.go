@@ -0,0 +1,33 @@
+package data
+
+import (
+	"time"
+)
+
+// RetentionQuery selects the messages of the conversations created before the retention limit
+// An empty Kind selects every kind of message, tombstones are never selected
+type RetentionQuery struct {
+	ConversationID []string
+	Kind           string
+	CreatedBefore  time.Time
+}
+
+// Matches returns true when the message is selected by the query
+func (query *RetentionQuery) Matches(message *Message) bool {
+	if message.IsTombstone() || !containsID(query.ConversationID, message.ConversationID) {
+		return false
+	}
+	if query.Kind != "" && query.Kind != message.MessageKind() {
+		return false
+	}
+	return message.CreatedOn.Before(query.CreatedBefore)
+}
+
+func containsID(ids []string, id string) bool {
+	for _, value := range ids {
+		if value == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorPermissionDenied : The user doesn't have the permission for the operation
+var ErrorPermissionDenied = fmt.Errorf("permission denied")
+
+// ErrorInvalidRole : Role specific errors
+var ErrorInvalidRole = fmt.Errorf("invalid role")
+
+// Roles of the members of a conversation
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleReadOnly  = "read-only"
+	RoleSpectator = "spectator"
+)
+
+// DefaultSpectatorDelay is the delay of the messages of match conversations seen by spectators
+const DefaultSpectatorDelay = 30 * time.Second
+
+// Permission is an operation on a conversation that depends on the role of the member
+type Permission string
+
+// Permissions checked against the role of the members
+const (
+	PermissionSendMessage  Permission = "send_message"
+	PermissionAddMember    Permission = "add_member"
+	PermissionRemoveMember Permission = "remove_member"
+	PermissionRename       Permission = "rename"
+	PermissionPin          Permission = "pin"
+	PermissionModerate     Permission = "moderate"
+	PermissionManageRoles  Permission = "manage_roles"
+	PermissionArchive      Permission = "archive"
+	PermissionDelete       Permission = "delete"
+)
+
+// permissionMatrix holds the permissions granted to each role
+var permissionMatrix = map[string][]Permission{
+	RoleOwner: {
+		PermissionSendMessage, PermissionAddMember, PermissionRemoveMember, PermissionRename,
+		PermissionPin, PermissionModerate, PermissionManageRoles, PermissionArchive, PermissionDelete,
+	},
+	RoleModerator: {
+		PermissionSendMessage, PermissionAddMember, PermissionRemoveMember, PermissionRename,
+		PermissionPin, PermissionModerate,
+	},
+	RoleMember: {
+		PermissionSendMessage, PermissionAddMember,
+	},
+	RoleReadOnly: {},
+	// Spectators can't write in the conversations of the players and see them with a delay
+	RoleSpectator: {},
+}
+
+// RoleChange is the role given to a member of a conversation
+type RoleChange struct {
+	UserID string `json:"user_id" validate:"required"`
+	Role   string `json:"role" validate:"omitempty,oneof=owner moderator member read-only spectator"`
+}
+
+// IsValidRole returns true when the role exists
+func IsValidRole(role string) bool {
+	_, ok := permissionMatrix[role]
+	return ok
+}
+
+// RoleOf returns the role of the user in the conversation, or an empty string when the user is not a member
+// Conversations created before roles existed are owned by their first member
+func (conversation *Conversation) RoleOf(userID string) string {
+	if !conversation.HasUser(userID) {
+		return ""
+	}
+	if role, ok := conversation.Roles[userID]; ok {
+		return role
+	}
+	if conversation.Owner() == "" && conversation.ConversationType() == ConversationTypeGroup && conversation.UserID[0] == userID {
+		return RoleOwner
+	}
+	return RoleMember
+}
+
+// Can returns true when the role of the user in the conversation grants the permission
+func (conversation *Conversation) Can(userID string, permission Permission) bool {
+	for _, granted := range permissionMatrix[conversation.RoleOf(userID)] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the owner of the conversation, or an empty string when it has no owner
+func (conversation *Conversation) Owner() string {
+	for userID, role := range conversation.Roles {
+		if role == RoleOwner {
+			return userID
+		}
+	}
+	return ""
+}
+
+// InitRoles makes the user the owner of a new group conversation
+// Direct and game conversations have no owner, all their members are equal
+func (conversation *Conversation) InitRoles(ownerID string) {
+	conversation.Roles = map[string]string{}
+	if conversation.ConversationType() != ConversationTypeGroup || len(conversation.UserID) == 0 {
+		return
+	}
+	if !conversation.HasUser(ownerID) {
+		ownerID = conversation.UserID[0]
+	}
+	conversation.Roles[ownerID] = RoleOwner
+}
+
+// SetRole changes the role of a member, ownership is changed with TransferOwnership
+func (conversation *Conversation) SetRole(userID string, role string) error {
+	if !conversation.HasUser(userID) {
+		return ErrorUserNotFound
+	}
+	if !IsValidRole(role) || role == RoleOwner || conversation.RoleOf(userID) == RoleOwner {
+		return ErrorInvalidRole
+	}
+	conversation.ensureRoles()
+	conversation.Roles[userID] = role
+	return nil
+}
+
+// TransferOwnership makes the member the new owner, the previous owner becomes a moderator
+func (conversation *Conversation) TransferOwnership(userID string) error {
+	if !conversation.HasUser(userID) {
+		return ErrorUserNotFound
+	}
+	conversation.ensureRoles()
+	for _, memberID := range conversation.UserID {
+		if conversation.RoleOf(memberID) == RoleOwner {
+			conversation.Roles[memberID] = RoleModerator
+		}
+	}
+	conversation.Roles[userID] = RoleOwner
+	return nil
+}
+
+// SetSpectator gives the spectator role to a member, or gives it back the default role
+func (conversation *Conversation) SetSpectator(userID string, spectator bool) {
+	conversation.ensureRoles()
+	if spectator {
+		conversation.Roles[userID] = RoleSpectator
+		return
+	}
+	delete(conversation.Roles, userID)
+}
+
+// SetMembers replaces the members of the conversation and keeps the roles consistent
+// Roles of removed members are dropped and a new owner is chosen when the owner leaves
+func (conversation *Conversation) SetMembers(userIDs []string) {
+	conversation.ensureRoles()
+	hadOwner := conversation.owner() != ""
+
+	conversation.UserID = append([]string{}, userIDs...)
+	for userID := range conversation.Roles {
+		if !conversation.HasUser(userID) {
+			delete(conversation.Roles, userID)
+		}
+	}
+
+	if hadOwner && conversation.Owner() == "" {
+		conversation.reassignOwner()
+	}
+}
+
+// EraseMember removes every reference to an erased user from the conversation
+// The direct key of a direct conversation is made of the user IDs of its members, so it is cleared as well
+func (conversation *Conversation) EraseMember(userID string) {
+	members := []string{}
+	for _, id := range conversation.UserID {
+		if id != userID {
+			members = append(members, id)
+		}
+	}
+	conversation.SetMembers(members)
+
+	muted := []string(nil)
+	for _, id := range conversation.MutedUserID {
+		if id != userID {
+			muted = append(muted, id)
+		}
+	}
+	conversation.MutedUserID = muted
+
+	if conversation.ConversationType() == ConversationTypeDirect {
+		conversation.DirectKey = ""
+	}
+}
+
+// reassignOwner promotes the first moderator, or else the first member that is not read-only
+func (conversation *Conversation) reassignOwner() {
+	for _, candidateRole := range []string{RoleModerator, RoleMember} {
+		for _, userID := range conversation.UserID {
+			if conversation.RoleOf(userID) == candidateRole {
+				conversation.Roles[userID] = RoleOwner
+				return
+			}
+		}
+	}
+}
+
+// ensureRoles stores the implicit owner of conversations created before roles existed
+func (conversation *Conversation) ensureRoles() {
+	owner := conversation.owner()
+	if conversation.Roles == nil {
+		conversation.Roles = map[string]string{}
+	}
+	if owner != "" {
+		conversation.Roles[owner] = RoleOwner
+	}
+}
+
+// owner returns the explicit or implicit owner of the conversation
+func (conversation *Conversation) owner() string {
+	for _, userID := range conversation.UserID {
+		if conversation.RoleOf(userID) == RoleOwner {
+			return userID
+		}
+	}
+	return ""
+}
+
+// validateRoles checks that the roles are valid roles of members, with at most one owner
+func (conversation *Conversation) validateRoles() error {
+	owners := 0
+	for userID, role := range conversation.Roles {
+		if !IsValidRole(role) || !conversation.HasUser(userID) {
+			return ErrorInvalidRole
+		}
+		if role == RoleOwner {
+			owners++
+		}
+	}
+	if owners > 1 {
+		return ErrorInvalidRole
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrorScheduledMessageNotFound : Scheduled message specific errors
+var ErrorScheduledMessageNotFound = fmt.Errorf("scheduled message not found")
+
+// Statuses of a scheduled message
+const (
+	ScheduleStatusPending   = "pending"
+	ScheduleStatusSending   = "sending"
+	ScheduleStatusSent      = "sent"
+	ScheduleStatusCancelled = "cancelled"
+	ScheduleStatusFailed    = "failed"
+)
+
+// ScheduledMessage is a message waiting to be sent at SendAt
+// The ID is also the ID of the message once sent, so a retried delivery never sends the message twice
+// ClaimedOn is set while a dispatcher is sending the message
+// TTL is the lifetime of the message once sent, it is stored apart since the TTL of a message is not stored
+type ScheduledMessage struct {
+	ID             string     `json:"id" bson:"_id"`
+	UserID         string     `json:"user_id" bson:"user_id"`
+	ConversationID string     `json:"conversation_id" bson:"conversation_id"`
+	Message        *Message   `json:"message" bson:"message"`
+	TTL            int64      `json:"-" bson:"ttl,omitempty"`
+	SendAt         time.Time  `json:"send_at" bson:"send_at"`
+	Status         string     `json:"status" bson:"status"`
+	ClaimedOn      *time.Time `json:"-" bson:"claimed_on,omitempty"`
+	Error          string     `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedOn      time.Time  `json:"created_on" bson:"created_on"`
+	UpdatedOn      time.Time  `json:"updated_on" bson:"updated_on"`
+}
+
+// ScheduledMessages is a collection of ScheduledMessage
+type ScheduledMessages []*ScheduledMessage
+
+// IsScheduled returns true when the message must be sent after the given time
+func (message *Message) IsScheduled(now time.Time) bool {
+	return message.SendAt != nil && message.SendAt.After(now)
+}
+
+// NewScheduledMessage creates a pending scheduled message sending the message at its SendAt
+func NewScheduledMessage(message *Message) *ScheduledMessage {
+	scheduled := &ScheduledMessage{
+		ID:             uuid.NewString(),
+		UserID:         message.UserID,
+		ConversationID: message.ConversationID,
+		Message:        message,
+		TTL:            message.TTL,
+		SendAt:         message.SendAt.UTC(),
+		Status:         ScheduleStatusPending,
+	}
+	message.ID = scheduled.ID
+	message.SendAt = nil
+	return scheduled
+}
@@ -1,6 +1,11 @@
 package data
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestChecksValidation(t *testing.T) {
 	message := &Message{
@@ -24,3 +29,186 @@ func TestChecksValidation(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMessageTTLValidation(t *testing.T) {
+	message := &Message{
+		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		Text:           "This is a text message",
+	}
+	conversation := &Conversation{
+		UserID: []string{"a2181017-5c53-422b-b6bc-036b27c04fc8", "e2382ea2-b5fa-4506-aa9d-d338aa52af44"},
+	}
+
+	for _, ttl := range []int64{0, MaxMessageTTL} {
+		message.TTL, conversation.MessageTTL = ttl, ttl
+		if message.ValidateMessage() != nil || conversation.ValidateConversation() != nil {
+			t.Errorf("Expected a TTL of %d to be valid", ttl)
+		}
+	}
+	for _, ttl := range []int64{-1, MaxMessageTTL + 1} {
+		message.TTL, conversation.MessageTTL = ttl, ttl
+		if message.ValidateMessage() == nil || conversation.ValidateConversation() == nil {
+			t.Errorf("Expected a TTL of %d to be invalid", ttl)
+		}
+	}
+}
+
+func TestSystemMessageValidation(t *testing.T) {
+	message := &Message{
+		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		Text:           "Player X joined",
+		Kind:           MessageKindSystem,
+		Sender:         &Sender{ID: "game-server", Type: SenderTypeService},
+	}
+
+	err := message.ValidateSystemMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message.Kind = MessageKindUser
+	if message.ValidateSystemMessage() != ErrorMessageKind {
+		t.Error("Expected user kind to be rejected for system messages")
+	}
+
+	message.Kind = MessageKindBot
+	message.Sender = nil
+	if message.ValidateSystemMessage() == nil {
+		t.Error("Expected message without user or sender to be rejected")
+	}
+}
+
+func TestConversationTypeValidation(t *testing.T) {
+	direct := &Conversation{Type: ConversationTypeDirect, UserID: []string{"a", "b", "c"}}
+	if direct.ValidateConversation() != ErrorConversationType {
+		t.Error("Expected direct conversation with 3 users to be rejected")
+	}
+
+	group := &Conversation{Type: ConversationTypeGroup, UserID: make([]string, MaxGroupSize+1)}
+	if group.ValidateConversation() != ErrorConversationFull {
+		t.Error("Expected group over the size cap to be rejected")
+	}
+
+	match := &Conversation{Type: ConversationTypeMatch, UserID: []string{"a"}}
+	if match.ValidateConversation() != ErrorGameNotFound {
+		t.Error("Expected match conversation without game to be rejected")
+	}
+	match.GameID = "game"
+	if match.ValidateConversation() != nil || !match.IsGameBound() {
+		t.Error("Expected match conversation bound to a game to be valid")
+	}
+
+	if NewDirectKey([]string{"b", "a"}) != NewDirectKey([]string{"a", "b"}) {
+		t.Error("Expected direct key to be independent of the order of the users")
+	}
+
+	direct.UserID = []string{"a", "b"}
+	if direct.ValidateMemberChange([]string{"b", "a"}) != nil {
+		t.Error("Expected the same members of a direct conversation to be valid")
+	}
+	if direct.ValidateMemberChange([]string{"a", "c"}) != ErrorConversationType {
+		t.Error("Expected the members of a direct conversation not to change")
+	}
+}
+
+func TestConversationRoles(t *testing.T) {
+	conversation := &Conversation{UserID: []string{"owner", "moderator", "member", "reader"}}
+	conversation.InitRoles("owner")
+	if err := conversation.SetRole("moderator", RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if err := conversation.SetRole("reader", RoleReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if err := conversation.SetRole("owner", RoleMember); err != ErrorInvalidRole {
+		t.Errorf("Expected the owner role to only change through a transfer, got %v", err)
+	}
+
+	if !conversation.Can("moderator", PermissionPin) || conversation.Can("moderator", PermissionDelete) {
+		t.Error("Moderator permissions don't match the permission matrix")
+	}
+	if conversation.Can("member", PermissionRemoveMember) || conversation.Can("reader", PermissionSendMessage) {
+		t.Error("Member permissions don't match the permission matrix")
+	}
+	if conversation.Can("stranger", PermissionSendMessage) {
+		t.Error("Users that are not members shouldn't have any permission")
+	}
+
+	// The first moderator becomes the owner when the owner leaves
+	conversation.SetMembers([]string{"member", "moderator", "reader"})
+	if conversation.Owner() != "moderator" {
+		t.Errorf("Expected the moderator to become the owner, got %s", conversation.Owner())
+	}
+	// Without moderators the first member that can send messages becomes the owner
+	conversation.SetMembers([]string{"reader", "member"})
+	if conversation.Owner() != "member" {
+		t.Errorf("Expected the member to become the owner, got %s", conversation.Owner())
+	}
+}
+
+func TestConversationRolesValidation(t *testing.T) {
+	conversation := &Conversation{
+		UserID: []string{"a", "b"},
+		Roles:  map[string]string{"a": RoleOwner, "b": RoleOwner},
+	}
+	if err := conversation.ValidateConversation(); err != ErrorInvalidRole {
+		t.Errorf("Expected two owners to be invalid, got %v", err)
+	}
+	conversation.Roles = map[string]string{"c": RoleMember}
+	if err := conversation.ValidateConversation(); err != ErrorInvalidRole {
+		t.Errorf("Expected roles of non members to be invalid, got %v", err)
+	}
+}
+
+func TestConversationMetadataValidation(t *testing.T) {
+	conversation := &Conversation{UserID: []string{"a", "b"}, Title: "Guild", AvatarURL: "https://cdn.ubivius.tk/avatar.png"}
+	if err := conversation.ValidateConversation(); err != nil {
+		t.Errorf("Expected valid metadata, got %v", err)
+	}
+
+	conversation.Attributes = map[string]string{}
+	for i := 0; i <= MaxAttributes; i++ {
+		conversation.Attributes[string(rune('a'+i))] = "value"
+	}
+	if err := conversation.ValidateConversation(); err == nil {
+		t.Error("Expected too many attributes to be invalid")
+	}
+
+	conversation.Attributes = map[string]string{"": "value"}
+	if err := conversation.ValidateConversation(); err == nil {
+		t.Error("Expected an empty attribute key to be invalid")
+	}
+}
+
+func TestTimestampsJSON(t *testing.T) {
+	message := &Message{}
+	err := json.Unmarshal([]byte(`{"id":"m1","text":"hi","created_on":"2020-05-01 22:01:00 +0000 UTC","updated_on":"2020-05-01T18:02:00-04:00"}`), message)
+	if err != nil {
+		t.Fatalf("Expected the legacy timestamps to be accepted, got %v", err)
+	}
+	if message.ID != "m1" || !message.CreatedOn.Equal(time.Date(2020, 5, 1, 22, 1, 0, 0, time.UTC)) || !message.UpdatedOn.Equal(time.Date(2020, 5, 1, 22, 2, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected message %+v", message)
+	}
+
+	body, _ := json.Marshal(message)
+	if !strings.Contains(string(body), `"created_on":"2020-05-01T22:01:00Z"`) {
+		t.Errorf("Expected RFC3339 timestamps, got %s", body)
+	}
+
+	message.Tombstone(time.Date(2020, 5, 3, 8, 0, 0, 0, time.UTC))
+	body, _ = json.Marshal(message)
+	if !message.IsTombstone() || !strings.Contains(string(body), `"deleted_on":"2020-05-03T08:00:00Z"`) {
+		t.Errorf("Expected an RFC3339 deletion time, got %s", body)
+	}
+
+	conversation := &Conversation{}
+	if err = json.Unmarshal([]byte(`{"id":"c1","user_id":["a"],"created_on":"yesterday"}`), conversation); err != ErrorTimestamp {
+		t.Errorf("Expected an invalid timestamp error, got %v", err)
+	}
+	conversation = &Conversation{}
+	err = json.Unmarshal([]byte(`{"id":"c1","user_id":["a"],"archived":true,"archived_on":"2020-05-01 22:01:00 +0000 UTC"}`), conversation)
+	if err != nil || conversation.ArchivedOn == nil || !conversation.ArchivedOn.Equal(time.Date(2020, 5, 1, 22, 1, 0, 0, time.UTC)) {
+		t.Errorf("Expected the legacy archive time to be accepted, got %v, %v", conversation.ArchivedOn, err)
+	}
+}
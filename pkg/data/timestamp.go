@@ -0,0 +1,99 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ErrorTimestamp : Timestamp specific errors
+var ErrorTimestamp = fmt.Errorf("timestamp must be an RFC3339 timestamp")
+
+// TimestampLayout is the layout of the timestamps stored as strings
+// CreatedOn and UpdatedOn were stored with it before they were stored as dates
+const TimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// ParseTimestamp parses a timestamp stored by this microservice or an RFC3339 timestamp
+func ParseTimestamp(value string) (time.Time, error) {
+	timestamp, err := time.Parse(TimestampLayout, value)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return timestamp, nil
+}
+
+// parseOptionalTimestamp parses a timestamp of a JSON document, an empty timestamp is the zero time
+func parseOptionalTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	timestamp, err := ParseTimestamp(value)
+	if err != nil {
+		return time.Time{}, ErrorTimestamp
+	}
+	return timestamp.UTC(), nil
+}
+
+// parseTimestampPointer parses a timestamp of a JSON document that can be missing, an empty timestamp is nil
+func parseTimestampPointer(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	timestamp, err := parseOptionalTimestamp(value)
+	if err != nil {
+		return nil, err
+	}
+	return &timestamp, nil
+}
+
+// UnmarshalJSON decodes a message, the timestamps written before they were RFC3339 are accepted
+func (message *Message) UnmarshalJSON(body []byte) error {
+	// The fields of the alias have no custom decoding, the timestamps are decoded by the outer fields
+	type messageFields Message
+	fields := &struct {
+		*messageFields
+		CreatedOn string `json:"created_on"`
+		UpdatedOn string `json:"updated_on"`
+		DeletedOn string `json:"deleted_on"`
+	}{messageFields: (*messageFields)(message)}
+	err := json.Unmarshal(body, fields)
+	if err != nil {
+		return err
+	}
+	message.CreatedOn, err = parseOptionalTimestamp(fields.CreatedOn)
+	if err != nil {
+		return err
+	}
+	message.UpdatedOn, err = parseOptionalTimestamp(fields.UpdatedOn)
+	if err != nil {
+		return err
+	}
+	message.DeletedOn, err = parseTimestampPointer(fields.DeletedOn)
+	return err
+}
+
+// UnmarshalJSON decodes a conversation, the timestamps written before they were RFC3339 are accepted
+func (conversation *Conversation) UnmarshalJSON(body []byte) error {
+	// The fields of the alias have no custom decoding, the timestamps are decoded by the outer fields
+	type conversationFields Conversation
+	fields := &struct {
+		*conversationFields
+		CreatedOn  string `json:"created_on"`
+		UpdatedOn  string `json:"updated_on"`
+		ArchivedOn string `json:"archived_on"`
+	}{conversationFields: (*conversationFields)(conversation)}
+	err := json.Unmarshal(body, fields)
+	if err != nil {
+		return err
+	}
+	conversation.CreatedOn, err = parseOptionalTimestamp(fields.CreatedOn)
+	if err != nil {
+		return err
+	}
+	conversation.UpdatedOn, err = parseOptionalTimestamp(fields.UpdatedOn)
+	if err != nil {
+		return err
+	}
+	conversation.ArchivedOn, err = parseTimestampPointer(fields.ArchivedOn)
+	return err
+}
@@ -4,14 +4,65 @@ import (
 	"github.com/go-playground/validator"
 )
 
-func (message *Message) ValidateMessage() error {
+// newValidator creates a validator with the validations specific to the text chat
+// The ttl tag checks a lifetime in seconds between 0 and MaxMessageTTL
+func newValidator() *validator.Validate {
 	validate := validator.New()
+	_ = validate.RegisterValidation("ttl", func(field validator.FieldLevel) bool {
+		ttl := field.Field().Int()
+		return ttl >= 0 && ttl <= MaxMessageTTL
+	})
+	return validate
+}
+
+func (message *Message) ValidateMessage() error {
+	validate := newValidator()
 	// To be discussed (depend on how we manage our id)
 	return validate.Struct(message)
 }
 
 func (conversation *Conversation) ValidateConversation() error {
-	validate := validator.New()
+	validate := newValidator()
 	// To be discussed (depend on how we manage our id)
-	return validate.Struct(conversation)
+	err := validate.Struct(conversation)
+	if err != nil {
+		return err
+	}
+	err = conversation.validateRoles()
+	if err != nil {
+		return err
+	}
+	return conversation.ValidateMembers(conversation.UserID)
+}
+
+func (patch *ConversationPatch) ValidateConversationPatch() error {
+	validate := validator.New()
+	return validate.Struct(patch)
+}
+
+func (roster *MatchRoster) ValidateMatchRoster() error {
+	validate := validator.New()
+	return validate.Struct(roster)
+}
+
+func (player *MatchPlayer) ValidateMatchPlayer() error {
+	validate := validator.New()
+	return validate.Struct(player)
+}
+
+func (roleChange *RoleChange) ValidateRoleChange() error {
+	validate := validator.New()
+	return validate.Struct(roleChange)
+}
+
+// ValidateSystemMessage validates a message sent by a service identity instead of a user
+func (message *Message) ValidateSystemMessage() error {
+	err := message.ValidateMessage()
+	if err != nil {
+		return err
+	}
+	if message.Sender == nil || message.IsUserMessage() {
+		return ErrorMessageKind
+	}
+	return nil
 }
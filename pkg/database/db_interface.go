@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
 )
@@ -11,12 +12,55 @@ type TextChatDB interface {
 	GetMessageByID(ctx context.Context, id string) (*data.Message, error)
 	GetConversationByID(ctx context.Context, id string) (*data.Conversation, error)
 	GetMessagesByConversationID(ctx context.Context, id string) (data.Messages, error)
+	GetMentionsByUserID(ctx context.Context, userID string) (data.Messages, error)
+	StreamMessagesByConversationID(ctx context.Context, id string, handle func(message *data.Message) error) error
+	StreamMessagesByUserID(ctx context.Context, userID string, handle func(message *data.Message) error) error
+	GetConversationsByUserID(ctx context.Context, userID string, includeArchived bool) (data.Conversations, error)
+	GetConversationsByGameID(ctx context.Context, gameID string) (data.Conversations, error)
+	GetConversationsByType(ctx context.Context, conversationType string) (data.Conversations, error)
 	AddMessage(ctx context.Context, message *data.Message) error
+	AddSystemMessage(ctx context.Context, message *data.Message) error
+	SetMessagePreviews(ctx context.Context, id string, previews []*data.Preview) error
 	AddConversation(ctx context.Context, conversation *data.Conversation) (*data.Conversation, error)
+	ImportConversation(ctx context.Context, conversation *data.Conversation) error
+	ImportMessage(ctx context.Context, message *data.Message) error
 	AddUserToConversation(ctx context.Context, conversation *data.Conversation) error
+	UpdateConversation(ctx context.Context, conversation *data.Conversation) error
+	ArchiveInactiveConversations(ctx context.Context, types []string, inactiveSince time.Time) (int64, error)
 	DeleteMessage(ctx context.Context, id string) error
+	DeleteExpiredMessages(ctx context.Context, now time.Time) (data.Messages, error)
+	CountMessagesForRetention(ctx context.Context, query *data.RetentionQuery) (int64, error)
+	GetMessagesForRetention(ctx context.Context, query *data.RetentionQuery, limit int) (data.Messages, error)
+	PurgeMessages(ctx context.Context, ids []string) (int64, error)
+	TombstoneMessages(ctx context.Context, ids []string, deletedOn time.Time) (int64, error)
+	AnonymizeUserMessages(ctx context.Context, userID string) (int64, error)
+	DeleteUserMessages(ctx context.Context, userID string) (data.Messages, error)
+	RemoveUserMentions(ctx context.Context, userID string) (int64, error)
 	DeleteConversation(ctx context.Context, id string) error
+	AddScheduledMessage(ctx context.Context, scheduled *data.ScheduledMessage) error
+	GetScheduledMessageByID(ctx context.Context, id string) (*data.ScheduledMessage, error)
+	GetScheduledMessagesByUserID(ctx context.Context, userID string) (data.ScheduledMessages, error)
+	CancelScheduledMessage(ctx context.Context, id string) error
+	ClaimScheduledMessages(ctx context.Context, now time.Time, claimedBefore time.Time) (data.ScheduledMessages, error)
+	CompleteScheduledMessage(ctx context.Context, id string, status string, reason string) error
+	DeleteUserScheduledMessages(ctx context.Context, userID string) (data.ScheduledMessages, error)
+	AddAttachment(ctx context.Context, attachment *data.Attachment) error
+	GetAttachmentByID(ctx context.Context, id string) (*data.Attachment, error)
+	GetAttachmentsByMessageID(ctx context.Context, messageID string) (data.Attachments, error)
+	LinkAttachment(ctx context.Context, id string, messageID string) error
+	DeleteAttachment(ctx context.Context, id string) error
 	Connect() error
 	PingDB() error
 	CloseDB()
 }
+
+// IndexManager is implemented by the databases that manage the indexes of their collections
+type IndexManager interface {
+	Reindex(ctx context.Context) error
+}
+
+// Migrator is implemented by the databases with versioned migrations
+type Migrator interface {
+	Migrate(ctx context.Context) ([]*MigrationStatus, error)
+	MigrationStatus(ctx context.Context) ([]*MigrationStatus, error)
+}
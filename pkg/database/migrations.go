@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrorMigrationLocked : Another replica is running the migrations
+var ErrorMigrationLocked = fmt.Errorf("migrations are locked by another replica")
+
+// migrationLockID is the ID of the document of the lock of the migrations
+const migrationLockID = "migrations"
+
+// MigrationLockLease is the time a replica holds the lock of the migrations, the lock of a stopped replica is released after it
+const MigrationLockLease = 10 * time.Minute
+
+// migrationLockRenewal is the interval between the renewals of the lease of the lock held while the migrations run
+const migrationLockRenewal = MigrationLockLease / 3
+
+// migrationLockRetry is the interval between the attempts to acquire the lock of the migrations
+const migrationLockRetry = time.Second
+
+// migrationBatchSize is the number of documents updated at once by the migrations converting documents
+const migrationBatchSize = 500
+
+// MigrationStatus is a migration of the database, AppliedOn is nil while the migration is pending
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedOn   *time.Time `json:"applied_on,omitempty"`
+}
+
+// migration is a change of the collections of the database, applied once in the order of the versions
+// A migration must be safe to apply again, the replica applying it can stop before it is recorded
+type migration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, mp *MongoTextChat) error
+}
+
+// appliedMigration is the record of an applied migration
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedOn   time.Time `bson:"applied_on"`
+}
+
+// migrations returns the migrations of the database, a new migration is added at the end with the next version
+// The indexes added after the first migration are created by a new migration
+func migrations() []migration {
+	return []migration{
+		{version: 1, description: "create the indexes of the collections", apply: createIndexes},
+		{version: 2, description: "backfill the version and type of the conversations and the kind of the messages", apply: backfillTypes},
+		{version: 3, description: "convert the timestamps of the messages and conversations stored as strings to dates", apply: convertTimestamps},
+		{version: 4, description: "make the index of the direct conversations unique", apply: uniqueDirectKey},
+		{version: 5, description: "convert the deletion, archival, scheduling and attachment timestamps stored as strings to dates", apply: convertOtherTimestamps},
+	}
+}
+
+// createIndexes creates the indexes of the collections, the existing indexes are left unchanged
+func createIndexes(ctx context.Context, mp *MongoTextChat) error {
+	for _, collectionIndexes := range mp.indexes() {
+		_, err := collectionIndexes.collection.Indexes().CreateMany(ctx, collectionIndexes.models)
+		if err != nil {
+			log.Error(err, "Failed to create the indexes of a collection", "collection", collectionIndexes.collection.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueDirectKey replaces the sparse index of the direct keys created by the first migration with a unique index
+// The creation fails while several direct conversations exist for the same pair of users, they must be merged first
+func uniqueDirectKey(ctx context.Context, mp *MongoTextChat) error {
+	_, err := mp.conversationsCollection.Indexes().DropOne(ctx, "direct_key_1")
+	// The index is missing when the migration is applied again
+	if commandError, ok := err.(mongo.CommandError); err != nil && !(ok && (commandError.Code == indexNotFound || commandError.Code == namespaceNotFound)) {
+		log.Error(err, "Failed to drop the index of the direct keys")
+		return err
+	}
+	_, err = mp.conversationsCollection.Indexes().CreateOne(ctx, directKeyIndex())
+	if err != nil {
+		log.Error(err, "Failed to create the unique index of the direct keys, duplicate direct conversations must be merged")
+	}
+	return err
+}
+
+// backfillTypes sets the fields of the documents stored before the fields existed to their default value
+func backfillTypes(ctx context.Context, mp *MongoTextChat) error {
+	_, err := mp.conversationsCollection.UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": 0}})
+	if err != nil {
+		return err
+	}
+	_, err = mp.conversationsCollection.UpdateMany(ctx,
+		bson.M{"type": bson.M{"$in": bson.A{"", nil}}},
+		bson.M{"$set": bson.M{"type": data.ConversationTypeGroup}})
+	if err != nil {
+		return err
+	}
+	_, err = mp.messagesCollection.UpdateMany(ctx,
+		bson.M{"kind": bson.M{"$in": bson.A{"", nil}}},
+		bson.M{"$set": bson.M{"kind": data.MessageKindUser}})
+	return err
+}
+
+// convertTimestamps converts the creation, update and last message times stored as strings to dates
+func convertTimestamps(ctx context.Context, mp *MongoTextChat) error {
+	err := convertStringTimestamps(ctx, mp.messagesCollection, "createdon", "updatedon")
+	if err != nil {
+		return err
+	}
+	return convertStringTimestamps(ctx, mp.conversationsCollection, "createdon", "updatedon", "last_message_on")
+}
+
+// convertOtherTimestamps converts the timestamps stored as strings that the third migration didn't convert
+func convertOtherTimestamps(ctx context.Context, mp *MongoTextChat) error {
+	err := convertStringTimestamps(ctx, mp.messagesCollection, "deleted_on")
+	if err != nil {
+		return err
+	}
+	err = convertStringTimestamps(ctx, mp.conversationsCollection, "archived_on")
+	if err != nil {
+		return err
+	}
+	err = convertStringTimestamps(ctx, mp.scheduledCollection, "created_on", "updated_on")
+	if err != nil {
+		return err
+	}
+	return convertStringTimestamps(ctx, mp.attachmentsCollection, "createdon")
+}
+
+// convertStringTimestamps converts the fields of the documents of the collection stored as strings to dates, in batches
+// A timestamp that can't be parsed is left unchanged, an empty timestamp is removed
+func convertStringTimestamps(ctx context.Context, collection *mongo.Collection, fields ...string) error {
+	conditions := bson.A{}
+	projection := bson.M{}
+	for _, field := range fields {
+		conditions = append(conditions, bson.M{field: bson.M{"$type": "string"}})
+		projection[field] = 1
+	}
+	cursor, err := collection.Find(ctx, bson.M{"$or": conditions}, options.Find().SetProjection(projection))
+	if err != nil {
+		log.Error(err, "Error getting the documents with string timestamps", "collection", collection.Name())
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	converted := 0
+	updates := []mongo.WriteModel{}
+	flush := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, updates)
+		if err != nil {
+			log.Error(err, "Error converting string timestamps", "collection", collection.Name())
+			return err
+		}
+		converted += len(updates)
+		updates = updates[:0]
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		document := bson.M{}
+		err = cursor.Decode(&document)
+		if err != nil {
+			return err
+		}
+		set := bson.M{}
+		unset := bson.M{}
+		for _, field := range fields {
+			value, ok := document[field].(string)
+			if !ok {
+				continue
+			}
+			if value == "" {
+				unset[field] = ""
+				continue
+			}
+			timestamp, err := data.ParseTimestamp(value)
+			if err != nil {
+				log.Error(err, "Invalid timestamp left unchanged", "collection", collection.Name(), "id", document["_id"], "field", field)
+				continue
+			}
+			set[field] = timestamp.UTC()
+		}
+		update := bson.M{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		if len(update) == 0 {
+			continue
+		}
+		updates = append(updates, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": document["_id"]}).SetUpdate(update))
+		if len(updates) == migrationBatchSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = cursor.Err()
+	if err != nil {
+		return err
+	}
+	err = flush()
+	if err != nil {
+		return err
+	}
+	log.Info("Converted string timestamps", "collection", collection.Name(), "documents", converted)
+	return nil
+}
+
+// Migrate applies the pending migrations and returns them
+// Only one replica applies the migrations, the others wait for it and find no pending migration
+func (mp *MongoTextChat) Migrate(ctx context.Context) ([]*MigrationStatus, error) {
+	owner := uuid.NewString()
+	for {
+		err := mp.lockMigrations(ctx, owner)
+		if err == nil {
+			break
+		}
+		if err != ErrorMigrationLocked {
+			return nil, err
+		}
+		log.Info("Waiting for the migrations of another replica")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockRetry):
+		}
+	}
+	defer mp.unlockMigrations(owner)
+	// The lease is renewed until the migrations end, so a long migration keeps the lock
+	done := make(chan struct{})
+	defer close(done)
+	go mp.renewMigrationLock(owner, done)
+
+	statuses, err := mp.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := []*MigrationStatus{}
+	for i, migration := range migrations() {
+		if statuses[i].AppliedOn != nil {
+			continue
+		}
+		log.Info("Applying migration", "version", migration.version, "description", migration.description)
+		err = migration.apply(ctx, mp)
+		if err != nil {
+			log.Error(err, "Migration failed", "version", migration.version)
+			return applied, err
+		}
+		record := &appliedMigration{Version: migration.version, Description: migration.description, AppliedOn: time.Now().UTC()}
+		_, err = mp.migrationsCollection.InsertOne(ctx, record)
+		// A replica that took over an expired lock can record the migration first, the migration is applied either way
+		if mongo.IsDuplicateKeyError(err) {
+			log.Info("Migration already recorded by another replica", "version", migration.version)
+			err = nil
+		}
+		if err != nil {
+			log.Error(err, "Error recording migration", "version", migration.version)
+			return applied, err
+		}
+		statuses[i].AppliedOn = &record.AppliedOn
+		applied = append(applied, statuses[i])
+	}
+	return applied, nil
+}
+
+// MigrationStatus returns the migrations of the database in the order of the versions, with the time they were applied
+func (mp *MongoTextChat) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	records := []*appliedMigration{}
+	cursor, err := mp.migrationsCollection.Find(ctx, bson.D{})
+	if err != nil {
+		log.Error(err, "Error getting migrations from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		log.Error(err, "Error decoding migrations from database")
+		return nil, err
+	}
+	appliedOn := map[int]time.Time{}
+	for _, record := range records {
+		appliedOn[record.Version] = record.AppliedOn
+	}
+
+	statuses := []*MigrationStatus{}
+	for _, migration := range migrations() {
+		status := &MigrationStatus{Version: migration.version, Description: migration.description}
+		if on, ok := appliedOn[migration.version]; ok {
+			status.AppliedOn = &on
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// lockMigrations acquires the lock of the migrations, an expired lock is taken over
+func (mp *MongoTextChat) lockMigrations(ctx context.Context, owner string) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": migrationLockID, "expires_at": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(MigrationLockLease)}}
+
+	// The lock is inserted when there is none, a lock held by another replica is a duplicate key
+	_, err := mp.migrationLockCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrorMigrationLocked
+	}
+	return err
+}
+
+// extendMigrationLock extends the lease of the lock of the migrations held by the owner
+func (mp *MongoTextChat) extendMigrationLock(ctx context.Context, owner string) error {
+	filter := bson.M{"_id": migrationLockID, "owner": owner}
+	update := bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(MigrationLockLease)}}
+	_, err := mp.migrationLockCollection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// renewMigrationLock extends the lease of the lock of the migrations held by the owner until done is closed
+func (mp *MongoTextChat) renewMigrationLock(owner string, done <-chan struct{}) {
+	ticker := time.NewTicker(migrationLockRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := mp.extendMigrationLock(context.Background(), owner)
+			if err != nil {
+				log.Error(err, "Error renewing the lock of the migrations")
+			}
+		}
+	}
+}
+
+// unlockMigrations releases the lock of the migrations held by the owner
+func (mp *MongoTextChat) unlockMigrations(owner string) {
+	filter := bson.M{"_id": migrationLockID, "owner": owner}
+	_, err := mp.migrationLockCollection.DeleteOne(context.Background(), filter)
+	if err != nil {
+		log.Error(err, "Error releasing the lock of the migrations")
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
@@ -33,7 +34,7 @@ func (mp *MockTextChat) GetMessageByID(ctx context.Context, id string) (*data.Me
 	_, span := otel.Tracer("text-chat").Start(ctx, "getMessageByIdDatabase")
 	defer span.End()
 	index := findIndexByMessageID(id)
-	if index == -1 {
+	if index == -1 || messageList[index].IsExpired(time.Now()) {
 		return nil, data.ErrorMessageNotFound
 	}
 	return messageList[index], nil
@@ -43,8 +44,9 @@ func (mp *MockTextChat) GetMessagesByConversationID(ctx context.Context, id stri
 	_, span := otel.Tracer("text-chat").Start(ctx, "getMessagesByConversationIdDatabase")
 	defer span.End()
 	var messages data.Messages
+	now := time.Now()
 	for _, v := range messageList {
-		if v.ConversationID == id {
+		if v.ConversationID == id && !v.IsExpired(now) {
 			messages = append(messages, v)
 		}
 	}
@@ -54,6 +56,65 @@ func (mp *MockTextChat) GetMessagesByConversationID(ctx context.Context, id stri
 	return messages, nil
 }
 
+func (mp *MockTextChat) GetMentionsByUserID(ctx context.Context, userID string) (data.Messages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getMentionsByUserIdDatabase")
+	defer span.End()
+	var messages data.Messages
+	now := time.Now()
+	for _, v := range messageList {
+		if v.IsExpired(now) {
+			continue
+		}
+		for _, mentionedUserID := range v.MentionedUserID {
+			if mentionedUserID == userID {
+				messages = append(messages, v)
+				break
+			}
+		}
+	}
+	return messages, nil
+}
+
+// StreamMessagesByConversationID calls handle with every message of the conversation, by creation
+func (mp *MockTextChat) StreamMessagesByConversationID(ctx context.Context, id string, handle func(message *data.Message) error) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "streamMessagesByConversationIdDatabase")
+	defer span.End()
+	messages := data.Messages{}
+	now := time.Now()
+	for _, message := range messageList {
+		if message.ConversationID == id && !message.IsExpired(now) {
+			messages = append(messages, message)
+		}
+	}
+	for _, message := range messages {
+		err := handle(message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamMessagesByUserID calls handle with every message authored by the user, by creation
+func (mp *MockTextChat) StreamMessagesByUserID(ctx context.Context, userID string, handle func(message *data.Message) error) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "streamMessagesByUserIdDatabase")
+	defer span.End()
+	messages := data.Messages{}
+	now := time.Now()
+	for _, message := range messageList {
+		if message.UserID == userID && !message.IsExpired(now) {
+			messages = append(messages, message)
+		}
+	}
+	for _, message := range messages {
+		err := handle(message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (mp *MockTextChat) GetConversationByID(ctx context.Context, id string) (*data.Conversation, error) {
 	_, span := otel.Tracer("text-chat").Start(ctx, "getConversationByIdDatabase")
 	defer span.End()
@@ -61,13 +122,13 @@ func (mp *MockTextChat) GetConversationByID(ctx context.Context, id string) (*da
 	if index == -1 {
 		return nil, data.ErrorConversationNotFound
 	}
-	return conversationList[index], nil
+	return copyConversation(conversationList[index]), nil
 }
 
 func (mp *MockTextChat) AddMessage(ctx context.Context, message *data.Message) error {
 	_, span := otel.Tracer("text-chat").Start(ctx, "addMessageDatabase")
 	defer span.End()
-	_, err := mp.GetConversationByID(ctx, message.ConversationID)
+	conversation, err := mp.GetConversationByID(ctx, message.ConversationID)
 	if err != nil {
 		return err
 	}
@@ -76,8 +137,63 @@ func (mp *MockTextChat) AddMessage(ctx context.Context, message *data.Message) e
 		return data.ErrorUserNotFound
 	}
 
-	message.ID = uuid.NewString()
+	if conversation.Archived {
+		return data.ErrorConversationArchived
+	}
+
+	if conversation.IsMuted(message.UserID) {
+		return data.ErrorUserMuted
+	}
+
+	message.Kind = data.MessageKindUser
+	message.Sender = nil
+	return mp.insertMessage(conversation, message)
+}
+
+func (mp *MockTextChat) AddSystemMessage(ctx context.Context, message *data.Message) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "addSystemMessageDatabase")
+	defer span.End()
+	conversation, err := mp.GetConversationByID(ctx, message.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	if conversation.Archived {
+		return data.ErrorConversationArchived
+	}
+
+	return mp.insertMessage(conversation, message)
+}
+
+// insertMessage keeps the ID of the message when it is set, so a retried insertion fails instead of duplicating the message
+func (mp *MockTextChat) insertMessage(conversation *data.Conversation, message *data.Message) error {
+	if message.ID == "" {
+		message.ID = uuid.NewString()
+	} else if findIndexByMessageID(message.ID) != -1 {
+		return data.ErrorMessageExists
+	}
+	now := time.Now().UTC()
+	message.SetRenderMetadata()
+	message.SetExpiry(now, conversation.MessageTTL)
+	message.CreatedOn = now
+	message.UpdatedOn = now
 	messageList = append(messageList, message)
+
+	// The last message is the activity used to archive inactive conversations
+	if index := findIndexByConversationID(message.ConversationID); index != -1 {
+		conversationList[index].LastMessageOn = &now
+	}
+	return nil
+}
+
+func (mp *MockTextChat) SetMessagePreviews(ctx context.Context, id string, previews []*data.Preview) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "setMessagePreviewsDatabase")
+	defer span.End()
+	index := findIndexByMessageID(id)
+	if index == -1 {
+		return data.ErrorMessageNotFound
+	}
+	messageList[index].Previews = previews
 	return nil
 }
 
@@ -94,11 +210,71 @@ func (mp *MockTextChat) AddConversation(ctx context.Context, conversation *data.
 		return nil, data.ErrorGameNotFound
 	}
 
-	conversation.ID = uuid.NewString()
+	// Direct conversations are unique per pair of users, the existing one is returned
+	if conversation.ConversationType() == data.ConversationTypeDirect {
+		conversation.DirectKey = data.NewDirectKey(conversation.UserID)
+		for _, existing := range conversationList {
+			if existing.DirectKey == conversation.DirectKey {
+				return existing, nil
+			}
+		}
+	}
+
+	// Conversations created with an ID keep it, so the game server can create them idempotently
+	if conversation.ID == "" {
+		conversation.ID = uuid.NewString()
+	} else if findIndexByConversationID(conversation.ID) != -1 {
+		return nil, data.ErrorConversationExists
+	}
+	// A new conversation starts at the first version, whatever version was received
+	conversation.Version = 0
+	conversation.CreatedOn = time.Now().UTC()
+	conversation.UpdatedOn = conversation.CreatedOn
 	conversationList = append(conversationList, conversation)
 	return conversation, nil
 }
 
+// ImportConversation stores a conversation with its original ID and timestamps
+func (mp *MockTextChat) ImportConversation(ctx context.Context, conversation *data.Conversation) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "importConversationDatabase")
+	defer span.End()
+	if findIndexByConversationID(conversation.ID) != -1 {
+		return data.ErrorConversationExists
+	}
+	if conversation.ConversationType() == data.ConversationTypeDirect {
+		conversation.DirectKey = data.NewDirectKey(conversation.UserID)
+		for _, existing := range conversationList {
+			if existing.DirectKey == conversation.DirectKey {
+				return data.ErrorDirectConversationExists
+			}
+		}
+	}
+	conversationList = append(conversationList, copyConversation(conversation))
+	return nil
+}
+
+// ImportMessage stores a message with its original ID and timestamps, its conversation must exist
+func (mp *MockTextChat) ImportMessage(ctx context.Context, message *data.Message) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "importMessageDatabase")
+	defer span.End()
+	conversationIndex := findIndexByConversationID(message.ConversationID)
+	if conversationIndex == -1 {
+		return data.ErrorConversationNotFound
+	}
+	if findIndexByMessageID(message.ID) != -1 {
+		return data.ErrorMessageExists
+	}
+	messageList = append(messageList, message)
+
+	// Messages are not imported in order, the last message is the most recent one
+	conversation := conversationList[conversationIndex]
+	if conversation.LastMessageOn == nil || message.CreatedOn.After(*conversation.LastMessageOn) {
+		createdOn := message.CreatedOn
+		conversation.LastMessageOn = &createdOn
+	}
+	return nil
+}
+
 func (mp *MockTextChat) DeleteMessage(ctx context.Context, id string) error {
 	_, span := otel.Tracer("text-chat").Start(ctx, "deleteMessageDatabase")
 	defer span.End()
@@ -108,10 +284,32 @@ func (mp *MockTextChat) DeleteMessage(ctx context.Context, id string) error {
 	}
 
 	messageList = append(messageList[:index], messageList[index+1:]...)
+	unpinMessage(id)
 
 	return nil
 }
 
+// DeleteExpiredMessages is the sweeper of the ephemeral messages, it deletes and returns the messages expired at the given time
+func (mp *MockTextChat) DeleteExpiredMessages(ctx context.Context, now time.Time) (data.Messages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "deleteExpiredMessagesDatabase")
+	defer span.End()
+	expired := data.Messages{}
+	kept := messageList[:0]
+	for _, message := range messageList {
+		if message.IsExpired(now) {
+			expired = append(expired, message)
+			continue
+		}
+		kept = append(kept, message)
+	}
+	messageList = kept
+
+	for _, message := range expired {
+		unpinMessage(message.ID)
+	}
+	return expired, nil
+}
+
 func (mp *MockTextChat) DeleteConversation(ctx context.Context, id string) error {
 	_, span := otel.Tracer("text-chat").Start(ctx, "deleteConversationDatabase")
 	defer span.End()
@@ -120,8 +318,7 @@ func (mp *MockTextChat) DeleteConversation(ctx context.Context, id string) error
 		return data.ErrorConversationNotFound
 	}
 
-	// This should not work, probably needs ':' after index+1. To test
-	conversationList = append(conversationList[:index], conversationList[index+1])
+	conversationList = append(conversationList[:index], conversationList[index+1:]...)
 
 	return nil
 }
@@ -140,13 +337,409 @@ func (mp *MockTextChat) AddUserToConversation(ctx context.Context, conversation
 	}
 
 	conversationIndex := findIndexByConversationID(conversation.ID)
+	if conversationIndex == -1 {
+		return data.ErrorConversationNotFound
+	}
 	conversationToUpdate := conversationList[conversationIndex]
-	conversationToUpdate.UpdatedOn = time.Now().UTC().String()
-	conversationToUpdate.UserID = make([]string, len(conversation.UserID))
-	_ = copy(conversationToUpdate.UserID, conversation.UserID)
+	if conversationToUpdate.Version != conversation.Version {
+		return data.ErrorConversationConflict
+	}
+
+	// The rules of the stored conversation type apply to the new members
+	err := conversationToUpdate.ValidateMemberChange(conversation.UserID)
+	if err != nil {
+		return err
+	}
+
+	conversationToUpdate.Version++
+	conversationToUpdate.UpdatedOn = time.Now().UTC()
+	conversationToUpdate.SetMembers(conversation.UserID)
 	return nil
 }
 
+func (mp *MockTextChat) UpdateConversation(ctx context.Context, conversation *data.Conversation) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "updateConversationDatabase")
+	defer span.End()
+	conversationIndex := findIndexByConversationID(conversation.ID)
+	if conversationIndex == -1 {
+		return data.ErrorConversationNotFound
+	}
+
+	if conversationList[conversationIndex].Version != conversation.Version {
+		return data.ErrorConversationConflict
+	}
+
+	conversation.Version++
+	conversation.UpdatedOn = time.Now().UTC()
+	conversationList[conversationIndex] = copyConversation(conversation)
+	return nil
+}
+
+func (mp *MockTextChat) GetConversationsByUserID(ctx context.Context, userID string, includeArchived bool) (data.Conversations, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getConversationsByUserIdDatabase")
+	defer span.End()
+	var conversations data.Conversations
+	for _, conversation := range conversationList {
+		if conversation.HasUser(userID) && (includeArchived || !conversation.Archived) {
+			conversations = append(conversations, copyConversation(conversation))
+		}
+	}
+	return conversations, nil
+}
+
+func (mp *MockTextChat) GetConversationsByGameID(ctx context.Context, gameID string) (data.Conversations, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getConversationsByGameIdDatabase")
+	defer span.End()
+	var conversations data.Conversations
+	for _, conversation := range conversationList {
+		if conversation.GameID == gameID {
+			conversations = append(conversations, copyConversation(conversation))
+		}
+	}
+	return conversations, nil
+}
+
+func (mp *MockTextChat) GetConversationsByType(ctx context.Context, conversationType string) (data.Conversations, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getConversationsByTypeDatabase")
+	defer span.End()
+	conversations := data.Conversations{}
+	for _, conversation := range conversationList {
+		if conversation.ConversationType() == conversationType {
+			conversations = append(conversations, copyConversation(conversation))
+		}
+	}
+	return conversations, nil
+}
+
+func (mp *MockTextChat) ArchiveInactiveConversations(ctx context.Context, types []string, inactiveSince time.Time) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "archiveInactiveConversationsDatabase")
+	defer span.End()
+	var archived int64
+	for _, conversation := range conversationList {
+		if conversation.Archived || !containsString(types, conversation.Type) {
+			continue
+		}
+		// Conversations without messages are inactive since their last update
+		lastActivity := conversation.UpdatedOn
+		if conversation.LastMessageOn != nil {
+			lastActivity = *conversation.LastMessageOn
+		}
+		if !lastActivity.Before(inactiveSince) {
+			continue
+		}
+		conversation.Archive()
+		conversation.Version++
+		archived++
+	}
+	return archived, nil
+}
+
+func (mp *MockTextChat) CountMessagesForRetention(ctx context.Context, query *data.RetentionQuery) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "countMessagesForRetentionDatabase")
+	defer span.End()
+	var count int64
+	for _, message := range messageList {
+		if query.Matches(message) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (mp *MockTextChat) GetMessagesForRetention(ctx context.Context, query *data.RetentionQuery, limit int) (data.Messages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getMessagesForRetentionDatabase")
+	defer span.End()
+	messages := data.Messages{}
+	for _, message := range messageList {
+		if len(messages) >= limit {
+			break
+		}
+		if query.Matches(message) {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// PurgeMessages deletes the messages and unpins them
+func (mp *MockTextChat) PurgeMessages(ctx context.Context, ids []string) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "purgeMessagesDatabase")
+	defer span.End()
+	var purged int64
+	for _, id := range ids {
+		index := findIndexByMessageID(id)
+		if index == -1 {
+			continue
+		}
+		messageList = append(messageList[:index], messageList[index+1:]...)
+		unpinMessage(id)
+		purged++
+	}
+	return purged, nil
+}
+
+// TombstoneMessages removes the content of the messages and unpins them
+func (mp *MockTextChat) TombstoneMessages(ctx context.Context, ids []string, deletedOn time.Time) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "tombstoneMessagesDatabase")
+	defer span.End()
+	var tombstoned int64
+	for _, id := range ids {
+		index := findIndexByMessageID(id)
+		if index == -1 || messageList[index].IsTombstone() {
+			continue
+		}
+		messageList[index].Tombstone(deletedOn)
+		unpinMessage(id)
+		tombstoned++
+	}
+	return tombstoned, nil
+}
+
+// AnonymizeUserMessages replaces the author of the messages and attachments of the user by the erased user
+func (mp *MockTextChat) AnonymizeUserMessages(ctx context.Context, userID string) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "anonymizeUserMessagesDatabase")
+	defer span.End()
+	var anonymized int64
+	updatedOn := time.Now().UTC()
+	for _, message := range messageList {
+		if message.UserID == userID {
+			message.UserID = data.ErasedUserID
+			message.UpdatedOn = updatedOn
+			anonymized++
+		}
+	}
+	for _, attachment := range attachmentList {
+		if attachment.UserID == userID {
+			attachment.UserID = data.ErasedUserID
+		}
+	}
+	return anonymized, nil
+}
+
+// DeleteUserMessages deletes and returns the messages of the user, expired messages included
+func (mp *MockTextChat) DeleteUserMessages(ctx context.Context, userID string) (data.Messages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "deleteUserMessagesDatabase")
+	defer span.End()
+	deleted := data.Messages{}
+	kept := messageList[:0]
+	for _, message := range messageList {
+		if message.UserID == userID {
+			deleted = append(deleted, message)
+			continue
+		}
+		kept = append(kept, message)
+	}
+	messageList = kept
+
+	for _, message := range deleted {
+		unpinMessage(message.ID)
+	}
+	return deleted, nil
+}
+
+// RemoveUserMentions removes the user from the mentions of every message
+func (mp *MockTextChat) RemoveUserMentions(ctx context.Context, userID string) (int64, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "removeUserMentionsDatabase")
+	defer span.End()
+	var updated int64
+	for _, message := range messageList {
+		if message.RemoveMentionsOf(userID) {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (mp *MockTextChat) AddScheduledMessage(ctx context.Context, scheduled *data.ScheduledMessage) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "addScheduledMessageDatabase")
+	defer span.End()
+	scheduled.CreatedOn = time.Now().UTC()
+	scheduled.UpdatedOn = scheduled.CreatedOn
+	scheduledMessageList = append(scheduledMessageList, scheduled)
+	return nil
+}
+
+func (mp *MockTextChat) GetScheduledMessageByID(ctx context.Context, id string) (*data.ScheduledMessage, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getScheduledMessageByIdDatabase")
+	defer span.End()
+	index := findIndexByScheduledMessageID(id)
+	if index == -1 {
+		return nil, data.ErrorScheduledMessageNotFound
+	}
+	scheduled := *scheduledMessageList[index]
+	return &scheduled, nil
+}
+
+// GetScheduledMessagesByUserID returns the pending scheduled messages of the user, by send time
+func (mp *MockTextChat) GetScheduledMessagesByUserID(ctx context.Context, userID string) (data.ScheduledMessages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getScheduledMessagesByUserIdDatabase")
+	defer span.End()
+	scheduledMessages := data.ScheduledMessages{}
+	for _, scheduled := range scheduledMessageList {
+		if scheduled.UserID == userID && scheduled.Status == data.ScheduleStatusPending {
+			scheduledMessages = append(scheduledMessages, scheduled)
+		}
+	}
+	sort.SliceStable(scheduledMessages, func(i, j int) bool {
+		return scheduledMessages[i].SendAt.Before(scheduledMessages[j].SendAt)
+	})
+	return scheduledMessages, nil
+}
+
+// CancelScheduledMessage cancels a pending scheduled message, a message being sent can't be cancelled
+func (mp *MockTextChat) CancelScheduledMessage(ctx context.Context, id string) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "cancelScheduledMessageDatabase")
+	defer span.End()
+	index := findIndexByScheduledMessageID(id)
+	if index == -1 || scheduledMessageList[index].Status != data.ScheduleStatusPending {
+		return data.ErrorScheduledMessageNotFound
+	}
+	scheduledMessageList[index].Status = data.ScheduleStatusCancelled
+	scheduledMessageList[index].UpdatedOn = time.Now().UTC()
+	return nil
+}
+
+// ClaimScheduledMessages marks the due messages as being sent and returns them
+// Messages claimed before claimedBefore are claimed again, their dispatcher stopped before completing them
+func (mp *MockTextChat) ClaimScheduledMessages(ctx context.Context, now time.Time, claimedBefore time.Time) (data.ScheduledMessages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "claimScheduledMessagesDatabase")
+	defer span.End()
+	claimed := data.ScheduledMessages{}
+	for _, scheduled := range scheduledMessageList {
+		due := scheduled.Status == data.ScheduleStatusPending && !scheduled.SendAt.After(now)
+		stale := scheduled.Status == data.ScheduleStatusSending && scheduled.ClaimedOn.Before(claimedBefore)
+		if !due && !stale {
+			continue
+		}
+		claimedOn := now
+		scheduled.Status = data.ScheduleStatusSending
+		scheduled.ClaimedOn = &claimedOn
+		copied := *scheduled
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// CompleteScheduledMessage sets the final status of a claimed scheduled message
+func (mp *MockTextChat) CompleteScheduledMessage(ctx context.Context, id string, status string, reason string) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "completeScheduledMessageDatabase")
+	defer span.End()
+	index := findIndexByScheduledMessageID(id)
+	if index == -1 {
+		return data.ErrorScheduledMessageNotFound
+	}
+	scheduledMessageList[index].Status = status
+	scheduledMessageList[index].Error = reason
+	scheduledMessageList[index].ClaimedOn = nil
+	scheduledMessageList[index].UpdatedOn = time.Now().UTC()
+	return nil
+}
+
+// DeleteUserScheduledMessages deletes and returns the scheduled messages of the user, whatever their status
+func (mp *MockTextChat) DeleteUserScheduledMessages(ctx context.Context, userID string) (data.ScheduledMessages, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "deleteUserScheduledMessagesDatabase")
+	defer span.End()
+	deleted := data.ScheduledMessages{}
+	kept := scheduledMessageList[:0]
+	for _, scheduled := range scheduledMessageList {
+		if scheduled.UserID == userID {
+			deleted = append(deleted, scheduled)
+			continue
+		}
+		kept = append(kept, scheduled)
+	}
+	scheduledMessageList = kept
+	return deleted, nil
+}
+
+func (mp *MockTextChat) AddAttachment(ctx context.Context, attachment *data.Attachment) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "addAttachmentDatabase")
+	defer span.End()
+	attachment.CreatedOn = time.Now().UTC()
+	attachmentList = append(attachmentList, attachment)
+	return nil
+}
+
+func (mp *MockTextChat) GetAttachmentByID(ctx context.Context, id string) (*data.Attachment, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getAttachmentByIdDatabase")
+	defer span.End()
+	index := findIndexByAttachmentID(id)
+	if index == -1 {
+		return nil, data.ErrorAttachmentNotFound
+	}
+	return attachmentList[index], nil
+}
+
+func (mp *MockTextChat) GetAttachmentsByMessageID(ctx context.Context, messageID string) (data.Attachments, error) {
+	_, span := otel.Tracer("text-chat").Start(ctx, "getAttachmentsByMessageIdDatabase")
+	defer span.End()
+	var attachments data.Attachments
+	for _, attachment := range attachmentList {
+		if attachment.MessageID == messageID {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments, nil
+}
+
+func (mp *MockTextChat) LinkAttachment(ctx context.Context, id string, messageID string) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "linkAttachmentDatabase")
+	defer span.End()
+	index := findIndexByAttachmentID(id)
+	if index == -1 {
+		return data.ErrorAttachmentNotFound
+	}
+	if attachmentList[index].MessageID != "" {
+		return data.ErrorAttachmentLinked
+	}
+	attachmentList[index].MessageID = messageID
+	return nil
+}
+
+func (mp *MockTextChat) DeleteAttachment(ctx context.Context, id string) error {
+	_, span := otel.Tracer("text-chat").Start(ctx, "deleteAttachmentDatabase")
+	defer span.End()
+	index := findIndexByAttachmentID(id)
+	if index == -1 {
+		return data.ErrorAttachmentNotFound
+	}
+
+	attachmentList = append(attachmentList[:index], attachmentList[index+1:]...)
+
+	return nil
+}
+
+// unpinMessage removes a deleted message from the pinned messages of every conversation
+func unpinMessage(id string) {
+	for _, conversation := range conversationList {
+		if conversation.Unpin(id) {
+			conversation.Version++
+		}
+	}
+}
+
+// Returns the index of a scheduled message in the database
+// Returns -1 when no scheduled message is found
+func findIndexByScheduledMessageID(id string) int {
+	for index, scheduled := range scheduledMessageList {
+		if scheduled.ID == id {
+			return index
+		}
+	}
+	return -1
+}
+
+// Returns the index of an attachment in the database
+// Returns -1 when no attachment is found
+func findIndexByAttachmentID(id string) int {
+	for index, attachment := range attachmentList {
+		if attachment.ID == id {
+			return index
+		}
+	}
+	return -1
+}
+
 // Returns the index of a message in the database
 // Returns -1 when no message is found
 func findIndexByMessageID(id string) int {
@@ -158,6 +751,39 @@ func findIndexByMessageID(id string) int {
 	return -1
 }
 
+// containsString returns true when the value is one of the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// copyConversation returns a copy of a stored conversation, so updates are only stored by UpdateConversation
+func copyConversation(conversation *data.Conversation) *data.Conversation {
+	result := *conversation
+	result.UserID = append([]string{}, conversation.UserID...)
+	result.MutedUserID = append([]string(nil), conversation.MutedUserID...)
+	result.PinnedMessageID = append([]string(nil), conversation.PinnedMessageID...)
+	result.Roles = copyMap(conversation.Roles)
+	result.Attributes = copyMap(conversation.Attributes)
+	return &result
+}
+
+// copyMap returns a copy of a map of strings, a nil map stays nil
+func copyMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+	return result
+}
+
 // Returns the index of a conversation in the database
 // Returns -1 when no conversation is found
 func findIndexByConversationID(id string) int {
@@ -187,24 +813,24 @@ var messageList = []*data.Message{
 		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
 		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
 		Text:           "This is a message",
-		CreatedOn:      time.Now().UTC().String(),
-		UpdatedOn:      time.Now().UTC().String(),
+		CreatedOn:      time.Now().UTC(),
+		UpdatedOn:      time.Now().UTC(),
 	},
 	{
 		ID:             "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
 		UserID:         "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
 		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
 		Text:           "This is an other message",
-		CreatedOn:      time.Now().UTC().String(),
-		UpdatedOn:      time.Now().UTC().String(),
+		CreatedOn:      time.Now().UTC(),
+		UpdatedOn:      time.Now().UTC(),
 	},
 	{
 		ID:             "2aee2975-6b76-4340-b679-e81661b1cdb5",
 		UserID:         "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
 		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
 		Text:           "This is a third message",
-		CreatedOn:      time.Now().UTC().String(),
-		UpdatedOn:      time.Now().UTC().String(),
+		CreatedOn:      time.Now().UTC(),
+		UpdatedOn:      time.Now().UTC(),
 	},
 }
 
@@ -216,8 +842,8 @@ var conversationList = []*data.Conversation{
 			"2aee2975-6b76-4340-b679-e81661b1cdb5",
 		},
 		GameID:    "",
-		CreatedOn: time.Now().UTC().String(),
-		UpdatedOn: time.Now().UTC().String(),
+		CreatedOn: time.Now().UTC(),
+		UpdatedOn: time.Now().UTC(),
 	},
 	{
 		ID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
@@ -228,7 +854,11 @@ var conversationList = []*data.Conversation{
 			"c6e6a2b2-bd25-4151-ace1-611accc15a50",
 		},
 		GameID:    "a2181017-5c53-422b-b6bc-036b27c04fc8",
-		CreatedOn: time.Now().UTC().String(),
-		UpdatedOn: time.Now().UTC().String(),
+		CreatedOn: time.Now().UTC(),
+		UpdatedOn: time.Now().UTC(),
 	},
 }
+
+var attachmentList = []*data.Attachment{}
+
+var scheduledMessageList = []*data.ScheduledMessage{}
@@ -4,69 +4,198 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"os"
+	"net/url"
+	"reflect"
 	"time"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 	"go.opentelemetry.io/otel"
 )
 
-// ErrorEnvVar : Environment variable error
+// ErrorEnvVar : The hostname, port or credentials of MongoDB are missing
 var ErrorEnvVar = fmt.Errorf("missing environment variable")
 
+// namespaceNotFound is the code of the MongoDB error returned for a collection that doesn't exist
+const namespaceNotFound = 26
+
+// indexNotFound is the code of the MongoDB error returned for an index that doesn't exist
+const indexNotFound = 27
+
+// MongoConfig is the configuration of the connection to MongoDB
+// UserServiceURL is the base URL of microservice-user, used to check that the members of the conversations exist
+type MongoConfig struct {
+	URI            string
+	Database       string
+	MaxPoolSize    uint64
+	MinPoolSize    uint64
+	ConnectTimeout time.Duration
+	UserServiceURL string
+}
+
 type MongoTextChat struct {
+	config                  MongoConfig
 	client                  *mongo.Client
 	messagesCollection      *mongo.Collection
 	conversationsCollection *mongo.Collection
+	attachmentsCollection   *mongo.Collection
+	scheduledCollection     *mongo.Collection
+	migrationsCollection    *mongo.Collection
+	migrationLockCollection *mongo.Collection
 }
 
-func NewMongoTextChat() TextChatDB {
-	mp := &MongoTextChat{}
+// NewMongoTextChat connects to MongoDB, the error is returned when the database can't be reached
+func NewMongoTextChat(config MongoConfig) (*MongoTextChat, error) {
+	mp := &MongoTextChat{config: config}
 	err := mp.Connect()
-	// If connect fails, kill the program
 	if err != nil {
 		log.Error(err, "MongoDB setup failed")
-		os.Exit(1)
+		return nil, err
 	}
-	return mp
+	return mp, nil
 }
 
 func (mp *MongoTextChat) Connect() error {
-	uri := mongodbURI()
-
 	// Setting client options
 	opts := options.Client()
-	clientOptions := opts.ApplyURI(uri)
+	clientOptions := opts.ApplyURI(mp.config.URI)
 	opts.Monitor = otelmongo.NewMonitor()
+	opts.SetRegistry(timestampRegistry())
+	if mp.config.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(mp.config.MaxPoolSize)
+	}
+	opts.SetMinPoolSize(mp.config.MinPoolSize)
+	if mp.config.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(mp.config.ConnectTimeout)
+		opts.SetServerSelectionTimeout(mp.config.ConnectTimeout)
+	}
 
 	// Connect to MongoDB
 	client, err := mongo.Connect(context.Background(), clientOptions)
-	if err != nil || client == nil {
-		log.Error(err, "Failed to connect to database. Shutting down service")
-		os.Exit(1)
+	if err != nil {
+		log.Error(err, "Failed to connect to database")
+		return err
 	}
 
 	// Ping DB
 	err = client.Ping(context.Background(), nil)
 	if err != nil {
-		log.Error(err, "Failed to ping database. Shutting down service")
-		os.Exit(1)
+		log.Error(err, "Failed to ping database")
+		_ = client.Disconnect(context.Background())
+		return err
 	}
 
 	log.Info("Connection to MongoDB established")
 
-	messagesCollection := client.Database("ubivius").Collection("messages")
-	conversationsCollection := client.Database("ubivius").Collection("conversations")
+	database := client.Database(mp.config.Database)
+	messagesCollection := database.Collection("messages")
+	conversationsCollection := database.Collection("conversations")
+	attachmentsCollection := database.Collection("attachments")
+	scheduledCollection := database.Collection("scheduled_messages")
+	migrationsCollection := database.Collection("migrations")
+	migrationLockCollection := database.Collection("migration_lock")
 
 	// Assign client and collection to the MongoTextChat struct
 	mp.messagesCollection = messagesCollection
 	mp.conversationsCollection = conversationsCollection
+	mp.attachmentsCollection = attachmentsCollection
+	mp.scheduledCollection = scheduledCollection
+	mp.migrationsCollection = migrationsCollection
+	mp.migrationLockCollection = migrationLockCollection
 	mp.client = client
+
+	// The indexes are created by the migrations
+	return nil
+}
+
+// collectionIndexes are the indexes of a collection
+type collectionIndexes struct {
+	collection *mongo.Collection
+	models     []mongo.IndexModel
+}
+
+// indexes returns the indexes created by the service, by collection
+func (mp *MongoTextChat) indexes() []collectionIndexes {
+	return []collectionIndexes{
+		{
+			collection: mp.messagesCollection,
+			models: []mongo.IndexModel{
+				// The messages of a conversation are read in the order they were created
+				{Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "createdon", Value: 1}}},
+				{Keys: bson.D{{Key: "userid", Value: 1}}},
+				{Keys: bson.D{{Key: "mentioned_user_id", Value: 1}}},
+				{Keys: bson.D{{Key: "text", Value: "text"}}},
+				// The TTL index purges the ephemeral messages once expired, the sweeper usually deletes them before
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+			},
+		},
+		{
+			collection: mp.conversationsCollection,
+			models: []mongo.IndexModel{
+				// The members are an array, so the index has an entry per member
+				{Keys: bson.D{{Key: "userid", Value: 1}}},
+				{Keys: bson.D{{Key: "gameid", Value: 1}}},
+				directKeyIndex(),
+				{Keys: bson.D{{Key: "type", Value: 1}, {Key: "last_message_on", Value: 1}}},
+			},
+		},
+		{
+			collection: mp.attachmentsCollection,
+			models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "message_id", Value: 1}}},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			},
+		},
+		{
+			collection: mp.scheduledCollection,
+			models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "status", Value: 1}, {Key: "send_at", Value: 1}}},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			},
+		},
+		{
+			collection: mp.migrationLockCollection,
+			models: []mongo.IndexModel{
+				// The lock of a replica stopped during the migrations is removed once expired
+				{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+			},
+		},
+	}
+}
+
+// directKeyIndex is the index of the direct conversations, unique so there is only one per pair of users
+// The partial filter leaves out the other conversations, which have no direct key
+func directKeyIndex() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "direct_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"direct_key": bson.M{"$exists": true}}),
+	}
+}
+
+// Reindex drops the indexes of the collections and creates the indexes of the service again
+func (mp *MongoTextChat) Reindex(ctx context.Context) error {
+	for _, collectionIndexes := range mp.indexes() {
+		name := collectionIndexes.collection.Name()
+		_, err := collectionIndexes.collection.Indexes().DropAll(ctx)
+		// A collection that doesn't exist yet has no index to drop
+		if commandError, ok := err.(mongo.CommandError); err != nil && !(ok && commandError.Code == namespaceNotFound) {
+			log.Error(err, "Failed to drop the indexes of a collection", "collection", name)
+			return err
+		}
+		_, err = collectionIndexes.collection.Indexes().CreateMany(ctx, collectionIndexes.models)
+		if err != nil {
+			log.Error(err, "Failed to create the indexes of a collection", "collection", name)
+			return err
+		}
+		log.Info("Rebuilt the indexes of a collection", "collection", name, "indexes", len(collectionIndexes.models))
+	}
 	return nil
 }
 
@@ -83,13 +212,16 @@ func (mp *MongoTextChat) CloseDB() {
 
 func (mp *MongoTextChat) GetMessageByID(ctx context.Context, id string) (*data.Message, error) {
 	// MongoDB search filter
-	filter := bson.D{{Key: "_id", Value: id}}
+	filter := bson.D{{Key: "_id", Value: id}, unexpired(time.Now())}
 
 	// Holds search result
 	var result data.Message
 
 	// Find a single matching item from the database
 	err := mp.messagesCollection.FindOne(ctx, filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, data.ErrorMessageNotFound
+	}
 
 	// Parse result into the returned message
 	return &result, err
@@ -104,6 +236,9 @@ func (mp *MongoTextChat) GetConversationByID(ctx context.Context, id string) (*d
 
 	// Find a single matching item from the database
 	err := mp.conversationsCollection.FindOne(ctx, filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, data.ErrorConversationNotFound
+	}
 
 	// Parse result into the returned conversation
 	return &result, err
@@ -111,7 +246,7 @@ func (mp *MongoTextChat) GetConversationByID(ctx context.Context, id string) (*d
 
 func (mp *MongoTextChat) GetMessagesByConversationID(ctx context.Context, id string) (data.Messages, error) {
 	// MongoDB search filter
-	filter := bson.D{{Key: "conversation_id", Value: id}}
+	filter := bson.D{{Key: "conversation_id", Value: id}, unexpired(time.Now())}
 
 	// messages will hold the array of Messages
 	var messages data.Messages
@@ -142,8 +277,144 @@ func (mp *MongoTextChat) GetMessagesByConversationID(ctx context.Context, id str
 	return messages, err
 }
 
+func (mp *MongoTextChat) GetMentionsByUserID(ctx context.Context, userID string) (data.Messages, error) {
+	// MongoDB search filter, matches any element of the mentioned users array
+	filter := bson.D{{Key: "mentioned_user_id", Value: userID}, unexpired(time.Now())}
+
+	// messages will hold the array of Messages
+	var messages data.Messages
+
+	// Find returns a cursor that must be iterated through
+	cursor, err := mp.messagesCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting mentions by userID from database")
+		return nil, err
+	}
+
+	// Decode every message of the cursor, the cursor is closed once finished
+	err = cursor.All(ctx, &messages)
+	if err != nil {
+		log.Error(err, "Error decoding mentions from database")
+	}
+
+	return messages, err
+}
+
+// StreamMessagesByConversationID calls handle with every message of the conversation, by creation
+// The messages are decoded one at a time, so a long conversation is never loaded fully into memory
+func (mp *MongoTextChat) StreamMessagesByConversationID(ctx context.Context, id string, handle func(message *data.Message) error) error {
+	filter := bson.D{{Key: "conversation_id", Value: id}, unexpired(time.Now())}
+	return mp.streamMessages(ctx, filter, handle)
+}
+
+// StreamMessagesByUserID calls handle with every message authored by the user, by creation
+// The messages are decoded one at a time, so a large history is never loaded fully into memory
+func (mp *MongoTextChat) StreamMessagesByUserID(ctx context.Context, userID string, handle func(message *data.Message) error) error {
+	filter := bson.D{{Key: "userid", Value: userID}, unexpired(time.Now())}
+	return mp.streamMessages(ctx, filter, handle)
+}
+
+// streamMessages calls handle with every message matching the filter, by creation
+func (mp *MongoTextChat) streamMessages(ctx context.Context, filter bson.D, handle func(message *data.Message) error) error {
+	findOptions := options.Find().SetSort(bson.D{{Key: "createdon", Value: 1}})
+
+	cursor, err := mp.messagesCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Error(err, "Error streaming messages from database")
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var message data.Message
+		err = cursor.Decode(&message)
+		if err != nil {
+			log.Error(err, "Error decoding message from database")
+			return err
+		}
+		err = handle(&message)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (mp *MongoTextChat) GetConversationsByUserID(ctx context.Context, userID string, includeArchived bool) (data.Conversations, error) {
+	// MongoDB search filter, matches any element of the members array
+	filter := bson.D{{Key: "userid", Value: userID}}
+	if !includeArchived {
+		filter = append(filter, bson.E{Key: "archived", Value: bson.M{"$ne": true}})
+	}
+
+	// conversations will hold the array of Conversations
+	var conversations data.Conversations
+
+	// Find returns a cursor that must be iterated through
+	cursor, err := mp.conversationsCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting conversations by userID from database")
+		return nil, err
+	}
+
+	// Decode every conversation of the cursor, the cursor is closed once finished
+	err = cursor.All(ctx, &conversations)
+	if err != nil {
+		log.Error(err, "Error decoding conversations from database")
+	}
+
+	return conversations, err
+}
+
+func (mp *MongoTextChat) GetConversationsByGameID(ctx context.Context, gameID string) (data.Conversations, error) {
+	// MongoDB search filter
+	filter := bson.D{{Key: "gameid", Value: gameID}}
+
+	// conversations will hold the array of Conversations
+	var conversations data.Conversations
+
+	// Find returns a cursor that must be iterated through
+	cursor, err := mp.conversationsCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting conversations by gameID from database")
+		return nil, err
+	}
+
+	// Decode every conversation of the cursor, the cursor is closed once finished
+	err = cursor.All(ctx, &conversations)
+	if err != nil {
+		log.Error(err, "Error decoding conversations from database")
+	}
+
+	return conversations, err
+}
+
+func (mp *MongoTextChat) GetConversationsByType(ctx context.Context, conversationType string) (data.Conversations, error) {
+	// Conversations stored without type are group conversations
+	types := bson.A{conversationType}
+	if conversationType == data.ConversationTypeGroup {
+		types = append(types, "", nil)
+	}
+	filter := bson.D{{Key: "type", Value: bson.M{"$in": types}}}
+
+	var conversations data.Conversations
+	cursor, err := mp.conversationsCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting conversations by type from database")
+		return nil, err
+	}
+
+	// Decode every conversation of the cursor, the cursor is closed once finished
+	err = cursor.All(ctx, &conversations)
+	if err != nil {
+		log.Error(err, "Error decoding conversations from database")
+	}
+
+	return conversations, err
+}
+
 func (mp *MongoTextChat) AddMessage(ctx context.Context, message *data.Message) error {
-	_, err := mp.GetConversationByID(ctx, message.ConversationID)
+	conversation, err := mp.GetConversationByID(ctx, message.ConversationID)
 	if err != nil {
 		return err
 	}
@@ -152,18 +423,82 @@ func (mp *MongoTextChat) AddMessage(ctx context.Context, message *data.Message)
 		return data.ErrorUserNotFound
 	}
 
-	message.ID = uuid.NewString()
+	if conversation.Archived {
+		return data.ErrorConversationArchived
+	}
+
+	if conversation.IsMuted(message.UserID) {
+		return data.ErrorUserMuted
+	}
+
+	message.Kind = data.MessageKindUser
+	message.Sender = nil
+	return mp.insertMessage(ctx, conversation, message)
+}
+
+func (mp *MongoTextChat) AddSystemMessage(ctx context.Context, message *data.Message) error {
+	// System and bot messages are sent by service identities, so the sender is not validated against the user service
+	conversation, err := mp.GetConversationByID(ctx, message.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	if conversation.Archived {
+		return data.ErrorConversationArchived
+	}
+
+	return mp.insertMessage(ctx, conversation, message)
+}
+
+func (mp *MongoTextChat) SetMessagePreviews(ctx context.Context, id string, previews []*data.Preview) error {
+	// MongoDB search filter
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.M{"$set": bson.M{"previews": previews}}
+
+	// Update a single item in the database with the values in update that match the filter
+	result, err := mp.messagesCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error setting message previews")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return data.ErrorMessageNotFound
+	}
+
+	return nil
+}
+
+func (mp *MongoTextChat) insertMessage(ctx context.Context, conversation *data.Conversation, message *data.Message) error {
+	// The ID of the message is kept when it is set, so a retried insertion fails instead of duplicating the message
+	if message.ID == "" {
+		message.ID = uuid.NewString()
+	}
+	// BSON dates have a millisecond precision, the returned message has the stored time
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	message.SetRenderMetadata()
+	message.SetExpiry(now, conversation.MessageTTL)
 	// Adding time information to new message
-	message.CreatedOn = time.Now().UTC().String()
-	message.UpdatedOn = time.Now().UTC().String()
+	message.CreatedOn = now
+	message.UpdatedOn = now
 
 	// Inserting the new message into the database
 	insertResult, err := mp.messagesCollection.InsertOne(ctx, message)
+	if mongo.IsDuplicateKeyError(err) {
+		return data.ErrorMessageExists
+	}
 	if err != nil {
 		return err
 	}
 
 	log.Info("Inserting message", "Inserted ID", insertResult.InsertedID)
+
+	// The last message is the activity used to archive inactive conversations
+	filter := bson.D{{Key: "_id", Value: message.ConversationID}}
+	update := bson.M{"$set": bson.M{"last_message_on": message.CreatedOn}}
+	_, err = mp.conversationsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error updating last message of conversation")
+	}
 	return nil
 }
 
@@ -178,13 +513,44 @@ func (mp *MongoTextChat) AddConversation(ctx context.Context, conversation *data
 		return nil, data.ErrorGameNotFound
 	}
 
-	conversation.ID = uuid.NewString()
+	// Direct conversations are unique per pair of users, the existing one is returned
+	if conversation.ConversationType() == data.ConversationTypeDirect {
+		conversation.DirectKey = data.NewDirectKey(conversation.UserID)
+
+		var existing data.Conversation
+		filter := bson.D{{Key: "direct_key", Value: conversation.DirectKey}}
+		err := mp.conversationsCollection.FindOne(ctx, filter).Decode(&existing)
+		if err == nil {
+			return &existing, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	// Conversations created with an ID keep it, so the game server can create them idempotently
+	if conversation.ID == "" {
+		conversation.ID = uuid.NewString()
+	}
+	// A new conversation starts at the first version, whatever version was received
+	conversation.Version = 0
 	// Adding time information to new conversation
-	conversation.CreatedOn = time.Now().UTC().String()
-	conversation.UpdatedOn = time.Now().UTC().String()
+	conversation.CreatedOn = time.Now().UTC().Truncate(time.Millisecond)
+	conversation.UpdatedOn = conversation.CreatedOn
 
 	// Inserting the new conversation into the database
 	insertResult, err := mp.conversationsCollection.InsertOne(ctx, conversation)
+	if mongo.IsDuplicateKeyError(err) {
+		// The direct conversation was created by a concurrent request between the search and the insertion
+		if conversation.DirectKey != "" {
+			var existing data.Conversation
+			filter := bson.D{{Key: "direct_key", Value: conversation.DirectKey}}
+			if mp.conversationsCollection.FindOne(ctx, filter).Decode(&existing) == nil {
+				return &existing, nil
+			}
+		}
+		return nil, data.ErrorConversationExists
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +560,54 @@ func (mp *MongoTextChat) AddConversation(ctx context.Context, conversation *data
 	return conversation, nil
 }
 
+// ImportConversation stores a conversation with its original ID and timestamps
+func (mp *MongoTextChat) ImportConversation(ctx context.Context, conversation *data.Conversation) error {
+	if conversation.ConversationType() == data.ConversationTypeDirect {
+		conversation.DirectKey = data.NewDirectKey(conversation.UserID)
+
+		// The conversation itself is found when its import is retried
+		filter := bson.D{{Key: "direct_key", Value: conversation.DirectKey}, {Key: "_id", Value: bson.M{"$ne": conversation.ID}}}
+		count, err := mp.conversationsCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return data.ErrorDirectConversationExists
+		}
+	}
+
+	_, err := mp.conversationsCollection.InsertOne(ctx, conversation)
+	if mongo.IsDuplicateKeyError(err) {
+		return data.ErrorConversationExists
+	}
+	return err
+}
+
+// ImportMessage stores a message with its original ID and timestamps, its conversation must exist
+func (mp *MongoTextChat) ImportMessage(ctx context.Context, message *data.Message) error {
+	_, err := mp.GetConversationByID(ctx, message.ConversationID)
+	if err != nil {
+		return err
+	}
+
+	_, err = mp.messagesCollection.InsertOne(ctx, message)
+	if mongo.IsDuplicateKeyError(err) {
+		return data.ErrorMessageExists
+	}
+	if err != nil {
+		return err
+	}
+
+	// Messages are not imported in order, the last message is the most recent one
+	filter := bson.D{{Key: "_id", Value: message.ConversationID}}
+	update := bson.M{"$max": bson.M{"last_message_on": message.CreatedOn}}
+	_, err = mp.conversationsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error updating last message of imported conversation")
+	}
+	return nil
+}
+
 func (mp *MongoTextChat) DeleteMessage(ctx context.Context, id string) error {
 	// MongoDB search filter
 	filter := bson.D{{Key: "_id", Value: id}}
@@ -202,12 +616,201 @@ func (mp *MongoTextChat) DeleteMessage(ctx context.Context, id string) error {
 	result, err := mp.messagesCollection.DeleteOne(ctx, filter)
 	if err != nil {
 		log.Error(err, "Error deleting message")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return data.ErrorMessageNotFound
 	}
 
 	log.Info("Deleted documents in messages collection", "delete_count", result.DeletedCount)
+
+	// A deleted message is no longer pinned, the message is deleted even when the unpin fails
+	pinFilter := bson.D{{Key: "pinned_message_id", Value: id}}
+	update := bson.M{"$pull": bson.M{"pinned_message_id": id}, "$inc": bson.M{"version": 1}}
+	_, err = mp.conversationsCollection.UpdateMany(ctx, pinFilter, update)
+	if err != nil {
+		log.Error(err, "Error unpinning deleted message")
+	}
 	return nil
 }
 
+// DeleteExpiredMessages deletes and returns the messages expired at the given time, before the TTL index purges them
+func (mp *MongoTextChat) DeleteExpiredMessages(ctx context.Context, now time.Time) (data.Messages, error) {
+	filter := bson.D{{Key: "expires_at", Value: bson.M{"$lte": now}}}
+
+	var expired data.Messages
+	cursor, err := mp.messagesCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting expired messages from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &expired)
+	if err != nil {
+		log.Error(err, "Error decoding expired messages from database")
+		return nil, err
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+
+	ids := make([]string, 0, len(expired))
+	for _, message := range expired {
+		ids = append(ids, message.ID)
+	}
+	result, err := mp.messagesCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Error(err, "Error deleting expired messages")
+		return nil, err
+	}
+	log.Info("Deleted expired messages", "delete_count", result.DeletedCount)
+
+	// A deleted message is no longer pinned
+	mp.unpinMessages(ctx, ids)
+	return expired, nil
+}
+
+func (mp *MongoTextChat) CountMessagesForRetention(ctx context.Context, query *data.RetentionQuery) (int64, error) {
+	count, err := mp.messagesCollection.CountDocuments(ctx, retentionFilter(query))
+	if err != nil {
+		log.Error(err, "Error counting messages for retention")
+	}
+	return count, err
+}
+
+func (mp *MongoTextChat) GetMessagesForRetention(ctx context.Context, query *data.RetentionQuery, limit int) (data.Messages, error) {
+	findOptions := options.Find().SetLimit(int64(limit))
+
+	var messages data.Messages
+	cursor, err := mp.messagesCollection.Find(ctx, retentionFilter(query), findOptions)
+	if err != nil {
+		log.Error(err, "Error getting messages for retention from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &messages)
+	if err != nil {
+		log.Error(err, "Error decoding messages from database")
+	}
+	return messages, err
+}
+
+// PurgeMessages deletes the messages and unpins them
+func (mp *MongoTextChat) PurgeMessages(ctx context.Context, ids []string) (int64, error) {
+	result, err := mp.messagesCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Error(err, "Error purging messages")
+		return 0, err
+	}
+	mp.unpinMessages(ctx, ids)
+	return result.DeletedCount, nil
+}
+
+// TombstoneMessages removes the content of the messages and unpins them
+func (mp *MongoTextChat) TombstoneMessages(ctx context.Context, ids []string, deletedOn time.Time) (int64, error) {
+	filter := bson.M{"_id": bson.M{"$in": ids}, "deleted_on": bson.M{"$exists": false}}
+	update := bson.M{
+		"$set": bson.M{"text": "", "deleted_on": deletedOn.UTC(), "updatedon": deletedOn.UTC()},
+		"$unset": bson.M{
+			"entities":          "",
+			"attachments":       "",
+			"previews":          "",
+			"mentions":          "",
+			"mentioned_user_id": "",
+		},
+	}
+	result, err := mp.messagesCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error tombstoning messages")
+		return 0, err
+	}
+	mp.unpinMessages(ctx, ids)
+	return result.ModifiedCount, nil
+}
+
+// AnonymizeUserMessages replaces the author of the messages and attachments of the user by the erased user
+func (mp *MongoTextChat) AnonymizeUserMessages(ctx context.Context, userID string) (int64, error) {
+	filter := bson.M{"userid": userID}
+	update := bson.M{"$set": bson.M{"userid": data.ErasedUserID, "updatedon": time.Now().UTC()}}
+	result, err := mp.messagesCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error anonymizing messages of user")
+		return 0, err
+	}
+
+	_, err = mp.attachmentsCollection.UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"user_id": data.ErasedUserID}})
+	if err != nil {
+		log.Error(err, "Error anonymizing attachments of user")
+		return result.ModifiedCount, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// DeleteUserMessages deletes and returns the messages of the user, expired messages included
+func (mp *MongoTextChat) DeleteUserMessages(ctx context.Context, userID string) (data.Messages, error) {
+	filter := bson.M{"userid": userID}
+
+	var messages data.Messages
+	cursor, err := mp.messagesCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting messages of user from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &messages)
+	if err != nil {
+		log.Error(err, "Error decoding messages of user from database")
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	result, err := mp.messagesCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Error(err, "Error deleting messages of user")
+		return nil, err
+	}
+	log.Info("Deleted messages of user", "delete_count", result.DeletedCount)
+
+	// A deleted message is no longer pinned
+	mp.unpinMessages(ctx, ids)
+	return messages, nil
+}
+
+// RemoveUserMentions removes the user from the mentions of every message
+func (mp *MongoTextChat) RemoveUserMentions(ctx context.Context, userID string) (int64, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"mentioned_user_id": userID},
+		bson.M{"mentions.user_id": userID},
+	}}
+	update := bson.M{
+		"$pull": bson.M{"mentioned_user_id": userID},
+		"$set":  bson.M{"mentions.$[mention].user_id": data.ErasedUserID},
+	}
+	updateOptions := options.Update().SetArrayFilters(options.ArrayFilters{
+		Filters: []interface{}{bson.M{"mention.user_id": userID}},
+	})
+
+	result, err := mp.messagesCollection.UpdateMany(ctx, filter, update, updateOptions)
+	if err != nil {
+		log.Error(err, "Error removing mentions of user")
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// unpinMessages removes the deleted messages from the pinned messages of every conversation
+func (mp *MongoTextChat) unpinMessages(ctx context.Context, ids []string) {
+	filter := bson.M{"pinned_message_id": bson.M{"$in": ids}}
+	update := bson.M{"$pull": bson.M{"pinned_message_id": bson.M{"$in": ids}}, "$inc": bson.M{"version": 1}}
+	_, err := mp.conversationsCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error unpinning deleted messages")
+	}
+}
+
 func (mp *MongoTextChat) DeleteConversation(ctx context.Context, id string) error {
 	// MongoDB search filter
 	filter := bson.D{{Key: "_id", Value: id}}
@@ -216,6 +819,10 @@ func (mp *MongoTextChat) DeleteConversation(ctx context.Context, id string) erro
 	result, err := mp.conversationsCollection.DeleteOne(ctx, filter)
 	if err != nil {
 		log.Error(err, "Error deleting conversation")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return data.ErrorConversationNotFound
 	}
 
 	log.Info("Deleted documents in conversations collection", "delete_count", result.DeletedCount)
@@ -235,22 +842,335 @@ func (mp *MongoTextChat) AddUserToConversation(ctx context.Context, conversation
 		return data.ErrorGameNotFound
 	}
 
-	conversation.UpdatedOn = time.Now().UTC().String()
-	// MongoDB search filter
-	filter := bson.D{{Key: "_id", Value: conversation.ID}}
-	update := bson.M{"$set": conversation}
+	// The rules of the stored conversation type apply to the new members
+	existing, err := mp.GetConversationByID(ctx, conversation.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Version != conversation.Version {
+		return data.ErrorConversationConflict
+	}
+	err = existing.ValidateMemberChange(conversation.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Roles of removed members are dropped and a new owner is chosen when the owner leaves
+	existing.SetMembers(conversation.UserID)
+	existing.UpdatedOn = time.Now().UTC()
+	// MongoDB search filter, the update only applies to the version that was read
+	filter := bson.D{{Key: "_id", Value: conversation.ID}, {Key: "version", Value: conversation.Version}}
+	if conversation.Version == 0 {
+		// Conversations created before versions existed don't have the field
+		filter[1].Value = bson.M{"$in": bson.A{0, nil}}
+	}
+	// Only the members are updated, the type and game of the conversation can't change
+	update := bson.M{
+		"$set": bson.M{"userid": existing.UserID, "roles": existing.Roles, "updatedon": existing.UpdatedOn},
+		"$inc": bson.M{"version": 1},
+	}
 
 	// Update a single item in the database with the values in update that match the filter
-	_, err := mp.conversationsCollection.UpdateOne(ctx, filter, update)
+	result, err := mp.conversationsCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		log.Error(err, "Error updating conversation.")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// The conversation was deleted or updated since it was read
+		_, err = mp.GetConversationByID(ctx, conversation.ID)
+		if err != nil {
+			return err
+		}
+		return data.ErrorConversationConflict
+	}
+	return nil
+}
+
+func (mp *MongoTextChat) UpdateConversation(ctx context.Context, conversation *data.Conversation) error {
+	// MongoDB search filter, the update only applies to the version that was read
+	filter := bson.D{{Key: "_id", Value: conversation.ID}, {Key: "version", Value: conversation.Version}}
+	if conversation.Version == 0 {
+		// Conversations created before versions existed don't have the field
+		filter[1].Value = bson.M{"$in": bson.A{0, nil}}
+	}
+	updated := *conversation
+	updated.Version++
+	updated.UpdatedOn = time.Now().UTC()
+
+	// The document is replaced, so the fields cleared by the update are removed instead of being left unchanged
+	result, err := mp.conversationsCollection.ReplaceOne(ctx, filter, &updated)
+	if err != nil {
+		log.Error(err, "Error updating conversation.")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		// The conversation was deleted or updated since it was read
+		_, err = mp.GetConversationByID(ctx, conversation.ID)
+		if err != nil {
+			return err
+		}
+		return data.ErrorConversationConflict
 	}
 
+	*conversation = updated
+	return nil
+}
+
+func (mp *MongoTextChat) ArchiveInactiveConversations(ctx context.Context, types []string, inactiveSince time.Time) (int64, error) {
+	// Conversations without messages are inactive since their last update
+	// Timestamps still stored as strings are only compared once migrated to dates
+	before := inactiveSince.UTC()
+	filter := bson.M{
+		"type":     bson.M{"$in": types},
+		"archived": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"last_message_on": bson.M{"$lt": before}},
+			bson.M{"last_message_on": bson.M{"$exists": false}, "updatedon": bson.M{"$lt": before}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"archived": true, "archived_on": time.Now().UTC()},
+		"$inc": bson.M{"version": 1},
+	}
+
+	result, err := mp.conversationsCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error archiving inactive conversations")
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (mp *MongoTextChat) AddScheduledMessage(ctx context.Context, scheduled *data.ScheduledMessage) error {
+	// BSON dates have a millisecond precision, the returned scheduled message has the stored time
+	scheduled.CreatedOn = time.Now().UTC().Truncate(time.Millisecond)
+	scheduled.UpdatedOn = scheduled.CreatedOn
+
+	insertResult, err := mp.scheduledCollection.InsertOne(ctx, scheduled)
+	if err != nil {
+		log.Error(err, "Error inserting scheduled message")
+		return err
+	}
+
+	log.Info("Inserting scheduled message", "Inserted ID", insertResult.InsertedID)
+	return nil
+}
+
+func (mp *MongoTextChat) GetScheduledMessageByID(ctx context.Context, id string) (*data.ScheduledMessage, error) {
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	var result data.ScheduledMessage
+	err := mp.scheduledCollection.FindOne(ctx, filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, data.ErrorScheduledMessageNotFound
+	}
+	return &result, err
+}
+
+// GetScheduledMessagesByUserID returns the pending scheduled messages of the user, by send time
+func (mp *MongoTextChat) GetScheduledMessagesByUserID(ctx context.Context, userID string) (data.ScheduledMessages, error) {
+	filter := bson.D{{Key: "user_id", Value: userID}, {Key: "status", Value: data.ScheduleStatusPending}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "send_at", Value: 1}})
+
+	scheduledMessages := data.ScheduledMessages{}
+	cursor, err := mp.scheduledCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Error(err, "Error getting scheduled messages by userID from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &scheduledMessages)
+	if err != nil {
+		log.Error(err, "Error decoding scheduled messages from database")
+	}
+	return scheduledMessages, err
+}
+
+// CancelScheduledMessage cancels a pending scheduled message, a message being sent can't be cancelled
+func (mp *MongoTextChat) CancelScheduledMessage(ctx context.Context, id string) error {
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "status", Value: data.ScheduleStatusPending}}
+	update := bson.M{"$set": bson.M{"status": data.ScheduleStatusCancelled, "updated_on": time.Now().UTC()}}
+
+	result, err := mp.scheduledCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error cancelling scheduled message")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return data.ErrorScheduledMessageNotFound
+	}
+	return nil
+}
+
+// ClaimScheduledMessages marks the due messages as being sent and returns them
+// Every message is claimed by a single update, so concurrent dispatchers never claim the same message
+// Messages claimed before claimedBefore are claimed again, their dispatcher stopped before completing them
+func (mp *MongoTextChat) ClaimScheduledMessages(ctx context.Context, now time.Time, claimedBefore time.Time) (data.ScheduledMessages, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": data.ScheduleStatusPending, "send_at": bson.M{"$lte": now}},
+		bson.M{"status": data.ScheduleStatusSending, "claimed_on": bson.M{"$lt": claimedBefore}},
+	}}
+	update := bson.M{"$set": bson.M{"status": data.ScheduleStatusSending, "claimed_on": now}}
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(bson.D{{Key: "send_at", Value: 1}})
+
+	claimed := data.ScheduledMessages{}
+	for {
+		var result data.ScheduledMessage
+		err := mp.scheduledCollection.FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&result)
+		if err == mongo.ErrNoDocuments {
+			return claimed, nil
+		}
+		if err != nil {
+			log.Error(err, "Error claiming scheduled messages")
+			return claimed, err
+		}
+		claimed = append(claimed, &result)
+	}
+}
+
+// CompleteScheduledMessage sets the final status of a claimed scheduled message
+func (mp *MongoTextChat) CompleteScheduledMessage(ctx context.Context, id string, status string, reason string) error {
+	filter := bson.D{{Key: "_id", Value: id}}
+	update := bson.M{
+		"$set":   bson.M{"status": status, "error": reason, "updated_on": time.Now().UTC()},
+		"$unset": bson.M{"claimed_on": ""},
+	}
+
+	result, err := mp.scheduledCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error completing scheduled message")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return data.ErrorScheduledMessageNotFound
+	}
+	return nil
+}
+
+// DeleteUserScheduledMessages deletes and returns the scheduled messages of the user, whatever their status
+func (mp *MongoTextChat) DeleteUserScheduledMessages(ctx context.Context, userID string) (data.ScheduledMessages, error) {
+	filter := bson.M{"user_id": userID}
+
+	scheduledMessages := data.ScheduledMessages{}
+	cursor, err := mp.scheduledCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting scheduled messages of user from database")
+		return nil, err
+	}
+	err = cursor.All(ctx, &scheduledMessages)
+	if err != nil {
+		log.Error(err, "Error decoding scheduled messages of user from database")
+		return nil, err
+	}
+
+	result, err := mp.scheduledCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error deleting scheduled messages of user")
+		return nil, err
+	}
+	log.Info("Deleted scheduled messages of user", "delete_count", result.DeletedCount)
+	return scheduledMessages, nil
+}
+
+func (mp *MongoTextChat) AddAttachment(ctx context.Context, attachment *data.Attachment) error {
+	// Adding time information to new attachment
+	// BSON dates have a millisecond precision, the returned attachment has the stored time
+	attachment.CreatedOn = time.Now().UTC().Truncate(time.Millisecond)
+
+	// Inserting the new attachment into the database
+	insertResult, err := mp.attachmentsCollection.InsertOne(ctx, attachment)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Inserting attachment", "Inserted ID", insertResult.InsertedID)
+	return nil
+}
+
+func (mp *MongoTextChat) GetAttachmentByID(ctx context.Context, id string) (*data.Attachment, error) {
+	// MongoDB search filter
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	// Holds search result
+	var result data.Attachment
+
+	// Find a single matching item from the database
+	err := mp.attachmentsCollection.FindOne(ctx, filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, data.ErrorAttachmentNotFound
+	}
+
+	// Parse result into the returned attachment
+	return &result, err
+}
+
+func (mp *MongoTextChat) GetAttachmentsByMessageID(ctx context.Context, messageID string) (data.Attachments, error) {
+	// MongoDB search filter
+	filter := bson.D{{Key: "message_id", Value: messageID}}
+
+	// attachments will hold the array of Attachments
+	var attachments data.Attachments
+
+	// Find returns a cursor that must be iterated through
+	cursor, err := mp.attachmentsCollection.Find(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error getting attachments by messageID from database")
+		return nil, err
+	}
+
+	// Decode every attachment of the cursor, the cursor is closed once finished
+	err = cursor.All(ctx, &attachments)
+	if err != nil {
+		log.Error(err, "Error decoding attachments from database")
+	}
+
+	return attachments, err
+}
+
+func (mp *MongoTextChat) LinkAttachment(ctx context.Context, id string, messageID string) error {
+	// MongoDB search filter, only an attachment not linked yet is claimed so concurrent messages can't share it
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "message_id", Value: bson.M{"$exists": false}}}
+	update := bson.M{"$set": bson.M{"message_id": messageID}}
+
+	// Update a single item in the database with the values in update that match the filter
+	result, err := mp.attachmentsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error(err, "Error linking attachment")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := mp.attachmentsCollection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}})
+		if err != nil {
+			log.Error(err, "Error counting attachments")
+			return err
+		}
+		if count == 0 {
+			return data.ErrorAttachmentNotFound
+		}
+		return data.ErrorAttachmentLinked
+	}
+
+	return nil
+}
+
+func (mp *MongoTextChat) DeleteAttachment(ctx context.Context, id string) error {
+	// MongoDB search filter
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	// Delete a single item matching the filter
+	result, err := mp.attachmentsCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		log.Error(err, "Error deleting attachment")
+		return err
+	}
+
+	log.Info("Deleted documents in attachments collection", "delete_count", result.DeletedCount)
 	return nil
 }
 
 func (mp *MongoTextChat) validateUserExist(userID string) bool {
-	getUserByIDPath := data.MicroserviceUserPath + "/users/" + userID
+	getUserByIDPath := mp.config.UserServiceURL + "/users/" + userID
 	resp, err := http.Get(getUserByIDPath)
 	return err == nil && resp.StatusCode == 200
 }
@@ -260,35 +1180,66 @@ func (mp *MongoTextChat) validateGameExist(gameID string) bool {
 	return true
 }
 
-func deleteAllConversationsAndMessagesFromMongoDB() (error, error) {
-	uri := mongodbURI()
-
-	// Setting client options
-	opts := options.Client()
-	clientOptions := opts.ApplyURI(uri)
-	client, err := mongo.Connect(context.Background(), clientOptions)
-	if err != nil || client == nil {
-		log.Error(err, "Failed to connect to database. Failing test")
-		return err, nil
+// retentionFilter is the filter of the messages selected by a retention query
+// The messages with a creation time still stored as a string are only selected once migrated to dates
+func retentionFilter(query *data.RetentionQuery) bson.M {
+	filter := bson.M{
+		"conversation_id": bson.M{"$in": query.ConversationID},
+		"createdon":       bson.M{"$lt": query.CreatedBefore},
+		"deleted_on":      bson.M{"$exists": false},
 	}
-	messagesCollection := client.Database("ubivius").Collection("messages")
-	conversationsCollection := client.Database("ubivius").Collection("conversations")
+	switch query.Kind {
+	case "":
+	case data.MessageKindUser:
+		// Messages stored without kind are user messages
+		filter["kind"] = bson.M{"$in": bson.A{data.MessageKindUser, "", nil}}
+	default:
+		filter["kind"] = query.Kind
+	}
+	return filter
+}
 
-	_, err1 := messagesCollection.DeleteMany(context.Background(), bson.D{{}})
-	_, err2 := conversationsCollection.DeleteMany(context.Background(), bson.D{{}})
-	return err1, err2
+// unexpired is the filter excluding the ephemeral messages expired at the given time
+func unexpired(now time.Time) bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": now}},
+	}}
 }
 
-func mongodbURI() string {
-	hostname := os.Getenv("DB_HOSTNAME")
-	port := os.Getenv("DB_PORT")
-	username := os.Getenv("DB_USERNAME")
-	password := os.Getenv("DB_PASSWORD")
+// timestampRegistry returns the BSON registry decoding the timestamps stored as strings before they were stored as dates
+// The strings are read until the timestamps migration converts them
+func timestampRegistry() *bsoncodec.Registry {
+	timeCodec := bsoncodec.NewTimeCodec()
+	decodeTimestamp := func(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if vr.Type() != bsontype.String {
+			return timeCodec.DecodeValue(dc, vr, val)
+		}
+		value, err := vr.ReadString()
+		if err != nil {
+			return err
+		}
+		timestamp := time.Time{}
+		if value != "" {
+			timestamp, err = data.ParseTimestamp(value)
+			if err != nil {
+				log.Error(err, "Invalid timestamp decoded as the zero time", "timestamp", value)
+				timestamp = time.Time{}
+			}
+		}
+		val.Set(reflect.ValueOf(timestamp.UTC()))
+		return nil
+	}
+	return bson.NewRegistryBuilder().
+		RegisterTypeDecoder(reflect.TypeOf(time.Time{}), bsoncodec.ValueDecoderFunc(decodeTimestamp)).
+		Build()
+}
 
+// MongoURI returns the URI of a MongoDB server authenticated on the admin database
+func MongoURI(hostname string, port string, username string, password string) (string, error) {
 	if hostname == "" || port == "" || username == "" || password == "" {
-		log.Error(ErrorEnvVar, "Some environment variables are not available for the DB connection. DB_HOSTNAME, DB_PORT, DB_USERNAME, DB_PASSWORD")
-		os.Exit(1)
+		return "", ErrorEnvVar
 	}
-
-	return "mongodb://" + username + ":" + password + "@" + hostname + ":" + port + "/?authSource=admin"
+	credentials := url.UserPassword(username, password).String()
+	return "mongodb://" + credentials + "@" + hostname + ":" + port + "/?authSource=admin", nil
 }
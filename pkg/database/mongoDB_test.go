@@ -4,8 +4,12 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func integrationTestSetup(t *testing.T) {
@@ -24,19 +28,55 @@ func integrationTestSetup(t *testing.T) {
 		os.Setenv("DB_HOSTNAME", "localhost")
 	}
 
-	err1, err2 := deleteAllConversationsAndMessagesFromMongoDB()
+	err1, err2 := deleteAllConversationsAndMessagesFromMongoDB(testMongoConfig(t))
 	if err1 != nil || err2 != nil {
 		t.Errorf("Failed to delete existing items from database during setup")
 	}
 }
 
+// testMongoConfig is the configuration of the database of the environment set by the test setup
+func testMongoConfig(t *testing.T) MongoConfig {
+	uri, err := MongoURI(os.Getenv("DB_HOSTNAME"), os.Getenv("DB_PORT"), os.Getenv("DB_USERNAME"), os.Getenv("DB_PASSWORD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MongoConfig{URI: uri, Database: "ubivius", UserServiceURL: "http://microservice-user:9090"}
+}
+
+// newTestMongoTextChat connects to the database of the environment set by the test setup
+func newTestMongoTextChat(t *testing.T) *MongoTextChat {
+	mp, err := NewMongoTextChat(testMongoConfig(t))
+	if err != nil {
+		t.Fatal("Failed to connect to database with error : " + err.Error())
+	}
+	return mp
+}
+
+// deleteAllConversationsAndMessagesFromMongoDB empties the messages and conversations of the database of the configuration
+func deleteAllConversationsAndMessagesFromMongoDB(config MongoConfig) (error, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.URI))
+	if err != nil || client == nil {
+		log.Error(err, "Failed to connect to database. Failing test")
+		return err, nil
+	}
+	defer func() {
+		_ = client.Disconnect(context.Background())
+	}()
+	messagesCollection := client.Database(config.Database).Collection("messages")
+	conversationsCollection := client.Database(config.Database).Collection("conversations")
+
+	_, err1 := messagesCollection.DeleteMany(context.Background(), bson.D{{}})
+	_, err2 := conversationsCollection.DeleteMany(context.Background(), bson.D{{}})
+	return err1, err2
+}
+
 func TestMongoDBConnectionAndShutdownIntegration(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Test skipped during unit tests")
 	}
 	integrationTestSetup(t)
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	if mp == nil {
 		t.Fail()
 	}
@@ -55,7 +95,7 @@ func TestMongoDBAddMessageIntegration(t *testing.T) {
 		Text:           "testText",
 	}
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	err := mp.AddMessage(context.Background(), message)
 	if err != nil {
 		t.Errorf("Failed to add message to database")
@@ -91,7 +131,7 @@ func TestMongoDBAddConversationIntegration(t *testing.T) {
 		GameID: "",
 	}
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	_, err := mp.AddConversation(context.Background(), conversation)
 	if err != nil {
 		t.Errorf("Failed to add conversation to database")
@@ -105,7 +145,7 @@ func TestMongoDBGetMessageByIDIntegration(t *testing.T) {
 	}
 	integrationTestSetup(t)
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	_, err := mp.GetMessageByID(context.Background(), "a2181017-5c53-422b-b6bc-036b27c04fc8")
 	if err != nil {
 		t.Error("Error getting message from database")
@@ -120,7 +160,7 @@ func TestMongoDBGetConversationByIDIntegration(t *testing.T) {
 	}
 	integrationTestSetup(t)
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	_, err := mp.GetConversationByID(context.Background(), "a2181017-5c53-422b-b6bc-036b27c04fc8")
 	if err != nil {
 		t.Fail()
@@ -135,7 +175,7 @@ func TestMongoDBGetMessagesByConversationIDIntegration(t *testing.T) {
 	}
 	integrationTestSetup(t)
 
-	mp := NewMongoTextChat()
+	mp := newTestMongoTextChat(t)
 	_, err := mp.GetMessagesByConversationID(context.Background(), "a2181017-5c53-422b-b6bc-036b27c04fc8")
 	if err != nil {
 		t.Fail()
@@ -143,3 +183,127 @@ func TestMongoDBGetMessagesByConversationIDIntegration(t *testing.T) {
 
 	mp.CloseDB()
 }
+
+func TestMongoDBDeleteMessageIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Test skipped during unit tests")
+	}
+	integrationTestSetup(t)
+
+	message := &data.Message{
+		ID:             "4f0c8a2e-7d1b-4b8e-9f3a-6c2d1e0b9a87",
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		Text:           "testText",
+	}
+
+	mp := newTestMongoTextChat(t)
+	_, err := mp.messagesCollection.InsertOne(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mp.DeleteMessage(context.Background(), message.ID)
+	if err != nil {
+		t.Errorf("Failed to delete message with error : %v", err)
+	}
+	err = mp.DeleteMessage(context.Background(), message.ID)
+	if err != data.ErrorMessageNotFound {
+		t.Errorf("Expected message not found for a deleted message, got %v", err)
+	}
+	mp.CloseDB()
+}
+
+func TestMigrationVersions(t *testing.T) {
+	for i, migration := range migrations() {
+		if migration.version != i+1 || migration.description == "" || migration.apply == nil {
+			t.Errorf("Expected the migrations to be numbered from 1 in order, got version %d at %d", migration.version, i)
+		}
+	}
+}
+
+func TestMongoDBMigrateIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Test skipped during unit tests")
+	}
+	integrationTestSetup(t)
+
+	mp := newTestMongoTextChat(t)
+	_, err := mp.Migrate(context.Background())
+	if err != nil {
+		t.Fatal("Failed to migrate database with error : " + err.Error())
+	}
+
+	// The migrations are applied once
+	applied, err := mp.Migrate(context.Background())
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Expected no pending migration, got %d, %v", len(applied), err)
+	}
+	statuses, err := mp.MigrationStatus(context.Background())
+	if err != nil || len(statuses) != len(migrations()) {
+		t.Fatalf("Unexpected migration status %v, %v", statuses, err)
+	}
+	for _, status := range statuses {
+		if status.AppliedOn == nil {
+			t.Errorf("Expected migration %d to be applied", status.Version)
+		}
+	}
+
+	// A lock held by another replica is not taken over before it expires
+	err = mp.lockMigrations(context.Background(), "replica-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.lockMigrations(context.Background(), "replica-b"); err != ErrorMigrationLocked {
+		t.Errorf("Expected the migrations to be locked, got %v", err)
+	}
+
+	// The lease is only extended for the owner of the lock
+	_, err = mp.migrationLockCollection.UpdateOne(context.Background(), bson.M{"_id": migrationLockID}, bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(-time.Second)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.extendMigrationLock(context.Background(), "replica-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = mp.lockMigrations(context.Background(), "replica-b"); err != ErrorMigrationLocked {
+		t.Errorf("Expected the extended lock to be kept, got %v", err)
+	}
+	mp.unlockMigrations("replica-a")
+	mp.CloseDB()
+}
+
+func TestTimestampRegistry(t *testing.T) {
+	timestamps := struct {
+		CreatedOn     time.Time
+		UpdatedOn     time.Time
+		LastMessageOn *time.Time
+	}{}
+	document := bson.M{
+		"createdon":     "2020-05-01 22:01:00.5 +0000 UTC",
+		"updatedon":     time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC),
+		"lastmessageon": "2020-05-01T18:01:00-04:00",
+	}
+	body, err := bson.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bson.UnmarshalWithRegistry(timestampRegistry(), body, &timestamps)
+	if err != nil {
+		t.Fatalf("Expected the string timestamps to be decoded, got %v", err)
+	}
+	if !timestamps.CreatedOn.Equal(time.Date(2020, 5, 1, 22, 1, 0, 500000000, time.UTC)) || !timestamps.UpdatedOn.Equal(time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamps %+v", timestamps)
+	}
+	if timestamps.LastMessageOn == nil || !timestamps.LastMessageOn.Equal(time.Date(2020, 5, 1, 22, 1, 0, 0, time.UTC)) {
+		t.Errorf("Expected the optional timestamp to be decoded, got %v", timestamps.LastMessageOn)
+	}
+
+	body, err = bson.Marshal(bson.M{"createdon": "yesterday"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bson.UnmarshalWithRegistry(timestampRegistry(), body, &timestamps)
+	if err != nil || !timestamps.CreatedOn.IsZero() {
+		t.Errorf("Expected an invalid timestamp to be decoded as the zero time, got %v, %v", timestamps.CreatedOn, err)
+	}
+}
@@ -0,0 +1,200 @@
+package erasure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/google/uuid"
+)
+
+// ErrorInvalidPolicy : The erasure policy doesn't exist
+var ErrorInvalidPolicy = fmt.Errorf("invalid erasure policy")
+
+// Policies applied to the messages of an erased user
+// Anonymized messages are kept without their author, so the conversations of the other members stay readable
+const (
+	PolicyAnonymize = "anonymize"
+	PolicyDelete    = "delete"
+)
+
+// DefaultPolicy is the policy applied when the erasure doesn't specify one
+const DefaultPolicy = PolicyAnonymize
+
+// maxConflictRetries is the number of times a conversation updated concurrently is read again before the erasure fails
+const maxConflictRetries = 3
+
+// IsValidPolicy returns true when the erasure policy exists
+func IsValidPolicy(policy string) bool {
+	return policy == PolicyAnonymize || policy == PolicyDelete
+}
+
+// Report is the audit record of the erasure of a user
+type Report struct {
+	ID                    string     `json:"id"`
+	UserID                string     `json:"user_id"`
+	Policy                string     `json:"policy"`
+	Messages              int64      `json:"messages"`
+	Mentions              int64      `json:"mentions"`
+	ScheduledMessages     int64      `json:"scheduled_messages"`
+	LeftConversationID    []string   `json:"left_conversation_id"`
+	DeletedConversationID []string   `json:"deleted_conversation_id"`
+	StartedOn             time.Time  `json:"started_on"`
+	CompletedOn           *time.Time `json:"completed_on,omitempty"`
+}
+
+// Eraser removes every reference to a user from the conversations and messages
+// An erasure is idempotent, an erasure that failed is completed by running it again
+type Eraser struct {
+	db          database.TextChatDB
+	attachments *attachments.Service
+	policy      string
+}
+
+// NewEraser creates an eraser applying the policy by default, the attachments of the deleted messages are deleted with the attachment service
+func NewEraser(db database.TextChatDB, attachmentService *attachments.Service, policy string) *Eraser {
+	return &Eraser{db: db, attachments: attachmentService, policy: policy}
+}
+
+// Erase removes the user from every conversation and applies the policy to the messages of the user, an empty policy is the default policy
+// The scheduled messages of the user are deleted, the user is removed from the mentions and the direct conversations left without messages are deleted
+func (eraser *Eraser) Erase(ctx context.Context, userID string, policy string) (*Report, error) {
+	if policy == "" {
+		policy = eraser.policy
+	}
+	if !IsValidPolicy(policy) {
+		return nil, ErrorInvalidPolicy
+	}
+
+	report := &Report{
+		ID:                    uuid.NewString(),
+		UserID:                userID,
+		Policy:                policy,
+		LeftConversationID:    []string{},
+		DeletedConversationID: []string{},
+		StartedOn:             time.Now().UTC(),
+	}
+	err := eraser.erase(ctx, report)
+	if err != nil {
+		log.Error(err, "Erasure of user failed", "erasure_id", report.ID, "user_id", userID, "report", report)
+		return report, err
+	}
+	completedOn := time.Now().UTC()
+	report.CompletedOn = &completedOn
+	log.Info("Erased user", "erasure_id", report.ID, "user_id", userID, "report", report)
+	return report, nil
+}
+
+// erase runs the steps of the erasure, the report holds the changes done by the steps that succeeded
+func (eraser *Eraser) erase(ctx context.Context, report *Report) error {
+	err := eraser.deleteScheduledMessages(ctx, report)
+	if err != nil {
+		return err
+	}
+
+	if report.Policy == PolicyDelete {
+		var messages data.Messages
+		messages, err = eraser.db.DeleteUserMessages(ctx, report.UserID)
+		report.Messages = int64(len(messages))
+		eraser.cleanupAttachments(ctx, messages)
+	} else {
+		report.Messages, err = eraser.db.AnonymizeUserMessages(ctx, report.UserID)
+	}
+	if err != nil {
+		return err
+	}
+
+	report.Mentions, err = eraser.db.RemoveUserMentions(ctx, report.UserID)
+	if err != nil {
+		return err
+	}
+
+	conversations, err := eraser.db.GetConversationsByUserID(ctx, report.UserID, true)
+	if err != nil {
+		return err
+	}
+	for _, conversation := range conversations {
+		deleted, err := eraser.leaveConversation(ctx, conversation, report.UserID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			report.DeletedConversationID = append(report.DeletedConversationID, conversation.ID)
+		} else {
+			report.LeftConversationID = append(report.LeftConversationID, conversation.ID)
+		}
+	}
+	return nil
+}
+
+// deleteScheduledMessages deletes the scheduled messages of the user, sent, failed and cancelled ones included,
+// since they hold the text of the messages. The attachments of the messages that were not sent are deleted with them
+func (eraser *Eraser) deleteScheduledMessages(ctx context.Context, report *Report) error {
+	scheduledMessages, err := eraser.db.DeleteUserScheduledMessages(ctx, report.UserID)
+	if err != nil {
+		return err
+	}
+	report.ScheduledMessages = int64(len(scheduledMessages))
+
+	// The attachments of sent messages belong to the messages, and a message being sent may still be added by the dispatcher
+	unsent := data.Messages{}
+	for _, scheduled := range scheduledMessages {
+		if scheduled.Status != data.ScheduleStatusSent && scheduled.Status != data.ScheduleStatusSending {
+			unsent = append(unsent, scheduled.Message)
+		}
+	}
+	eraser.cleanupAttachments(ctx, unsent)
+	return nil
+}
+
+// cleanupAttachments deletes the attachments of the removed messages
+func (eraser *Eraser) cleanupAttachments(ctx context.Context, messages data.Messages) {
+	if eraser.attachments == nil {
+		return
+	}
+	for _, message := range messages {
+		if message == nil || len(message.Attachments) == 0 {
+			continue
+		}
+		err := eraser.attachments.CleanupMessage(ctx, message.ID)
+		if err != nil {
+			log.Error(err, "Error deleting attachments of erased message", "id", message.ID)
+		}
+	}
+}
+
+// leaveConversation removes the user from the conversation, a direct conversation without messages is deleted instead
+// It returns true when the conversation was deleted
+func (eraser *Eraser) leaveConversation(ctx context.Context, conversation *data.Conversation, userID string) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		if conversation.ConversationType() == data.ConversationTypeDirect {
+			// Some databases return no messages instead of a not found error
+			messages, err := eraser.db.GetMessagesByConversationID(ctx, conversation.ID)
+			if err != nil && err != data.ErrorMessageNotFound {
+				return false, err
+			}
+			if len(messages) == 0 {
+				err = eraser.db.DeleteConversation(ctx, conversation.ID)
+				if err == nil || err == data.ErrorConversationNotFound {
+					return true, nil
+				}
+				return false, err
+			}
+		}
+
+		conversation.EraseMember(userID)
+		err := eraser.db.UpdateConversation(ctx, conversation)
+		if err != data.ErrorConversationConflict || attempt >= maxConflictRetries {
+			return false, err
+		}
+
+		// The conversation was updated since it was read, the erasure is applied to the new version
+		conversation, err = eraser.db.GetConversationByID(ctx, conversation.ID)
+		if err != nil {
+			return false, err
+		}
+	}
+}
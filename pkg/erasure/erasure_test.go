@@ -0,0 +1,132 @@
+package erasure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+func TestEraseAnonymizesMessages(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	group, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeGroup, UserID: []string{"erased-a", "erased-b", "erased-c"}})
+	group.InitRoles("erased-a")
+	group.MutedUserID = []string{"erased-a"}
+	_ = db.UpdateConversation(ctx, group)
+	direct, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"erased-a", "erased-b"}})
+	empty, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"erased-a", "erased-c"}})
+
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-b", ConversationID: direct.ID, Text: "hi"})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-b", ConversationID: group.ID, Text: "@a gg",
+		Mentions: []data.Mention{{Offset: 0, Length: 2, Type: data.MentionTypeUser, UserID: "erased-a"}}, MentionedUserID: []string{"erased-a"}})
+	sendAt := time.Now().Add(time.Hour)
+	_ = db.AddScheduledMessage(ctx, data.NewScheduledMessage(&data.Message{UserID: "erased-a", ConversationID: group.ID, Text: "later", SendAt: &sendAt}))
+	sent := data.NewScheduledMessage(&data.Message{UserID: "erased-a", ConversationID: group.ID, Text: "earlier", SendAt: &sendAt})
+	_ = db.AddScheduledMessage(ctx, sent)
+	_ = db.CompleteScheduledMessage(ctx, sent.ID, data.ScheduleStatusSent, "")
+
+	report, err := NewEraser(db, nil, DefaultPolicy).Erase(ctx, "erased-a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Policy != PolicyAnonymize || report.Mentions != 1 || report.ScheduledMessages != 2 || report.CompletedOn == nil {
+		t.Errorf("Unexpected erasure report %+v", report)
+	}
+	if len(report.LeftConversationID) != 2 || len(report.DeletedConversationID) != 1 || report.DeletedConversationID[0] != empty.ID {
+		t.Errorf("Expected the empty direct conversation to be deleted, got %+v", report)
+	}
+
+	conversations, _ := db.GetConversationsByUserID(ctx, "erased-a", true)
+	if len(conversations) != 0 {
+		t.Errorf("Expected the user to be removed from every conversation, got %d", len(conversations))
+	}
+	group, _ = db.GetConversationByID(ctx, group.ID)
+	if group.IsMuted("erased-a") || group.Owner() == "" || group.Owner() == "erased-a" {
+		t.Errorf("Expected the roles and mutes of the user to be removed, got %+v", group)
+	}
+	direct, _ = db.GetConversationByID(ctx, direct.ID)
+	if direct.DirectKey != "" || len(direct.UserID) != 1 {
+		t.Errorf("Expected the direct conversation to keep a single member, got %+v", direct)
+	}
+	mentions, _ := db.GetMentionsByUserID(ctx, "erased-a")
+	if len(mentions) != 0 {
+		t.Errorf("Expected the mentions of the user to be removed, got %d", len(mentions))
+	}
+	scheduled, _ := db.GetScheduledMessagesByUserID(ctx, "erased-a")
+	if len(scheduled) != 0 {
+		t.Errorf("Expected the scheduled messages to be deleted, got %d", len(scheduled))
+	}
+	if _, err = db.GetScheduledMessageByID(ctx, sent.ID); err != data.ErrorScheduledMessageNotFound {
+		t.Errorf("Expected the sent scheduled message to be deleted, got %v", err)
+	}
+
+	// Running the erasure again changes nothing
+	report, err = NewEraser(db, nil, DefaultPolicy).Erase(ctx, "erased-a", "")
+	if err != nil || report.Messages != 0 || len(report.LeftConversationID) != 0 {
+		t.Errorf("Expected a second erasure to be empty, got %+v, %v", report, err)
+	}
+}
+
+func TestEraseDeletesMessages(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	direct, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"erased-d", "erased-e"}})
+	group, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeGroup, UserID: []string{"erased-d", "erased-e"}})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-d", ConversationID: direct.ID, Text: "hi"})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-d", ConversationID: group.ID, Text: "hello"})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-e", ConversationID: group.ID, Text: "hey"})
+
+	report, err := NewEraser(db, nil, PolicyAnonymize).Erase(ctx, "erased-d", PolicyDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Policy != PolicyDelete || report.Messages != 2 || len(report.DeletedConversationID) != 1 || report.DeletedConversationID[0] != direct.ID {
+		t.Errorf("Unexpected erasure report %+v", report)
+	}
+	messages, _ := db.GetMessagesByConversationID(ctx, group.ID)
+	if len(messages) != 1 || messages[0].UserID != "erased-e" {
+		t.Errorf("Expected only the messages of the user to be deleted, got %d", len(messages))
+	}
+
+	if _, err = NewEraser(db, nil, DefaultPolicy).Erase(ctx, "erased-d", "forget"); err != ErrorInvalidPolicy {
+		t.Errorf("Expected an invalid policy error, got %v", err)
+	}
+}
+
+// mongoSemantics returns no messages instead of a not found error for a conversation without messages, as MongoDB does
+type mongoSemantics struct {
+	database.TextChatDB
+}
+
+func (db mongoSemantics) GetMessagesByConversationID(ctx context.Context, id string) (data.Messages, error) {
+	messages, err := db.TextChatDB.GetMessagesByConversationID(ctx, id)
+	if err == data.ErrorMessageNotFound {
+		return nil, nil
+	}
+	return messages, err
+}
+
+func TestEraseWithMongoSemantics(t *testing.T) {
+	db := mongoSemantics{database.NewMockTextChat()}
+	ctx := context.Background()
+
+	empty, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"erased-f", "erased-g"}})
+	direct, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"erased-f", "erased-h"}})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "erased-h", ConversationID: direct.ID, Text: "hi"})
+
+	report, err := NewEraser(db, nil, DefaultPolicy).Erase(ctx, "erased-f", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.DeletedConversationID) != 1 || report.DeletedConversationID[0] != empty.ID || len(report.LeftConversationID) != 1 {
+		t.Errorf("Expected the empty direct conversation to be deleted, got %+v", report)
+	}
+	if _, err = db.GetConversationByID(ctx, empty.ID); err != data.ErrorConversationNotFound {
+		t.Errorf("Expected the empty direct conversation to be deleted, got %v", err)
+	}
+}
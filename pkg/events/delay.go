@@ -0,0 +1,71 @@
+package events
+
+import (
+	"time"
+)
+
+// delayedEvent is an event held back until its due time
+type delayedEvent struct {
+	event Event
+	due   time.Time
+}
+
+// Delay returns a channel receiving the events of the input channel, the events matching delayed are held back for the delay
+// Events keep their order within the delayed and the immediate events, the returned channel is closed with the input channel or when done is closed
+func Delay(done <-chan struct{}, input <-chan Event, delay time.Duration, delayed func(Event) bool) <-chan Event {
+	output := make(chan Event, subscriberBuffer)
+	go func() {
+		defer close(output)
+		var queue []delayedEvent
+		timer := time.NewTimer(delay)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			// Only wait for the timer when an event is queued
+			var due <-chan time.Time
+			if len(queue) > 0 {
+				due = timer.C
+			}
+			select {
+			case <-done:
+				return
+			case event, open := <-input:
+				if !open {
+					return
+				}
+				if delay <= 0 || !delayed(event) {
+					if !send(done, output, event) {
+						return
+					}
+					continue
+				}
+				queue = append(queue, delayedEvent{event: event, due: time.Now().Add(delay)})
+				if len(queue) == 1 {
+					timer.Reset(delay)
+				}
+			case <-due:
+				for len(queue) > 0 && !queue[0].due.After(time.Now()) {
+					if !send(done, output, queue[0].event) {
+						return
+					}
+					queue = queue[1:]
+				}
+				if len(queue) > 0 {
+					timer.Reset(time.Until(queue[0].due))
+				}
+			}
+		}
+	}()
+	return output
+}
+
+// send writes the event to the output unless done is closed first
+func send(done <-chan struct{}, output chan<- Event, event Event) bool {
+	select {
+	case output <- event:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// Event types
+const (
+	EventMessageCreated = "message.created"
+	EventMessageUpdated = "message.updated"
+	EventMessageDeleted = "message.deleted"
+	EventMessageExpired = "message.expired"
+)
+
+// subscriberBuffer is the number of events buffered for a slow subscriber before events are dropped
+const subscriberBuffer = 32
+
+// Event is sent to the clients connected to a conversation
+type Event struct {
+	Type           string        `json:"type"`
+	ConversationID string        `json:"conversation_id"`
+	Message        *data.Message `json:"message,omitempty"`
+}
+
+// Broker delivers the events of a conversation to its subscribers
+type Broker struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+// NewBroker creates an in-process event broker
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving the events of the conversation and a function to unsubscribe
+func (broker *Broker) Subscribe(conversationID string) (<-chan Event, func()) {
+	return broker.SubscribeMany([]string{conversationID})
+}
+
+// SubscribeMany returns a single channel receiving the events of every conversation and a function to unsubscribe
+func (broker *Broker) SubscribeMany(conversationIDs []string) (<-chan Event, func()) {
+	channel := make(chan Event, subscriberBuffer)
+
+	broker.mutex.Lock()
+	for _, conversationID := range conversationIDs {
+		if broker.subscribers[conversationID] == nil {
+			broker.subscribers[conversationID] = make(map[chan Event]struct{})
+		}
+		broker.subscribers[conversationID][channel] = struct{}{}
+	}
+	broker.mutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			broker.mutex.Lock()
+			defer broker.mutex.Unlock()
+			for _, conversationID := range conversationIDs {
+				delete(broker.subscribers[conversationID], channel)
+				if len(broker.subscribers[conversationID]) == 0 {
+					delete(broker.subscribers, conversationID)
+				}
+			}
+			close(channel)
+		})
+	}
+	return channel, unsubscribe
+}
+
+// Publish sends the event to the subscribers of its conversation
+// Events are dropped for subscribers that don't keep up instead of blocking the publisher
+func (broker *Broker) Publish(event Event) {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	for channel := range broker.subscribers[event.ConversationID] {
+		select {
+		case channel <- event:
+		default:
+			log.Info("Dropping event for slow subscriber", "type", event.Type, "conversation_id", event.ConversationID)
+		}
+	}
+}
+
+// PublishMessage sends a message event to the subscribers of the conversation of the message
+func (broker *Broker) PublishMessage(eventType string, message *data.Message) {
+	broker.Publish(Event{Type: eventType, ConversationID: message.ConversationID, Message: message})
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+func TestPublishToConversationSubscribers(t *testing.T) {
+	broker := NewBroker()
+	channel, unsubscribe := broker.Subscribe("conversation")
+	other, unsubscribeOther := broker.Subscribe("other-conversation")
+	defer unsubscribeOther()
+
+	broker.PublishMessage(EventMessageCreated, &data.Message{ID: "message", ConversationID: "conversation"})
+
+	event := <-channel
+	if event.Type != EventMessageCreated || event.Message.ID != "message" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	select {
+	case event := <-other:
+		t.Errorf("Unexpected event for another conversation %+v", event)
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-channel; ok {
+		t.Error("Expected channel to be closed after unsubscribe")
+	}
+}
+
+func TestPublishDoesNotBlockOnSlowSubscriber(t *testing.T) {
+	broker := NewBroker()
+	_, unsubscribe := broker.Subscribe("conversation")
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer*2; i++ {
+		broker.Publish(Event{Type: EventMessageCreated, ConversationID: "conversation"})
+	}
+}
+
+func TestSubscribeToManyConversations(t *testing.T) {
+	broker := NewBroker()
+	channel, unsubscribe := broker.SubscribeMany([]string{"all-chat", "team-chat"})
+
+	broker.PublishMessage(EventMessageCreated, &data.Message{ID: "all", ConversationID: "all-chat"})
+	broker.PublishMessage(EventMessageCreated, &data.Message{ID: "team", ConversationID: "team-chat"})
+	broker.PublishMessage(EventMessageCreated, &data.Message{ID: "other", ConversationID: "other-team-chat"})
+
+	for _, expected := range []string{"all", "team"} {
+		event := <-channel
+		if event.Message.ID != expected {
+			t.Errorf("Expected message %s, got %+v", expected, event)
+		}
+	}
+	select {
+	case event := <-channel:
+		t.Errorf("Unexpected event for another conversation %+v", event)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-channel; ok {
+		t.Error("Expected channel to be closed after unsubscribe")
+	}
+}
+
+func TestDelaySpectatorEvents(t *testing.T) {
+	input := make(chan Event, 2)
+	done := make(chan struct{})
+	defer close(done)
+	delay := 50 * time.Millisecond
+	output := Delay(done, input, delay, func(event Event) bool {
+		return event.ConversationID == "all-chat"
+	})
+
+	start := time.Now()
+	input <- Event{Type: EventMessageCreated, ConversationID: "all-chat"}
+	input <- Event{Type: EventMessageCreated, ConversationID: "spectator-chat"}
+
+	// The events of other conversations are not delayed
+	event := <-output
+	if event.ConversationID != "spectator-chat" || time.Since(start) >= delay {
+		t.Errorf("Expected the spectator chat event without delay, got %+v", event)
+	}
+	event = <-output
+	if event.ConversationID != "all-chat" || time.Since(start) < delay {
+		t.Errorf("Expected the all-chat event after the delay, got %+v", event)
+	}
+
+	close(input)
+	if _, ok := <-output; ok {
+		t.Error("Expected output to be closed with the input")
+	}
+}
@@ -0,0 +1,86 @@
+package expiry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+)
+
+// DefaultInterval is the interval between two sweeps of the expired messages
+const DefaultInterval = 10 * time.Second
+
+// Sweeper periodically deletes the expired ephemeral messages and notifies the connected clients
+// Expired messages are already hidden from the reads, so the interval only delays the events and the purge
+type Sweeper struct {
+	db          database.TextChatDB
+	attachments *attachments.Service
+	broker      *events.Broker
+	interval    time.Duration
+	wait        sync.WaitGroup
+}
+
+// NewSweeper creates a sweeper publishing the expiry events to the broker, the attachments of the expired messages are deleted with the attachment service
+func NewSweeper(db database.TextChatDB, attachmentService *attachments.Service, broker *events.Broker, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		db:          db,
+		attachments: attachmentService,
+		broker:      broker,
+		interval:    interval,
+	}
+}
+
+// Start runs the sweeper until the context is cancelled
+func (sweeper *Sweeper) Start(ctx context.Context) {
+	sweeper.wait.Add(1)
+	go func() {
+		defer sweeper.wait.Done()
+		ticker := time.NewTicker(sweeper.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = sweeper.Sweep(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the sweeper is stopped
+func (sweeper *Sweeper) Wait() {
+	sweeper.wait.Wait()
+}
+
+// Sweep deletes the messages expired at the given time with their attachments and publishes an expiry event for each of them
+func (sweeper *Sweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	expired, err := sweeper.db.DeleteExpiredMessages(ctx, now)
+	if err != nil {
+		log.Error(err, "Error deleting expired messages")
+		return 0, err
+	}
+	for _, message := range expired {
+		sweeper.cleanupAttachments(ctx, message)
+		sweeper.broker.PublishMessage(events.EventMessageExpired, message)
+	}
+	if len(expired) > 0 {
+		log.Info("Deleted expired messages", "count", len(expired))
+	}
+	return len(expired), nil
+}
+
+// cleanupAttachments deletes the attachments of an expired message
+func (sweeper *Sweeper) cleanupAttachments(ctx context.Context, message *data.Message) {
+	if sweeper.attachments == nil || len(message.Attachments) == 0 {
+		return
+	}
+	err := sweeper.attachments.CleanupMessage(ctx, message.ID)
+	if err != nil {
+		log.Error(err, "Error deleting attachments of expired message", "id", message.ID)
+	}
+}
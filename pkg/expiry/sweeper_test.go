@@ -0,0 +1,71 @@
+package expiry
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+)
+
+func TestSweepExpiredMessages(t *testing.T) {
+	db := database.NewMockTextChat()
+	broker := events.NewBroker()
+	ctx := context.Background()
+
+	conversation, err := db.AddConversation(ctx, &data.Conversation{UserID: []string{"a", "b"}, MessageTTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentService := attachments.NewService(db, attachments.NewLocalBlobStore(t.TempDir()))
+	attachment, err := attachmentService.Upload(ctx, "a", "screenshot.png", bytes.NewReader([]byte("\x89PNG\r\n\x1a\n0000")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ephemeral := &data.Message{UserID: "a", ConversationID: conversation.ID, Text: "self-destructing", Attachments: []*data.Attachment{{ID: attachment.ID}}}
+	kept := &data.Message{UserID: "a", ConversationID: conversation.ID, Text: "kept", TTL: 3600}
+	for _, message := range []*data.Message{ephemeral, kept} {
+		err = attachmentService.Resolve(ctx, message)
+		if err == nil {
+			err = db.AddMessage(ctx, message)
+		}
+		if err == nil {
+			err = attachmentService.Link(ctx, message)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ephemeral.ExpiresAt == nil || kept.ExpiresAt.Sub(*ephemeral.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("Expected the TTL of the message to override the default of the conversation, got %v and %v", ephemeral.ExpiresAt, kept.ExpiresAt)
+	}
+
+	channel, unsubscribe := broker.Subscribe(conversation.ID)
+	defer unsubscribe()
+	sweeper := NewSweeper(db, attachmentService, broker, DefaultInterval)
+	swept, err := sweeper.Sweep(ctx, time.Now().Add(2*time.Minute))
+	if err != nil || swept != 1 {
+		t.Fatalf("Expected one expired message, swept %d: %v", swept, err)
+	}
+	event := <-channel
+	if event.Type != events.EventMessageExpired || event.Message.ID != ephemeral.ID {
+		t.Errorf("Expected an expiry event for the message, got %+v", event)
+	}
+
+	_, err = db.GetMessageByID(ctx, ephemeral.ID)
+	if err != data.ErrorMessageNotFound {
+		t.Errorf("Expected %v, got %v", data.ErrorMessageNotFound, err)
+	}
+	if _, _, err = attachmentService.Open(ctx, attachment.ID); err != data.ErrorAttachmentNotFound {
+		t.Errorf("Expected the attachment of the expired message to be deleted, got %v", err)
+	}
+	messages, _ := db.GetMessagesByConversationID(ctx, conversation.ID)
+	if len(messages) != 1 || messages[0].ID != kept.ID {
+		t.Errorf("Expected only the kept message, got %v", messages)
+	}
+}
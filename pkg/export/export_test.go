@@ -0,0 +1,146 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/mentions"
+)
+
+// fakeDirectory resolves the usernames from a map
+type fakeDirectory map[string]string
+
+func (directory fakeDirectory) GetUsername(ctx context.Context, userID string) (string, error) {
+	if username, ok := directory[userID]; ok {
+		return username, nil
+	}
+	return "", mentions.ErrorUsernameNotFound
+}
+
+func TestWriteUserArchive(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+	userID := "export-user"
+
+	conversation, err := db.AddConversation(ctx, &data.Conversation{UserID: []string{userID, "friend"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < flushEvery+1; i++ {
+		_ = db.AddMessage(ctx, &data.Message{UserID: userID, ConversationID: conversation.ID, Text: "hello"})
+	}
+	_ = db.AddMessage(ctx, &data.Message{UserID: "friend", ConversationID: conversation.ID, Text: "hi"})
+
+	output := &bytes.Buffer{}
+	err = WriteUserArchive(ctx, db, userID, time.Now(), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := struct {
+		Version           int                    `json:"version"`
+		UserID            string                 `json:"user_id"`
+		Conversations     data.Conversations     `json:"conversations"`
+		Messages          data.Messages          `json:"messages"`
+		ScheduledMessages data.ScheduledMessages `json:"scheduled_messages"`
+	}{}
+	err = json.Unmarshal(output.Bytes(), &archive)
+	if err != nil {
+		t.Fatalf("Expected a valid JSON document, got %v", err)
+	}
+	if archive.Version != UserArchiveVersion || archive.UserID != userID || len(archive.Conversations) != 1 {
+		t.Errorf("Unexpected archive %+v", archive)
+	}
+	if len(archive.Messages) != flushEvery+1 || archive.ScheduledMessages == nil {
+		t.Errorf("Expected only the messages authored by the user, got %d", len(archive.Messages))
+	}
+}
+
+func TestWriteTranscript(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	conversation, _ := db.AddConversation(ctx, &data.Conversation{Title: "Finals <1>", UserID: []string{"transcript-a", "transcript-b"}})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "transcript-a", ConversationID: conversation.ID, Text: "glhf, <b>"})
+	_ = db.AddMessage(ctx, &data.Message{UserID: "transcript-b", ConversationID: conversation.ID, Text: "removed"})
+	_ = db.AddSystemMessage(ctx, data.NewSystemMessage(conversation.ID, "Match started"))
+	messages, _ := db.GetMessagesByConversationID(ctx, conversation.ID)
+	_, _ = db.TombstoneMessages(ctx, []string{messages[1].ID}, time.Now())
+
+	directory := fakeDirectory{"transcript-a": "Alice"}
+	location := time.FixedZone("EST", -5*60*60)
+	write := func(format string) string {
+		output := &bytes.Buffer{}
+		err := WriteTranscript(ctx, db, directory, conversation, TranscriptOptions{Format: format, Location: location, Now: time.Now()}, output)
+		if err != nil {
+			t.Fatalf("Error writing %s transcript: %v", format, err)
+		}
+		return output.String()
+	}
+
+	transcript := struct {
+		TimeZone string            `json:"time_zone"`
+		Messages []TranscriptEntry `json:"messages"`
+	}{}
+	err := json.Unmarshal([]byte(write(FormatJSON)), &transcript)
+	if err != nil {
+		t.Fatalf("Expected a valid JSON document, got %v", err)
+	}
+	if transcript.TimeZone != "EST" || len(transcript.Messages) != 3 {
+		t.Fatalf("Unexpected transcript %+v", transcript)
+	}
+	first, deleted, system := transcript.Messages[0], transcript.Messages[1], transcript.Messages[2]
+	if first.AuthorName != "Alice" || !strings.HasSuffix(first.CreatedOn, "-05:00") {
+		t.Errorf("Expected the author name and a local time, got %+v", first)
+	}
+	if !deleted.Deleted || deleted.Text != "" || deleted.AuthorName != "transcript-b" {
+		t.Errorf("Expected a tombstone authored by the user ID, got %+v", deleted)
+	}
+	if system.AuthorName != "Chat" || system.Kind != data.MessageKindSystem {
+		t.Errorf("Expected the sender of the system message, got %+v", system)
+	}
+
+	lines := strings.Split(strings.TrimSpace(write(FormatNDJSON)), "\n")
+	if len(lines) != 3 {
+		t.Errorf("Expected one line per message, got %d", len(lines))
+	}
+
+	records, err := csv.NewReader(strings.NewReader(write(FormatCSV))).ReadAll()
+	if err != nil || len(records) != 4 || records[1][5] != "glhf, <b>" || records[2][7] != "true" {
+		t.Errorf("Unexpected CSV transcript %v, %v", records, err)
+	}
+
+	for cell, expected := range map[string]string{
+		"=HYPERLINK(\"http://evil\")": "'=HYPERLINK(\"http://evil\")",
+		"+1":                          "'+1",
+		"-2+3":                        "'-2+3",
+		"@SUM(A1)":                    "'@SUM(A1)",
+		"gg = ez":                     "gg = ez",
+		"":                            "",
+	} {
+		if escaped := escapeFormula(cell); escaped != expected {
+			t.Errorf("Expected %q to be escaped as %q, got %q", cell, expected, escaped)
+		}
+	}
+
+	text := write(FormatText)
+	if !strings.Contains(text, "Alice: glhf, <b>") || !strings.Contains(text, "transcript-b: [message deleted]") {
+		t.Errorf("Unexpected text transcript %q", text)
+	}
+
+	page := write(FormatHTML)
+	if !strings.Contains(page, "<title>Finals &lt;1&gt;</title>") || !strings.Contains(page, "glhf, &lt;b&gt;") || !strings.Contains(page, `class="message deleted"`) {
+		t.Errorf("Unexpected HTML transcript %q", page)
+	}
+
+	if err = WriteTranscript(ctx, db, directory, conversation, TranscriptOptions{Format: "pdf"}, &bytes.Buffer{}); err != ErrorTranscriptFormat {
+		t.Errorf("Expected an unsupported format error, got %v", err)
+	}
+}
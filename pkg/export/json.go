@@ -0,0 +1,81 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// flushEvery is the number of records written between two flushes of the output
+const flushEvery = 100
+
+// flusher is implemented by the outputs that buffer their content, like an HTTP response
+type flusher interface {
+	Flush()
+}
+
+// jsonWriter writes a JSON document incrementally, one value at a time
+// The first error is kept and every following write is ignored
+type jsonWriter struct {
+	output  io.Writer
+	encoder *json.Encoder
+	first   bool
+	written int
+	err     error
+}
+
+func newJSONWriter(output io.Writer) *jsonWriter {
+	return &jsonWriter{output: output, encoder: json.NewEncoder(output)}
+}
+
+// raw writes a part of the document as is
+func (writer *jsonWriter) raw(content string) {
+	if writer.err == nil {
+		_, writer.err = io.WriteString(writer.output, content)
+	}
+}
+
+// field starts a field of the root object, its value must be written next
+func (writer *jsonWriter) field(name string, first bool) {
+	if !first {
+		writer.raw(",")
+	}
+	writer.value(name)
+	writer.raw(":")
+}
+
+// value writes a single JSON value
+func (writer *jsonWriter) value(value interface{}) {
+	if writer.err == nil {
+		writer.err = writer.encoder.Encode(value)
+	}
+}
+
+// startArray starts an array, its elements are written with element
+func (writer *jsonWriter) startArray() {
+	writer.raw("[")
+	writer.first = true
+}
+
+// element writes an element of the current array and flushes the output regularly
+func (writer *jsonWriter) element(value interface{}) error {
+	if !writer.first {
+		writer.raw(",")
+	}
+	writer.first = false
+	writer.value(value)
+	writer.written++
+	if writer.written%flushEvery == 0 {
+		writer.flush()
+	}
+	return writer.err
+}
+
+func (writer *jsonWriter) endArray() {
+	writer.raw("]")
+}
+
+func (writer *jsonWriter) flush() {
+	if output, ok := writer.output.(flusher); ok && writer.err == nil {
+		output.Flush()
+	}
+}
@@ -0,0 +1,400 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/mentions"
+)
+
+// ErrorTranscriptFormat : The format of the transcript is not supported
+var ErrorTranscriptFormat = fmt.Errorf("unsupported transcript format")
+
+// Formats of the transcript of a conversation
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	FormatText   = "txt"
+	FormatHTML   = "html"
+)
+
+// TranscriptVersion is the version of the JSON format of the transcripts, incremented on breaking changes
+const TranscriptVersion = 1
+
+// transcriptContentTypes holds the content type of each transcript format
+var transcriptContentTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatNDJSON: "application/x-ndjson",
+	FormatCSV:    "text/csv; charset=utf-8",
+	FormatText:   "text/plain; charset=utf-8",
+	FormatHTML:   "text/html; charset=utf-8",
+}
+
+// Texts of the transcripts for the removed content and authors
+const (
+	deletedText      = "[message deleted]"
+	erasedAuthorName = "Deleted user"
+)
+
+// TranscriptContentType returns the content type of the transcript format
+func TranscriptContentType(format string) (string, error) {
+	contentType, ok := transcriptContentTypes[format]
+	if !ok {
+		return "", ErrorTranscriptFormat
+	}
+	return contentType, nil
+}
+
+// TranscriptOptions are the format of a transcript and the time zone of its timestamps
+type TranscriptOptions struct {
+	Format   string
+	Location *time.Location
+	Now      time.Time
+}
+
+// TranscriptEntry is a message of a transcript, with the name of its author and its time in the time zone of the transcript
+// The text of a tombstone is removed and Deleted is set
+type TranscriptEntry struct {
+	ID          string   `json:"id"`
+	AuthorID    string   `json:"author_id"`
+	AuthorName  string   `json:"author_name"`
+	Kind        string   `json:"kind"`
+	Text        string   `json:"text"`
+	Attachments []string `json:"attachments,omitempty"`
+	CreatedOn   string   `json:"created_on"`
+	Deleted     bool     `json:"deleted"`
+	DeletedOn   string   `json:"deleted_on,omitempty"`
+}
+
+// transcriptHeader describes the conversation of a transcript
+type transcriptHeader struct {
+	ConversationID string
+	Type           string
+	Title          string
+	TimeZone       string
+	ExportedOn     string
+}
+
+// transcriptEncoder writes the transcript in a format, the first error is kept and every following write is ignored
+type transcriptEncoder interface {
+	begin(header *transcriptHeader)
+	entry(entry *TranscriptEntry)
+	end()
+	flush()
+	error() error
+}
+
+// WriteTranscript writes the messages of the conversation in order, in the format and time zone of the options
+// The messages are streamed from the database and the names of their authors are fetched once per author
+// An error after the first write leaves a truncated transcript
+func WriteTranscript(ctx context.Context, db database.TextChatDB, directory mentions.UserDirectory, conversation *data.Conversation, options TranscriptOptions, output io.Writer) error {
+	var encoder transcriptEncoder
+	switch options.Format {
+	case FormatJSON:
+		encoder = &jsonTranscript{writer: newJSONWriter(output)}
+	case FormatNDJSON:
+		encoder = &ndjsonTranscript{writer: newJSONWriter(output)}
+	case FormatCSV:
+		encoder = &csvTranscript{output: output, writer: csv.NewWriter(output)}
+	case FormatText:
+		encoder = &textTranscript{output: output}
+	case FormatHTML:
+		encoder = &htmlTranscript{textTranscript{output: output}}
+	default:
+		return ErrorTranscriptFormat
+	}
+	location := options.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	title := conversation.Title
+	if title == "" {
+		title = conversation.ID
+	}
+	encoder.begin(&transcriptHeader{
+		ConversationID: conversation.ID,
+		Type:           conversation.ConversationType(),
+		Title:          title,
+		TimeZone:       location.String(),
+		ExportedOn:     options.Now.In(location).Format(time.RFC3339),
+	})
+
+	authors := &authorNames{ctx: ctx, directory: directory, names: map[string]string{}}
+	written := 0
+	err := db.StreamMessagesByConversationID(ctx, conversation.ID, func(message *data.Message) error {
+		encoder.entry(newTranscriptEntry(message, authors, location))
+		written++
+		if written%flushEvery == 0 {
+			encoder.flush()
+		}
+		return encoder.error()
+	})
+	if err != nil {
+		log.Error(err, "Error streaming messages of conversation transcript", "conversation_id", conversation.ID)
+		return err
+	}
+	encoder.end()
+	encoder.flush()
+	return encoder.error()
+}
+
+// newTranscriptEntry converts a message to an entry of a transcript
+func newTranscriptEntry(message *data.Message, authors *authorNames, location *time.Location) *TranscriptEntry {
+	entry := &TranscriptEntry{
+		ID:        message.ID,
+		Kind:      message.MessageKind(),
+		Text:      message.Text,
+		CreatedOn: message.CreatedOn.In(location).Format(time.RFC3339),
+	}
+	if message.Sender != nil {
+		entry.AuthorID = message.Sender.ID
+		entry.AuthorName = message.Sender.DisplayName
+		if entry.AuthorName == "" {
+			entry.AuthorName = message.Sender.ID
+		}
+	} else {
+		entry.AuthorID = message.UserID
+		entry.AuthorName = authors.name(message.UserID)
+	}
+	for _, attachment := range message.Attachments {
+		entry.Attachments = append(entry.Attachments, attachment.FileName)
+	}
+	if message.IsTombstone() {
+		entry.Text = ""
+		entry.Deleted = true
+		entry.DeletedOn = message.DeletedOn.In(location).Format(time.RFC3339)
+	}
+	return entry
+}
+
+// authorNames resolves the display names of the authors of a transcript, each author is fetched once
+// The user ID is used when the name can't be fetched
+type authorNames struct {
+	ctx       context.Context
+	directory mentions.UserDirectory
+	names     map[string]string
+}
+
+func (authors *authorNames) name(userID string) string {
+	if userID == data.ErasedUserID {
+		return erasedAuthorName
+	}
+	if name, ok := authors.names[userID]; ok {
+		return name
+	}
+	name := userID
+	if authors.directory != nil {
+		username, err := authors.directory.GetUsername(authors.ctx, userID)
+		if err == nil {
+			name = username
+		} else {
+			log.Error(err, "Error fetching the name of a transcript author, using the user ID", "user_id", userID)
+		}
+	}
+	authors.names[userID] = name
+	return name
+}
+
+// displayText returns the text of an entry as read by a person, with its attachments
+func displayText(entry *TranscriptEntry) string {
+	if entry.Deleted {
+		return deletedText
+	}
+	text := entry.Text
+	for _, attachment := range entry.Attachments {
+		text += " [attachment: " + attachment + "]"
+	}
+	return text
+}
+
+// jsonTranscript writes the transcript as a single JSON document
+type jsonTranscript struct {
+	writer *jsonWriter
+}
+
+func (transcript *jsonTranscript) begin(header *transcriptHeader) {
+	writer := transcript.writer
+	writer.raw("{")
+	writer.field("version", true)
+	writer.value(TranscriptVersion)
+	writer.field("conversation_id", false)
+	writer.value(header.ConversationID)
+	writer.field("type", false)
+	writer.value(header.Type)
+	writer.field("title", false)
+	writer.value(header.Title)
+	writer.field("time_zone", false)
+	writer.value(header.TimeZone)
+	writer.field("exported_on", false)
+	writer.value(header.ExportedOn)
+	writer.field("messages", false)
+	writer.startArray()
+}
+
+func (transcript *jsonTranscript) entry(entry *TranscriptEntry) {
+	_ = transcript.writer.element(entry)
+}
+
+func (transcript *jsonTranscript) end() {
+	transcript.writer.endArray()
+	transcript.writer.raw("}")
+}
+
+func (transcript *jsonTranscript) flush() {
+	transcript.writer.flush()
+}
+
+func (transcript *jsonTranscript) error() error {
+	return transcript.writer.err
+}
+
+// ndjsonTranscript writes one JSON document per message
+type ndjsonTranscript struct {
+	writer *jsonWriter
+}
+
+func (transcript *ndjsonTranscript) begin(header *transcriptHeader) {}
+
+func (transcript *ndjsonTranscript) entry(entry *TranscriptEntry) {
+	// The encoder ends every value with a new line
+	transcript.writer.value(entry)
+}
+
+func (transcript *ndjsonTranscript) end() {}
+
+func (transcript *ndjsonTranscript) flush() {
+	transcript.writer.flush()
+}
+
+func (transcript *ndjsonTranscript) error() error {
+	return transcript.writer.err
+}
+
+// csvTranscript writes one row per message after a header row
+type csvTranscript struct {
+	output io.Writer
+	writer *csv.Writer
+	err    error
+}
+
+func (transcript *csvTranscript) begin(header *transcriptHeader) {
+	transcript.write([]string{"id", "created_on", "author_id", "author_name", "kind", "text", "attachments", "deleted", "deleted_on"})
+}
+
+func (transcript *csvTranscript) entry(entry *TranscriptEntry) {
+	record := []string{
+		entry.ID,
+		entry.CreatedOn,
+		entry.AuthorID,
+		entry.AuthorName,
+		entry.Kind,
+		entry.Text,
+		strings.Join(entry.Attachments, ";"),
+		strconv.FormatBool(entry.Deleted),
+		entry.DeletedOn,
+	}
+	for i, cell := range record {
+		record[i] = escapeFormula(cell)
+	}
+	transcript.write(record)
+}
+
+// escapeFormula prefixes a cell that a spreadsheet would interpret as a formula with a quote, so it is displayed as text
+func escapeFormula(cell string) string {
+	if cell != "" && strings.ContainsAny(cell[:1], "=+-@") {
+		return "'" + cell
+	}
+	return cell
+}
+
+func (transcript *csvTranscript) write(record []string) {
+	if transcript.err == nil {
+		transcript.err = transcript.writer.Write(record)
+	}
+}
+
+func (transcript *csvTranscript) end() {}
+
+func (transcript *csvTranscript) flush() {
+	if transcript.err != nil {
+		return
+	}
+	transcript.writer.Flush()
+	transcript.err = transcript.writer.Error()
+	if output, ok := transcript.output.(flusher); ok && transcript.err == nil {
+		output.Flush()
+	}
+}
+
+func (transcript *csvTranscript) error() error {
+	return transcript.err
+}
+
+// textTranscript writes the transcript as plain text, one line per message
+type textTranscript struct {
+	output io.Writer
+	err    error
+}
+
+func (transcript *textTranscript) begin(header *transcriptHeader) {
+	transcript.printf("Conversation: %s\nTime zone: %s\nExported on: %s\n\n", header.Title, header.TimeZone, header.ExportedOn)
+}
+
+func (transcript *textTranscript) entry(entry *TranscriptEntry) {
+	transcript.printf("[%s] %s: %s\n", entry.CreatedOn, entry.AuthorName, displayText(entry))
+}
+
+func (transcript *textTranscript) printf(format string, values ...interface{}) {
+	if transcript.err == nil {
+		_, transcript.err = fmt.Fprintf(transcript.output, format, values...)
+	}
+}
+
+func (transcript *textTranscript) end() {}
+
+func (transcript *textTranscript) flush() {
+	if output, ok := transcript.output.(flusher); ok && transcript.err == nil {
+		output.Flush()
+	}
+}
+
+func (transcript *textTranscript) error() error {
+	return transcript.err
+}
+
+// htmlTranscript writes the transcript as an HTML page, one list item per message
+type htmlTranscript struct {
+	textTranscript
+}
+
+func (transcript *htmlTranscript) begin(header *transcriptHeader) {
+	title := html.EscapeString(header.Title)
+	transcript.printf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n"+
+		"<style>.text{white-space:pre-wrap}.deleted .text{font-style:italic}</style>\n</head>\n<body>\n"+
+		"<h1>%s</h1>\n<p>Time zone: %s, exported on <time datetime=\"%s\">%s</time></p>\n<ol class=\"transcript\">\n",
+		title, title, html.EscapeString(header.TimeZone), header.ExportedOn, header.ExportedOn)
+}
+
+func (transcript *htmlTranscript) entry(entry *TranscriptEntry) {
+	class := "message"
+	if entry.Deleted {
+		class += " deleted"
+	}
+	transcript.printf("<li class=\"%s\" id=\"message-%s\"><time datetime=\"%s\">%s</time> <span class=\"author\">%s</span>: <span class=\"text\">%s</span></li>\n",
+		class, html.EscapeString(entry.ID), html.EscapeString(entry.CreatedOn), html.EscapeString(entry.CreatedOn),
+		html.EscapeString(entry.AuthorName), html.EscapeString(displayText(entry)))
+}
+
+func (transcript *htmlTranscript) end() {
+	transcript.printf("</ol>\n</body>\n</html>\n")
+}
@@ -0,0 +1,71 @@
+package export
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// UserArchiveVersion is the version of the format of the user archive, incremented on breaking changes
+const UserArchiveVersion = 1
+
+// WriteUserArchive writes every data of the text chat tied to the user as a single JSON document:
+// the conversations the user belongs to, including archived ones, the messages the user authored and the pending scheduled messages
+// The messages are streamed from the database, so a large history is never loaded fully into memory
+// An error after the first write leaves a truncated document
+func WriteUserArchive(ctx context.Context, db database.TextChatDB, userID string, now time.Time, output io.Writer) error {
+	conversations, err := db.GetConversationsByUserID(ctx, userID, true)
+	if err != nil {
+		return err
+	}
+	scheduledMessages, err := db.GetScheduledMessagesByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	writer := newJSONWriter(output)
+	writer.raw("{")
+	writer.field("version", true)
+	writer.value(UserArchiveVersion)
+	writer.field("user_id", false)
+	writer.value(userID)
+	writer.field("exported_on", false)
+	writer.value(now.UTC().Format(time.RFC3339))
+
+	writer.field("conversations", false)
+	writer.startArray()
+	for _, conversation := range conversations {
+		err = writer.element(conversation)
+		if err != nil {
+			return err
+		}
+	}
+	writer.endArray()
+
+	writer.field("messages", false)
+	writer.startArray()
+	err = db.StreamMessagesByUserID(ctx, userID, func(message *data.Message) error {
+		return writer.element(message)
+	})
+	if err != nil {
+		log.Error(err, "Error streaming messages of user archive", "user_id", userID)
+		return err
+	}
+	writer.endArray()
+
+	writer.field("scheduled_messages", false)
+	writer.startArray()
+	for _, scheduled := range scheduledMessages {
+		err = writer.element(scheduled)
+		if err != nil {
+			return err
+		}
+	}
+	writer.endArray()
+	writer.raw("}")
+	writer.flush()
+	return writer.err
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.opentelemetry.io/otel"
+)
+
+// ArchiveConversation makes a conversation read-only and hides it from the default listing
+func (textChatHandler *TextChatHandler) ArchiveConversation(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "archiveConversation")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("ArchiveConversation request", "id", id)
+
+	textChatHandler.updateArchive(responseWriter, request, id, (*data.Conversation).Archive)
+}
+
+// RestoreConversation makes an archived conversation writable again
+func (textChatHandler *TextChatHandler) RestoreConversation(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "restoreConversation")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("RestoreConversation request", "id", id)
+
+	textChatHandler.updateArchive(responseWriter, request, id, (*data.Conversation).Restore)
+}
+
+// updateArchive changes the archived state of a conversation when the user is allowed to archive it
+func (textChatHandler *TextChatHandler) updateArchive(responseWriter http.ResponseWriter, request *http.Request, id string, change func(conversation *data.Conversation)) {
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionArchive) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		change(conversation)
+		err = textChatHandler.db.UpdateConversation(request.Context(), conversation)
+	}
+
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(conversation)
+		if err != nil {
+			log.Error(err, "Error serializing conversation")
+		}
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't archive the conversation", "id", id)
+		http.Error(responseWriter, "Only the owner can archive the conversation", http.StatusForbidden)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation changed while archiving", "id", id)
+		http.Error(responseWriter, "Conversation was modified, try again", http.StatusConflict)
+		return
+	default:
+		log.Error(err, "Error archiving conversation", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
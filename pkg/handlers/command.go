@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/commands"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.opentelemetry.io/otel"
+)
+
+// executeCommand runs the chat command contained in the message and returns its result
+// The reply of the command is private and only sent back to the user who ran it
+func (textChatHandler *TextChatHandler) executeCommand(responseWriter http.ResponseWriter, request *http.Request, message *data.Message) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "executeCommand")
+	defer span.End()
+	log.Info("Command request", "user_id", message.UserID, "conversation_id", message.ConversationID)
+
+	result, err := textChatHandler.commands.Execute(request.Context(), message)
+	switch err {
+	case nil:
+		if result.Message != nil {
+			textChatHandler.messageCreated(result.Message)
+		}
+		err = json.NewEncoder(responseWriter).Encode(result)
+		if err != nil {
+			log.Error(err, "Error serializing command result")
+		}
+		return
+	case commands.ErrorUnknownCommand:
+		log.Error(err, "Unknown command")
+		http.Error(responseWriter, "Unknown command, type /help for the list of commands", http.StatusBadRequest)
+		return
+	case commands.ErrorInvalidArguments:
+		log.Error(err, "Invalid command arguments")
+		if result != nil && result.Reply != "" {
+			http.Error(responseWriter, result.Reply, http.StatusBadRequest)
+			return
+		}
+		http.Error(responseWriter, "Invalid command arguments", http.StatusBadRequest)
+		return
+	case commands.ErrorPermissionDenied:
+		log.Error(err, "Command not allowed")
+		http.Error(responseWriter, "You are not allowed to use this command", http.StatusForbidden)
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found")
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorUserNotFound:
+		log.Error(err, "UserID doesn't exist")
+		http.Error(responseWriter, "UserID doesn't exist", http.StatusBadRequest)
+		return
+	case data.ErrorConversationType, data.ErrorConversationFull:
+		log.Error(err, "Command not allowed for this conversation")
+		http.Error(responseWriter, err.Error(), http.StatusBadRequest)
+		return
+	case data.ErrorUserMuted:
+		log.Error(err, "User is muted")
+		http.Error(responseWriter, "User is muted in this conversation", http.StatusForbidden)
+		return
+	case data.ErrorConversationArchived:
+		log.Error(err, "Conversation is archived")
+		http.Error(responseWriter, "Conversation is archived and read-only", http.StatusForbidden)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation changed while running the command")
+		http.Error(responseWriter, "Conversation was modified, try again", http.StatusConflict)
+		return
+	default:
+		log.Error(err, "Error executing command")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
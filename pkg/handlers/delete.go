@@ -4,26 +4,49 @@ import (
 	"net/http"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
 	"go.opentelemetry.io/otel"
 )
 
 // Delete a message with specified id from the database
+// Only the author of the message and the members that can moderate its conversation can delete it
 func (textChatHandler *TextChatHandler) DeleteMessage(responseWriter http.ResponseWriter, request *http.Request) {
 	_, span := otel.Tracer("text-chat").Start(request.Context(), "deleteMessage")
 	defer span.End()
 	id := getTextChatID(request)
 	log.Info("Delete message by ID request", "id", id)
 
-	err := textChatHandler.db.DeleteMessage(request.Context(), id)
+	// The message is read before its deletion to notify the clients of its conversation
+	message, err := textChatHandler.db.GetMessageByID(request.Context(), id)
+	if err == nil && (message.UserID == "" || message.UserID != getRequestUserID(request)) {
+		var conversation *data.Conversation
+		conversation, err = textChatHandler.db.GetConversationByID(request.Context(), message.ConversationID)
+		if err == data.ErrorConversationNotFound || (err == nil && !hasPermission(request, conversation, data.PermissionModerate)) {
+			err = data.ErrorPermissionDenied
+		}
+	}
+	if err == nil {
+		err = textChatHandler.db.DeleteMessage(request.Context(), id)
+	}
 
 	switch err {
 	case nil:
+		textChatHandler.events.PublishMessage(events.EventMessageDeleted, message)
+		// The attachments of the message can't be referenced anymore
+		err = textChatHandler.attachments.CleanupMessage(request.Context(), id)
+		if err != nil {
+			log.Error(err, "Error deleting attachments of message", "id", id)
+		}
 		responseWriter.WriteHeader(http.StatusNoContent)
 		return
 	case data.ErrorMessageNotFound:
 		log.Error(err, "Error deleting message, id does not exist")
 		http.Error(responseWriter, "Message not found", http.StatusNotFound)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Error deleting message, user is not the author nor a moderator", "id", id)
+		http.Error(responseWriter, "Only the author and the moderators can delete the message", http.StatusForbidden)
+		return
 	default:
 		log.Error(err, "Error deleting message")
 		http.Error(responseWriter, "Erro deleting message", http.StatusInternalServerError)
@@ -40,12 +63,22 @@ func (textChatHandler *TextChatHandler) DeleteConversation(responseWriter http.R
 
 	// TODO: Delete all messages from the conversation
 
-	err := textChatHandler.db.DeleteConversation(request.Context(), id)
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionDelete) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		err = textChatHandler.db.DeleteConversation(request.Context(), id)
+	}
 
 	switch err {
 	case nil:
 		responseWriter.WriteHeader(http.StatusNoContent)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Error deleting conversation, user is not the owner", "id", id)
+		http.Error(responseWriter, "Only the owner can delete the conversation", http.StatusForbidden)
+		return
 	case data.ErrorConversationNotFound:
 		log.Error(err, "Error deleting conversation, id does not exist")
 		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
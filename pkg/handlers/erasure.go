@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/erasure"
+	"go.opentelemetry.io/otel"
+)
+
+// EraseUser removes a deleted user from every conversation and applies the erasure policy to the messages of the user
+// The policy query parameter overrides the default policy, the response is the audit report of the erasure
+func (textChatHandler *TextChatHandler) EraseUser(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "eraseUser")
+	defer span.End()
+	id := getTextChatID(request)
+	policy := request.URL.Query().Get("policy")
+	log.Info("EraseUser request", "id", id, "policy", policy)
+
+	report, err := textChatHandler.eraser.Erase(request.Context(), id, policy)
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(report)
+		if err != nil {
+			log.Error(err, "Error serializing erasure report")
+		}
+		return
+	case erasure.ErrorInvalidPolicy:
+		log.Error(err, "Invalid erasure policy", "policy", policy)
+		http.Error(responseWriter, "Invalid erasure policy, expected anonymize or delete", http.StatusBadRequest)
+		return
+	default:
+		log.Error(err, "Error erasing user", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
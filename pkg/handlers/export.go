@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/export"
+	"go.opentelemetry.io/otel"
+)
+
+// ExportUserData streams the archive of every data of the text chat tied to a user, for the data requests of the players
+func (textChatHandler *TextChatHandler) ExportUserData(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "exportUserData")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("ExportUserData request for userID", "id", id)
+
+	responseWriter.Header().Set("Content-Type", "application/json")
+	responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "text-chat-" + id + ".json"}))
+
+	// The status is sent with the first write, an error in the middle of the archive leaves it truncated
+	output := &streamWriter{ResponseWriter: responseWriter}
+	err := export.WriteUserArchive(request.Context(), textChatHandler.db, id, time.Now(), output)
+	if err != nil {
+		log.Error(err, "Error exporting user data", "id", id)
+		if !output.written {
+			responseWriter.Header().Del("Content-Disposition")
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// ExportConversation streams the transcript of a conversation in the format of the format query parameter, json by default
+// The timestamps are in the time zone of the tz query parameter, UTC by default. Only the moderators of the conversation and the internal services can export it
+func (textChatHandler *TextChatHandler) ExportConversation(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "exportConversation")
+	defer span.End()
+	id := getTextChatID(request)
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatJSON
+	}
+	log.Info("ExportConversation request", "id", id, "format", format)
+
+	contentType, err := export.TranscriptContentType(format)
+	if err != nil {
+		log.Error(err, "Invalid transcript format", "format", format)
+		http.Error(responseWriter, "Invalid format, expected json, ndjson, csv, txt or html", http.StatusBadRequest)
+		return
+	}
+	location := time.UTC
+	if timeZone := request.URL.Query().Get("tz"); timeZone != "" {
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			log.Error(err, "Invalid transcript time zone", "tz", timeZone)
+			http.Error(responseWriter, "Invalid time zone", http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionModerate) {
+		err = data.ErrorPermissionDenied
+	}
+	switch err {
+	case nil:
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't export the conversation", "id", id)
+		http.Error(responseWriter, "Only the moderators can export the conversation", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error getting conversation", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", contentType)
+	responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "conversation-" + id + "." + format}))
+
+	output := &streamWriter{ResponseWriter: responseWriter}
+	options := export.TranscriptOptions{Format: format, Location: location, Now: time.Now()}
+	err = export.WriteTranscript(request.Context(), textChatHandler.db, textChatHandler.users, conversation, options, output)
+	if err != nil {
+		log.Error(err, "Error exporting conversation", "id", id)
+		if !output.written {
+			responseWriter.Header().Del("Content-Disposition")
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// streamWriter is a response streamed to the client, it tells if an error can still be sent instead of the content
+type streamWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (writer *streamWriter) Write(content []byte) (int, error) {
+	writer.written = true
+	return writer.ResponseWriter.Write(content)
+}
+
+// Flush sends the content written so far to the client
+func (writer *streamWriter) Flush() {
+	if flusher, ok := writer.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
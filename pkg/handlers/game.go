@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.opentelemetry.io/otel"
+)
+
+// GetGameConversations returns the conversations of a game visible to the user making the request
+func (textChatHandler *TextChatHandler) GetGameConversations(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getGameConversations")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("GetGameConversations request", "game_id", id)
+
+	conversations, ok := textChatHandler.visibleGameConversations(responseWriter, request, id)
+	if !ok {
+		return
+	}
+	err := json.NewEncoder(responseWriter).Encode(conversations)
+	if err != nil {
+		log.Error(err, "Error serializing conversations")
+	}
+}
+
+// GetGameMessages returns the messages of every conversation of a game visible to the user making the request
+// The messages are ordered by creation and their channel tells the client where they were sent
+// Spectators don't see the messages of the last spectator delay in the conversations they spectate
+func (textChatHandler *TextChatHandler) GetGameMessages(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getGameMessages")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("GetGameMessages request", "game_id", id)
+
+	conversations, ok := textChatHandler.visibleGameConversations(responseWriter, request, id)
+	if !ok {
+		return
+	}
+
+	messages := data.Messages{}
+	for _, conversation := range conversations {
+		conversationMessages, err := textChatHandler.db.GetMessagesByConversationID(request.Context(), conversation.ID)
+		if err != nil && err != data.ErrorMessageNotFound {
+			log.Error(err, "Error fetching messages", "conversation_id", conversation.ID)
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		messages = append(messages, textChatHandler.visibleMessages(request, conversation, conversationMessages)...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedOn.Before(messages[j].CreatedOn)
+	})
+
+	err := json.NewEncoder(responseWriter).Encode(messages)
+	if err != nil {
+		log.Error(err, "Error serializing messages")
+	}
+}
+
+// StreamGameEvents streams the events of every conversation of a game visible to the user making the request
+func (textChatHandler *TextChatHandler) StreamGameEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "streamGameEvents")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("StreamGameEvents request", "game_id", id)
+
+	conversations, ok := textChatHandler.visibleGameConversations(responseWriter, request, id)
+	if !ok {
+		return
+	}
+
+	conversationIDs := make([]string, 0, len(conversations))
+	for _, conversation := range conversations {
+		conversationIDs = append(conversationIDs, conversation.ID)
+	}
+	channel, unsubscribe := textChatHandler.events.SubscribeMany(conversationIDs)
+	defer unsubscribe()
+	writeEventStream(responseWriter, request, textChatHandler.delayEvents(request, channel, conversations...))
+}
+
+// visibleGameConversations returns the conversations of the game the user making the request is a member of
+// The error response is written when false is returned
+func (textChatHandler *TextChatHandler) visibleGameConversations(responseWriter http.ResponseWriter, request *http.Request, gameID string) (data.Conversations, bool) {
+	userID := getRequestUserID(request)
+	if userID == "" {
+		log.Error(data.ErrorUserNotFound, "Game conversations requested without user identity", "game_id", gameID)
+		http.Error(responseWriter, "Missing user identity", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	conversations, err := textChatHandler.db.GetConversationsByGameID(request.Context(), gameID)
+	if err != nil {
+		log.Error(err, "Error fetching game conversations", "game_id", gameID)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	visible := conversations.VisibleTo(userID)
+	if len(visible) == 0 {
+		log.Error(data.ErrorGameNotFound, "User has no conversation in the game", "game_id", gameID)
+		http.Error(responseWriter, "Game not found", http.StatusNotFound)
+		return nil, false
+	}
+	return visible, true
+}
+
+// resolveChannel sets the conversation of a message sent to a channel of a game
+// The sender is the user of the message, system messages can only be sent to the all channel
+func (textChatHandler *TextChatHandler) resolveChannel(request *http.Request, message *data.Message) error {
+	if message.ConversationID != "" {
+		return nil
+	}
+	conversations, err := textChatHandler.db.GetConversationsByGameID(request.Context(), message.GameID)
+	if err != nil {
+		return err
+	}
+	if message.UserID == "" && message.Channel != data.ChannelAll {
+		return data.ErrorChannelNotFound
+	}
+	conversation, err := conversations.ChannelConversation(message.UserID, message.Channel)
+	if err != nil {
+		return err
+	}
+	message.ConversationID = conversation.ID
+	return nil
+}
+
+// setMessageChannel sets the game and the channel of a message sent to a game conversation
+func setMessageChannel(message *data.Message, conversation *data.Conversation) {
+	message.GameID = ""
+	message.Channel = conversation.Channel()
+	if message.Channel != "" {
+		message.GameID = conversation.GameID
+	}
+}
@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
 	"go.opentelemetry.io/otel"
@@ -16,6 +19,10 @@ func (textChatHandler *TextChatHandler) GetMessageByID(responseWriter http.Respo
 	log.Info("GetMessageByID request for ID", "id", id)
 
 	message, err := textChatHandler.db.GetMessageByID(request.Context(), id)
+	if err == nil {
+		// Only the members of the conversation read its messages
+		_, err = textChatHandler.readableConversation(request, message.ConversationID)
+	}
 	switch err {
 	case nil:
 		err = json.NewEncoder(responseWriter).Encode(message)
@@ -23,10 +30,14 @@ func (textChatHandler *TextChatHandler) GetMessageByID(responseWriter http.Respo
 			log.Error(err, "Error serializing message")
 		}
 		return
-	case data.ErrorMessageNotFound:
+	case data.ErrorMessageNotFound, data.ErrorConversationNotFound:
 		log.Error(err, "Message not found")
 		http.Error(responseWriter, "Message not found", http.StatusBadRequest)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Message requested by a user that is not a member of its conversation", "id", id)
+		http.Error(responseWriter, "Only the members of the conversation can read its messages", http.StatusForbidden)
+		return
 	default:
 		log.Error(err, "Error fetching message")
 		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
@@ -41,7 +52,8 @@ func (textChatHandler *TextChatHandler) GetConversationByID(responseWriter http.
 
 	log.Info("GetConversationByID request for ID", "id", id)
 
-	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	// The team chats of a match are only visible to their team, like under /games/{id}
+	conversation, err := textChatHandler.readableConversation(request, id)
 	switch err {
 	case nil:
 		err = json.NewEncoder(responseWriter).Encode(conversation)
@@ -53,6 +65,10 @@ func (textChatHandler *TextChatHandler) GetConversationByID(responseWriter http.
 		log.Error(err, "Conversation not found")
 		http.Error(responseWriter, "Conversation not found", http.StatusBadRequest)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Conversation requested by a user that is not a member", "id", id)
+		http.Error(responseWriter, "Only the members of the conversation can read it", http.StatusForbidden)
+		return
 	default:
 		log.Error(err, "Error fetching conversation")
 		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
@@ -67,21 +83,160 @@ func (textChatHandler *TextChatHandler) GetMessagesByConversationID(responseWrit
 
 	log.Info("GetMessagesByConversationID request for conversationID", "id", id)
 
-	messages, err := textChatHandler.db.GetMessagesByConversationID(request.Context(), id)
+	// Only the members of the conversation read its messages, the spectators with a delay
+	conversation, err := textChatHandler.readableConversation(request, id)
+	var messages data.Messages
+	if err == nil {
+		messages, err = textChatHandler.db.GetMessagesByConversationID(request.Context(), id)
+	}
 	switch err {
 	case nil:
-		err = json.NewEncoder(responseWriter).Encode(messages)
+		err = json.NewEncoder(responseWriter).Encode(textChatHandler.visibleMessages(request, conversation, messages))
 		if err != nil {
 			log.Error(err, "Error serializing messages")
 		}
 		return
-	case data.ErrorMessageNotFound:
+	case data.ErrorMessageNotFound, data.ErrorConversationNotFound:
 		log.Error(err, "Messages not found")
 		http.Error(responseWriter, "Messages not found", http.StatusBadRequest)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Messages requested by a user that is not a member of the conversation", "id", id)
+		http.Error(responseWriter, "Only the members of the conversation can read its messages", http.StatusForbidden)
+		return
 	default:
 		log.Error(err, "Error fetching messages")
 		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// GetMentionsByUserID returns the messages mentioning the user, so clients can show a mentions inbox
+func (textChatHandler *TextChatHandler) GetMentionsByUserID(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getMentionsByUserId")
+	defer span.End()
+	id := getTextChatID(request)
+
+	log.Info("GetMentionsByUserID request for userID", "id", id)
+
+	if !isInternalRequest(request) && id != getRequestUserID(request) {
+		log.Error(data.ErrorPermissionDenied, "Mentions requested for another user", "id", id)
+		http.Error(responseWriter, "Users can only list their own mentions", http.StatusForbidden)
+		return
+	}
+
+	messages, err := textChatHandler.db.GetMentionsByUserID(request.Context(), id)
+	switch err {
+	case nil:
+		if messages == nil {
+			messages = data.Messages{}
+		}
+		err = json.NewEncoder(responseWriter).Encode(messages)
+		if err != nil {
+			log.Error(err, "Error serializing mentions")
+		}
+		return
+	default:
+		log.Error(err, "Error fetching mentions")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetAttachmentByID returns the content of an attachment
+// Only the uploader and the members of the conversation of the message the attachment is claimed by can download it
+func (textChatHandler *TextChatHandler) GetAttachmentByID(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getAttachmentById")
+	defer span.End()
+	id := getTextChatID(request)
+
+	log.Info("GetAttachmentByID request for ID", "id", id)
+
+	attachment, content, err := textChatHandler.attachments.Open(request.Context(), id)
+	if err == nil {
+		defer content.Close()
+		err = textChatHandler.checkAttachmentAccess(request, attachment)
+	}
+	switch err {
+	case nil:
+		responseWriter.Header().Set("Content-Type", attachment.ContentType)
+		responseWriter.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		responseWriter.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+		responseWriter.Header().Set("X-Content-Type-Options", "nosniff")
+		_, err = io.Copy(responseWriter, content)
+		if err != nil {
+			log.Error(err, "Error sending attachment")
+		}
+		return
+	case data.ErrorAttachmentNotFound:
+		log.Error(err, "Attachment not found")
+		http.Error(responseWriter, "Attachment not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Attachment requested by a user that can't read its message", "id", id)
+		http.Error(responseWriter, "Only the uploader and the members of the conversation can download the attachment", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error fetching attachment")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// checkAttachmentAccess returns an error unless the user making the request uploaded the attachment or can read the message it is claimed by
+// The attachments of a scheduled message are only readable by the uploader until the message is sent
+func (textChatHandler *TextChatHandler) checkAttachmentAccess(request *http.Request, attachment *data.Attachment) error {
+	userID := getRequestUserID(request)
+	if isInternalRequest(request) || (userID != "" && attachment.UserID == userID) {
+		return nil
+	}
+	if attachment.MessageID == "" {
+		return data.ErrorPermissionDenied
+	}
+	message, err := textChatHandler.db.GetMessageByID(request.Context(), attachment.MessageID)
+	if err == data.ErrorMessageNotFound {
+		return data.ErrorPermissionDenied
+	}
+	if err != nil {
+		return err
+	}
+	_, err = textChatHandler.readableConversation(request, message.ConversationID)
+	if err == data.ErrorConversationNotFound {
+		return data.ErrorPermissionDenied
+	}
+	return err
+}
+
+// GetConversationsByUserID returns the conversations of a user
+// Archived conversations are only returned with include_archived=true
+func (textChatHandler *TextChatHandler) GetConversationsByUserID(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getConversationsByUserId")
+	defer span.End()
+	id := getTextChatID(request)
+	includeArchived, _ := strconv.ParseBool(request.URL.Query().Get("include_archived"))
+
+	log.Info("GetConversationsByUserID request for ID", "id", id, "include_archived", includeArchived)
+
+	if !isInternalRequest(request) && id != getRequestUserID(request) {
+		log.Error(data.ErrorPermissionDenied, "Conversations requested for another user", "id", id)
+		http.Error(responseWriter, "Users can only list their own conversations", http.StatusForbidden)
+		return
+	}
+
+	conversations, err := textChatHandler.db.GetConversationsByUserID(request.Context(), id, includeArchived)
+	switch err {
+	case nil:
+		if conversations == nil {
+			conversations = data.Conversations{}
+		}
+		err = json.NewEncoder(responseWriter).Encode(conversations)
+		if err != nil {
+			log.Error(err, "Error serializing conversations")
+		}
+		return
+	default:
+		log.Error(err, "Error fetching conversations")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
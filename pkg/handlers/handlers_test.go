@@ -1,14 +1,23 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
 	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/importer"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
@@ -17,6 +26,18 @@ func newTextChatDB() database.TextChatDB {
 	return database.NewMockTextChat()
 }
 
+func newMultipartAttachment(t *testing.T, fileName string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(content)
+	_ = writer.Close()
+	return body, writer.FormDataContentType()
+}
+
 func TestGetExistingMessageByID(t *testing.T) {
 	request := httptest.NewRequest(http.MethodGet, "/messages/a2181017-5c53-422b-b6bc-036b27c04fc8", nil)
 	response := httptest.NewRecorder()
@@ -28,6 +49,7 @@ func TestGetExistingMessageByID(t *testing.T) {
 		"id": "a2181017-5c53-422b-b6bc-036b27c04fc8",
 	}
 	request = mux.SetURLVars(request, vars)
+	request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8"))
 
 	textChatHandler.GetMessageByID(response, request)
 
@@ -50,6 +72,7 @@ func TestGetExistingConversationByID(t *testing.T) {
 		"id": "a2181017-5c53-422b-b6bc-036b27c04fc8",
 	}
 	request = mux.SetURLVars(request, vars)
+	request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8"))
 
 	textChatHandler.GetMessageByID(response, request)
 
@@ -160,6 +183,7 @@ func TestAddMessage(t *testing.T) {
 
 	// Add the body to the context since we arent passing through middleware
 	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, body.UserID)
 	request = request.WithContext(ctx)
 
 	textChatHandler := NewTextChatHandler(newTextChatDB())
@@ -183,6 +207,7 @@ func TestAddMessageNonExistingConversation(t *testing.T) {
 
 	// Add the body to the context since we arent passing through middleware
 	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, body.UserID)
 	request = request.WithContext(ctx)
 
 	textChatHandler := NewTextChatHandler(newTextChatDB())
@@ -196,8 +221,9 @@ func TestAddMessageNonExistingConversation(t *testing.T) {
 func TestAddConversation(t *testing.T) {
 	// Creating request body
 	body := &data.Conversation{
-		UserID: []string{"a2181017-5c53-422b-b6bc-036b27c04fc8", "2aee2975-6b76-4340-b679-e81661b1cdb5"},
-		GameID: "",
+		UserID:  []string{"a2181017-5c53-422b-b6bc-036b27c04fc8", "2aee2975-6b76-4340-b679-e81661b1cdb5"},
+		GameID:  "",
+		Version: 42,
 	}
 
 	request := httptest.NewRequest(http.MethodPost, "/conversations", nil)
@@ -213,6 +239,11 @@ func TestAddConversation(t *testing.T) {
 	if response.Code != http.StatusOK {
 		t.Errorf("Expected status code %d, but got %d", http.StatusOK, response.Code)
 	}
+	conversation := &data.Conversation{}
+	_ = json.NewDecoder(response.Body).Decode(conversation)
+	if conversation.Version != 0 {
+		t.Errorf("Expected a new conversation to start at version 0, got %d", conversation.Version)
+	}
 }
 
 func TestDeleteExistingMessage(t *testing.T) {
@@ -227,7 +258,14 @@ func TestDeleteExistingMessage(t *testing.T) {
 	}
 	request = mux.SetURLVars(request, vars)
 
-	textChatHandler.DeleteMessage(response, request)
+	// A member that can't moderate the conversation can't delete the messages of the others
+	textChatHandler.DeleteMessage(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "2aee2975-6b76-4340-b679-e81661b1cdb5")))
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d but got : %d", http.StatusForbidden, response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	textChatHandler.DeleteMessage(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8")))
 	if response.Code != http.StatusNoContent {
 		t.Errorf("Expected status code %d but got : %d", http.StatusNoContent, response.Code)
 	}
@@ -244,6 +282,8 @@ func TestDeleteExistingConversation(t *testing.T) {
 		"id": "a2181017-5c53-422b-b6bc-036b27c04fc8",
 	}
 	request = mux.SetURLVars(request, vars)
+	// The first member of a conversation created before roles existed is its owner
+	request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8"))
 
 	textChatHandler.DeleteConversation(response, request)
 	if response.Code != http.StatusNoContent {
@@ -268,12 +308,949 @@ func TestAddUserToExistingConversation(t *testing.T) {
 
 	// Add the body to the context since we arent passing through middleware
 	ctx := context.WithValue(request.Context(), KeyConversation{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, "2aee2975-6b76-4340-b679-e81661b1cdb5")
 	request = request.WithContext(ctx)
 
-	textChatHandler := NewTextChatHandler(newTextChatDB())
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
 	textChatHandler.AddUserToConversation(response, request)
 
 	if response.Code != http.StatusNoContent {
 		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, response.Code)
 	}
+
+	// A change of the members read before the previous change is rejected
+	stale := &data.Conversation{ID: body.ID, UserID: body.UserID[:2], GameID: body.GameID, Version: body.Version - 1}
+	if err := db.AddUserToConversation(context.Background(), stale); err != data.ErrorConversationConflict {
+		t.Errorf("Expected a conflict for a stale version, got %v", err)
+	}
+}
+
+func TestAddMessageWithSystemKindOnPublicEndpoint(t *testing.T) {
+	// Creating request body
+	body := &data.Message{
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		ConversationID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		Text:           "This is a test message",
+		Kind:           data.MessageKindSystem,
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	response := httptest.NewRecorder()
+
+	// Add the body to the context since we arent passing through middleware
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, body.UserID)
+	request = request.WithContext(ctx)
+
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	textChatHandler.AddMessage(response, request)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestAddMessageAsAnotherUser(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+
+	// Neither messages nor commands can be sent in the name of another member
+	for _, text := range []string{"This is a test message", "/mute @a2181017-5c53-422b-b6bc-036b27c04fc8"} {
+		body := &data.Message{
+			UserID:         "2aee2975-6b76-4340-b679-e81661b1cdb5",
+			ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+			Text:           text,
+		}
+		request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+		ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+		ctx = context.WithValue(ctx, KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8")
+		response := httptest.NewRecorder()
+		textChatHandler.AddMessage(response, request.WithContext(ctx))
+
+		if response.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d for %q, but got %d", http.StatusForbidden, text, response.Code)
+		}
+	}
+}
+
+func TestAddSystemMessage(t *testing.T) {
+	// Creating request body
+	body := &data.Message{
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "Round 3 begins",
+		Kind:           data.MessageKindSystem,
+		Sender: &data.Sender{
+			ID:          "game-server",
+			Type:        data.SenderTypeService,
+			DisplayName: "Game",
+		},
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/messages/system", nil)
+	response := httptest.NewRecorder()
+
+	// Add the body to the context since we arent passing through middleware
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	request = request.WithContext(ctx)
+
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	textChatHandler.AddSystemMessage(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	if !strings.Contains(response.Body.String(), `"style":"system"`) {
+		t.Error("Expected system rendering metadata but got : ", response.Body.String())
+	}
+}
+
+func TestAddMessageWithCommand(t *testing.T) {
+	// Creating request body
+	body := &data.Message{
+		UserID:         "2aee2975-6b76-4340-b679-e81661b1cdb5",
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "/help",
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	response := httptest.NewRecorder()
+
+	// Add the body to the context since we arent passing through middleware
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, body.UserID)
+	request = request.WithContext(ctx)
+
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	textChatHandler.AddMessage(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	if !strings.Contains(response.Body.String(), "Available commands") {
+		t.Error("Expected command reply but got : ", response.Body.String())
+	}
+}
+
+func TestGetMentionsByUserID(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+
+	// Creating request body
+	body := &data.Message{
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "Ready @3a1c152e-f172-41de-a5ab-ca21f6573bf3 ?",
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	response := httptest.NewRecorder()
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, body.UserID)
+	textChatHandler.AddMessage(response, request.WithContext(ctx))
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusNoContent, response.Code)
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/users/3a1c152e-f172-41de-a5ab-ca21f6573bf3/mentions", nil)
+	response = httptest.NewRecorder()
+
+	// Mocking gorilla/mux vars
+	vars := map[string]string{
+		"id": "3a1c152e-f172-41de-a5ab-ca21f6573bf3",
+	}
+	request = mux.SetURLVars(request, vars)
+
+	// The mentions of a user are private
+	textChatHandler.GetMentionsByUserID(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, body.UserID)))
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d but got : %d", http.StatusForbidden, response.Code)
+	}
+
+	response = httptest.NewRecorder()
+	textChatHandler.GetMentionsByUserID(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "3a1c152e-f172-41de-a5ab-ca21f6573bf3")))
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got : %d", http.StatusOK, response.Code)
+	}
+	if !strings.Contains(response.Body.String(), `"mentioned_user_id":["3a1c152e-f172-41de-a5ab-ca21f6573bf3"]`) {
+		t.Error("Expected mention in response but got : ", response.Body.String())
+	}
+}
+
+func TestAddSystemMessageWithRichText(t *testing.T) {
+	// Creating request body
+	body := &data.Message{
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "**Round 3** begins",
+		Kind:           data.MessageKindSystem,
+		Sender:         &data.Sender{ID: "game-server", Type: data.SenderTypeService},
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/messages/system", nil)
+	response := httptest.NewRecorder()
+
+	// Add the body to the context since we arent passing through middleware
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	request = request.WithContext(ctx)
+
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	textChatHandler.AddSystemMessage(response, request)
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	if !strings.Contains(response.Body.String(), `"text":"Round 3 begins"`) || !strings.Contains(response.Body.String(), `"entities":[{"type":"bold","offset":0,"length":7}]`) {
+		t.Error("Expected plain text and bold entity but got : ", response.Body.String())
+	}
+}
+
+func TestUploadAttachmentAndSendMessage(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB(), WithBlobStore(attachments.NewLocalBlobStore(t.TempDir())))
+
+	body, contentType := newMultipartAttachment(t, "screenshot.png", []byte("\x89PNG\r\n\x1a\n0000"))
+	request := httptest.NewRequest(http.MethodPost, "/attachments", body)
+	request.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+
+	textChatHandler.UploadAttachment(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8")))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d : %s", http.StatusOK, response.Code, response.Body.String())
+	}
+	attachment := &data.Attachment{}
+	err := json.NewDecoder(response.Body).Decode(attachment)
+	if err != nil || attachment.ContentType != "image/png" || attachment.UserID != "a2181017-5c53-422b-b6bc-036b27c04fc8" {
+		t.Fatalf("Unexpected attachment %+v : %v", attachment, err)
+	}
+
+	download := func(userID string) int {
+		request := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/attachments/"+attachment.ID, nil), map[string]string{"id": attachment.ID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.GetAttachmentByID(response, request)
+		return response.Code
+	}
+	// Until the attachment is sent only its uploader can download it
+	if code := download("2aee2975-6b76-4340-b679-e81661b1cdb5"); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+
+	// Reference the uploaded attachment in a message
+	message := &data.Message{
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "Look at this",
+		Attachments:    []*data.Attachment{{ID: attachment.ID}},
+	}
+	err = message.ValidateMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request = httptest.NewRequest(http.MethodPost, "/messages", nil)
+	response = httptest.NewRecorder()
+	ctx := context.WithValue(request.Context(), KeyMessage{}, message)
+	ctx = context.WithValue(ctx, KeyUserID{}, message.UserID)
+	textChatHandler.AddMessage(response, request.WithContext(ctx))
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusNoContent, response.Code)
+	}
+	// The members of the conversation download the attachments of its messages
+	if code := download("2aee2975-6b76-4340-b679-e81661b1cdb5"); code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+	}
+	if code := download("b7c1e3a0-0f5e-4f7a-9d55-4a3f2c9e8d11"); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+
+	// Deleting the message deletes its attachments
+	request = mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/messages/"+message.ID, nil), map[string]string{"id": message.ID})
+	response = httptest.NewRecorder()
+	textChatHandler.DeleteMessage(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, message.UserID)))
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusNoContent, response.Code)
+	}
+
+	if code := download(message.UserID); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestUploadAttachmentWithForbiddenType(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB(), WithBlobStore(attachments.NewLocalBlobStore(t.TempDir())))
+
+	body, contentType := newMultipartAttachment(t, "page.png", []byte("<html><body>not an image</body></html>"))
+	request := httptest.NewRequest(http.MethodPost, "/attachments", body)
+	request.Header.Set("Content-Type", contentType)
+	response := httptest.NewRecorder()
+
+	textChatHandler.UploadAttachment(response, request.WithContext(context.WithValue(request.Context(), KeyUserID{}, "a2181017-5c53-422b-b6bc-036b27c04fc8")))
+	if response.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnsupportedMediaType, response.Code)
+	}
+
+	// The uploader is the user making the request
+	body, contentType = newMultipartAttachment(t, "screenshot.png", []byte("\x89PNG\r\n\x1a\n0000"))
+	request = httptest.NewRequest(http.MethodPost, "/attachments", body)
+	request.Header.Set("Content-Type", contentType)
+	response = httptest.NewRecorder()
+	textChatHandler.UploadAttachment(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestStreamConversationEvents(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+
+	router := mux.NewRouter()
+	router.Use(textChatHandler.MiddlewareUserIdentity)
+	router.HandleFunc("/conversations/{id:[0-9a-z-]+}/events", textChatHandler.StreamConversationEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	streamRequest := func(userID string) *http.Response {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", bearerToken(userID))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	response := streamRequest("b7c1e3a0-0f5e-4f7a-9d55-4a3f2c9e8d11")
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a user that is not a member, but got %d", http.StatusForbidden, response.StatusCode)
+	}
+
+	response = streamRequest("3a1c152e-f172-41de-a5ab-ca21f6573bf3")
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream but got %s", response.Header.Get("Content-Type"))
+	}
+
+	// Send a message once the client is subscribed
+	body := &data.Message{
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "Player X joined",
+		Kind:           data.MessageKindSystem,
+		Sender:         &data.Sender{ID: "game-server", Type: data.SenderTypeService},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/messages/system", nil)
+	ctx := context.WithValue(request.Context(), KeyMessage{}, body)
+	textChatHandler.AddSystemMessage(httptest.NewRecorder(), request.WithContext(ctx))
+
+	reader := bufio.NewReader(response.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: message.created\n" {
+		t.Errorf("Expected message created event but got %q", line)
+	}
+	line, _ = reader.ReadString('\n')
+	if !strings.Contains(line, "Player X joined") {
+		t.Errorf("Expected message in event data but got %q", line)
+	}
+
+	// Deleting the message notifies the clients
+	request = httptest.NewRequest(http.MethodDelete, "/messages/"+body.ID, nil)
+	request = mux.SetURLVars(request, map[string]string{"id": body.ID})
+	textChatHandler.DeleteMessage(httptest.NewRecorder(), request.WithContext(context.WithValue(request.Context(), KeyInternal{}, true)))
+	_, _ = reader.ReadString('\n')
+	line, _ = reader.ReadString('\n')
+	if line != "event: message.deleted\n" {
+		t.Errorf("Expected message deleted event but got %q", line)
+	}
+}
+
+// bearerToken returns an unsigned bearer token of the user, the identity middleware doesn't verify the signature
+func bearerToken(userID string) string {
+	payload, _ := json.Marshal(map[string]string{"sub": userID})
+	return "Bearer header." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+func TestAddDirectConversationIsUniquePerPair(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	var ids []string
+	for _, users := range [][]string{{"3a1c152e-f172-41de-a5ab-ca21f6573bf3", "c6e6a2b2-bd25-4151-ace1-611accc15a50"}, {"c6e6a2b2-bd25-4151-ace1-611accc15a50", "3a1c152e-f172-41de-a5ab-ca21f6573bf3"}} {
+		body := &data.Conversation{Type: data.ConversationTypeDirect, UserID: users}
+
+		request := httptest.NewRequest(http.MethodPost, "/conversations", nil)
+		response := httptest.NewRecorder()
+		ctx := context.WithValue(request.Context(), KeyConversation{}, body)
+		textChatHandler.AddConversation(response, request.WithContext(ctx))
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+		}
+		conversation := &data.Conversation{}
+		_ = json.NewDecoder(response.Body).Decode(conversation)
+		ids = append(ids, conversation.ID)
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("Expected the same direct conversation but got %s and %s", ids[0], ids[1])
+	}
+
+	// The members identify the direct conversation, so they can't be replaced
+	body := &data.Conversation{ID: ids[0], UserID: []string{"a2181017-5c53-422b-b6bc-036b27c04fc8", "2aee2975-6b76-4340-b679-e81661b1cdb5"}}
+	request := httptest.NewRequest(http.MethodPut, "/conversations", nil)
+	response := httptest.NewRecorder()
+	ctx := context.WithValue(request.Context(), KeyConversation{}, body)
+	ctx = context.WithValue(ctx, KeyUserID{}, "3a1c152e-f172-41de-a5ab-ca21f6573bf3")
+	textChatHandler.AddUserToConversation(response, request.WithContext(ctx))
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestGameConversationOnlyOnInternalRouter(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB())
+	body := &data.Conversation{
+		Type:   data.ConversationTypeMatch,
+		UserID: []string{"a2181017-5c53-422b-b6bc-036b27c04fc8", "2aee2975-6b76-4340-b679-e81661b1cdb5"},
+		GameID: "a2181017-5c53-422b-b6bc-036b27c04fc8",
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/conversations", nil)
+	response := httptest.NewRecorder()
+	ctx := context.WithValue(request.Context(), KeyConversation{}, body)
+	textChatHandler.AddConversation(response, request.WithContext(ctx))
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusForbidden, response.Code)
+	}
+
+	// The internal middleware marks the request as coming from the game server
+	response = httptest.NewRecorder()
+	textChatHandler.MiddlewareInternal(http.HandlerFunc(textChatHandler.AddConversation)).ServeHTTP(response, request.WithContext(ctx))
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	conversation := &data.Conversation{}
+	_ = json.NewDecoder(response.Body).Decode(conversation)
+
+	// Members of the match can't be changed on the public router
+	update := &data.Conversation{ID: conversation.ID, UserID: []string{"a2181017-5c53-422b-b6bc-036b27c04fc8"}}
+	request = httptest.NewRequest(http.MethodPut, "/conversations", nil)
+	response = httptest.NewRecorder()
+	ctx = context.WithValue(request.Context(), KeyConversation{}, update)
+	textChatHandler.AddUserToConversation(response, request.WithContext(ctx))
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, response.Code)
+	}
+}
+
+func TestConversationRolePermissions(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	owner := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	member := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+
+	// The user creating the group is its owner, whatever roles are sent
+	body := &data.Conversation{
+		UserID: []string{member, owner, "3a1c152e-f172-41de-a5ab-ca21f6573bf3"},
+		Roles:  map[string]string{member: data.RoleOwner},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/conversations", nil)
+	ctx := context.WithValue(request.Context(), KeyConversation{}, body)
+	request = request.WithContext(context.WithValue(ctx, KeyUserID{}, owner))
+	response := httptest.NewRecorder()
+	textChatHandler.AddConversation(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	conversation := &data.Conversation{}
+	_ = json.NewDecoder(response.Body).Decode(conversation)
+	if conversation.Owner() != owner {
+		t.Fatalf("Expected %s to be the owner, got %s", owner, conversation.Owner())
+	}
+
+	roleRequest := func(handler http.HandlerFunc, userID string, roleChange *data.RoleChange) int {
+		request := httptest.NewRequest(http.MethodPut, "/conversations/"+conversation.ID+"/roles", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversation.ID})
+		ctx := context.WithValue(request.Context(), KeyRoleChange{}, roleChange)
+		request = request.WithContext(context.WithValue(ctx, KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response.Code
+	}
+
+	// Only the owner manages roles
+	code := roleRequest(textChatHandler.SetMemberRole, member, &data.RoleChange{UserID: member, Role: data.RoleModerator})
+	if code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+	code = roleRequest(textChatHandler.SetMemberRole, owner, &data.RoleChange{UserID: member, Role: data.RoleReadOnly})
+	if code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+	}
+
+	// A read-only member can't send messages
+	message := &data.Message{UserID: member, ConversationID: conversation.ID, Text: "hello"}
+	request = httptest.NewRequest(http.MethodPost, "/messages", nil)
+	request = request.WithContext(context.WithValue(context.WithValue(request.Context(), KeyMessage{}, message), KeyUserID{}, message.UserID))
+	response = httptest.NewRecorder()
+	textChatHandler.AddMessage(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, response.Code)
+	}
+
+	// Only the owner can delete the conversation
+	request = httptest.NewRequest(http.MethodDelete, "/conversations/"+conversation.ID, nil)
+	request = mux.SetURLVars(request, map[string]string{"id": conversation.ID})
+	request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, member))
+	response = httptest.NewRecorder()
+	textChatHandler.DeleteConversation(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, response.Code)
+	}
+
+	// The previous owner becomes a moderator after a transfer
+	code = roleRequest(textChatHandler.TransferOwnership, owner, &data.RoleChange{UserID: member})
+	if code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+	}
+	updated, _ := db.GetConversationByID(context.Background(), conversation.ID)
+	if updated.Owner() != member || updated.RoleOf(owner) != data.RoleModerator {
+		t.Errorf("Expected ownership to be transferred, got roles %v", updated.Roles)
+	}
+}
+
+func TestPatchConversation(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	owner := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	member := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+
+	conversation := &data.Conversation{UserID: []string{owner, member}}
+	conversation.InitRoles(owner)
+	conversation, err := db.AddConversation(context.Background(), conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchRequest := func(userID string, patch *data.ConversationPatch) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPatch, "/conversations/"+conversation.ID, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversation.ID})
+		ctx := context.WithValue(request.Context(), KeyConversationPatch{}, patch)
+		request = request.WithContext(context.WithValue(ctx, KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.PatchConversation(response, request)
+		return response
+	}
+	title := "Raid team"
+	version := int64(0)
+
+	// Members can't rename the conversation
+	response := patchRequest(member, &data.ConversationPatch{Title: &title, Version: &version})
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, response.Code)
+	}
+
+	value := "eu-west"
+	response = patchRequest(owner, &data.ConversationPatch{Title: &title, Attributes: map[string]*string{"region": &value}, Version: &version})
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, response.Code)
+	}
+	updated := &data.Conversation{}
+	_ = json.NewDecoder(response.Body).Decode(updated)
+	if updated.Title != title || updated.Attributes["region"] != value || updated.Version != 1 {
+		t.Errorf("Expected the conversation to be updated, got %+v", updated)
+	}
+
+	// The rename is announced in the conversation
+	messages, _ := db.GetMessagesByConversationID(context.Background(), conversation.ID)
+	if len(messages) != 1 || messages[0].Kind != data.MessageKindSystem || !strings.Contains(messages[0].Text, title) {
+		t.Errorf("Expected a system message announcing the rename, got %v", messages)
+	}
+
+	// The update of a stale version is rejected
+	topic := "Wednesday 8pm"
+	response = patchRequest(owner, &data.ConversationPatch{Topic: &topic, Version: &version})
+	if response.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, but got %d", http.StatusConflict, response.Code)
+	}
+
+	avatar := "not a url"
+	version = 1
+	response = patchRequest(owner, &data.ConversationPatch{AvatarURL: &avatar, Version: &version})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, response.Code)
+	}
+}
+
+func TestPinnedMessages(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	owner := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	member := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+
+	conversation := &data.Conversation{UserID: []string{owner, member}}
+	conversation.InitRoles(owner)
+	conversation, err := db.AddConversation(context.Background(), conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := &data.Message{UserID: owner, ConversationID: conversation.ID, Text: "Rules"}
+	schedule := &data.Message{UserID: owner, ConversationID: conversation.ID, Text: "Schedule"}
+	for _, message := range []*data.Message{rules, schedule} {
+		if err = db.AddMessage(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pinRequest := func(handler http.HandlerFunc, userID string, messageID string) int {
+		request := httptest.NewRequest(http.MethodPost, "/conversations/"+conversation.ID+"/pins/"+messageID, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversation.ID, "messageID": messageID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response.Code
+	}
+
+	if code := pinRequest(textChatHandler.PinMessage, member, rules.ID); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+	for _, message := range []*data.Message{schedule, rules} {
+		if code := pinRequest(textChatHandler.PinMessage, owner, message.ID); code != http.StatusNoContent {
+			t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+		}
+	}
+	// Messages of other conversations can't be pinned
+	if code := pinRequest(textChatHandler.PinMessage, owner, "a2181017-5c53-422b-b6bc-036b27c04fc8"); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+
+	getPins := func(userID string) (data.Messages, int) {
+		request := httptest.NewRequest(http.MethodGet, "/conversations/"+conversation.ID+"/pins", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversation.ID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.GetPinnedMessages(response, request)
+		messages := data.Messages{}
+		_ = json.NewDecoder(response.Body).Decode(&messages)
+		return messages, response.Code
+	}
+	if _, code := getPins("3a1c152e-f172-41de-a5ab-ca21f6573bf3"); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a user that is not a member, but got %d", http.StatusForbidden, code)
+	}
+	pins, _ := getPins(member)
+	if len(pins) != 2 || pins[0].Text != "Schedule" || pins[1].Text != "Rules" {
+		t.Errorf("Expected the pinned messages in pin order, got %v", pins)
+	}
+
+	// A deleted message is unpinned
+	if err = db.DeleteMessage(context.Background(), schedule.ID); err != nil {
+		t.Fatal(err)
+	}
+	pins, _ = getPins(member)
+	if len(pins) != 1 || pins[0].ID != rules.ID {
+		t.Errorf("Expected the deleted message to be unpinned, got %v", pins)
+	}
+
+	if code := pinRequest(textChatHandler.UnpinMessage, owner, rules.ID); code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+	}
+	if code := pinRequest(textChatHandler.UnpinMessage, owner, rules.ID); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestArchiveAndRestoreConversation(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	owner := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	member := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+
+	conversation := &data.Conversation{UserID: []string{owner, member}}
+	conversation.InitRoles(owner)
+	conversation, err := db.AddConversation(context.Background(), conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archiveRequest := func(handler http.HandlerFunc, userID string) int {
+		request := httptest.NewRequest(http.MethodPost, "/conversations/"+conversation.ID+"/archive", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversation.ID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response.Code
+	}
+	listRequest := func(userID string, query string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/users/"+member+"/conversations"+query, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": member})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.GetConversationsByUserID(response, request)
+		return response
+	}
+	listConversations := func(query string) data.Conversations {
+		conversations := data.Conversations{}
+		_ = json.NewDecoder(listRequest(member, query).Body).Decode(&conversations)
+		return conversations
+	}
+	isListed := func(conversations data.Conversations) bool {
+		for _, listed := range conversations {
+			if listed.ID == conversation.ID {
+				return true
+			}
+		}
+		return false
+	}
+	// The conversations of a user are private, direct conversations included
+	if code := listRequest(owner, "").Code; code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+
+	if code := archiveRequest(textChatHandler.ArchiveConversation, member); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+	if code := archiveRequest(textChatHandler.ArchiveConversation, owner); code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+	}
+
+	// Archived conversations are read-only
+	message := &data.Message{UserID: member, ConversationID: conversation.ID, Text: "hello"}
+	request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	request = request.WithContext(context.WithValue(context.WithValue(request.Context(), KeyMessage{}, message), KeyUserID{}, message.UserID))
+	response := httptest.NewRecorder()
+	textChatHandler.AddMessage(response, request)
+	if response.Code != http.StatusForbidden || !strings.Contains(response.Body.String(), "archived") {
+		t.Errorf("Expected status code %d with an archived error, but got %d: %s", http.StatusForbidden, response.Code, response.Body.String())
+	}
+
+	// Archived conversations are hidden from the default listing
+	if isListed(listConversations("")) || !isListed(listConversations("?include_archived=true")) {
+		t.Error("Expected the archived conversation to only be listed with include_archived")
+	}
+
+	if code := archiveRequest(textChatHandler.RestoreConversation, owner); code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, code)
+	}
+	if !isListed(listConversations("")) {
+		t.Error("Expected the restored conversation to be listed")
+	}
+}
+
+func TestGameChannels(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	gameID := "b7c1e3a0-0f5e-4f7a-9d55-4a3f2c9e8d11"
+	blue := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	red := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+	spectator := "c6e6a2b2-bd25-4151-ace1-611accc15a50"
+
+	match, err := textChatHandler.matches.Start(context.Background(), &data.MatchRoster{
+		MatchID:    gameID,
+		Teams:      map[string][]string{"blue": {blue}, "red": {red}},
+		Spectators: []string{spectator},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(userID string, channel string) int {
+		message := &data.Message{UserID: userID, GameID: gameID, Channel: channel, Text: "push " + channel}
+		request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+		request = request.WithContext(context.WithValue(context.WithValue(request.Context(), KeyMessage{}, message), KeyUserID{}, message.UserID))
+		response := httptest.NewRecorder()
+		textChatHandler.AddMessage(response, request)
+		return response.Code
+	}
+	if code := send(blue, data.ChannelTeam); code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+	}
+	if code := send(red, data.ChannelAll); code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+	}
+	// Spectators have no team and can't write in the all-chat, they talk in the spectator channel
+	if code := send(spectator, data.ChannelTeam); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+	if code := send(spectator, data.ChannelAll); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+	if code := send(spectator, data.ChannelSpectator); code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+	}
+	if code := send(blue, data.ChannelSpectator); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+
+	gameRequest := func(handler http.HandlerFunc, userID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/games/"+gameID+"/messages", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": gameID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response
+	}
+
+	// Every player sees the all-chat and its own team
+	messages := data.Messages{}
+	_ = json.NewDecoder(gameRequest(textChatHandler.GetGameMessages, blue).Body).Decode(&messages)
+	if len(messages) != 2 || messages[0].Channel != data.ChannelTeam || messages[0].ConversationID != match.TeamChatID["blue"] {
+		t.Errorf("Expected the team and all-chat messages in order, got %v", messages)
+	}
+	messages = data.Messages{}
+	_ = json.NewDecoder(gameRequest(textChatHandler.GetGameMessages, red).Body).Decode(&messages)
+	if len(messages) != 1 || messages[0].Channel != data.ChannelAll {
+		t.Errorf("Expected only the all-chat message, got %v", messages)
+	}
+
+	// Spectators see the all-chat and the spectator chat, without the messages of the last spectator delay in the all-chat
+	conversations := data.Conversations{}
+	_ = json.NewDecoder(gameRequest(textChatHandler.GetGameConversations, spectator).Body).Decode(&conversations)
+	if len(conversations) != 2 || conversations[0].ID == match.TeamChatID["blue"] || conversations[1].ID == match.TeamChatID["blue"] {
+		t.Errorf("Expected the all-chat and the spectator chat, got %v", conversations)
+	}
+	messages = data.Messages{}
+	_ = json.NewDecoder(gameRequest(textChatHandler.GetGameMessages, spectator).Body).Decode(&messages)
+	if len(messages) != 1 || messages[0].Channel != data.ChannelSpectator || messages[0].ConversationID != match.SpectatorChatID {
+		t.Errorf("Expected only the spectator message, got %v", messages)
+	}
+
+	// The messages of a conversation are read with the same visibility
+	conversationRequest := func(handler http.HandlerFunc, userID string, conversationID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/messages/conversation/"+conversationID, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": conversationID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response
+	}
+	for _, userID := range []string{red, spectator} {
+		for _, handler := range []http.HandlerFunc{textChatHandler.GetMessagesByConversationID, textChatHandler.GetConversationByID, textChatHandler.GetPinnedMessages} {
+			if code := conversationRequest(handler, userID, match.TeamChatID["blue"]).Code; code != http.StatusForbidden {
+				t.Errorf("Expected status code %d for the team chat of the other team, but got %d", http.StatusForbidden, code)
+			}
+		}
+	}
+	if code := conversationRequest(textChatHandler.GetConversationByID, blue, match.TeamChatID["blue"]).Code; code != http.StatusOK {
+		t.Errorf("Expected status code %d for the team chat of the player, but got %d", http.StatusOK, code)
+	}
+	messages = data.Messages{}
+	response := conversationRequest(textChatHandler.GetMessagesByConversationID, spectator, match.AllChatID)
+	_ = json.NewDecoder(response.Body).Decode(&messages)
+	if response.Code != http.StatusOK || len(messages) != 0 {
+		t.Errorf("Expected the all-chat messages of the spectator delay to be hidden, got %d %v", response.Code, messages)
+	}
+	messages = data.Messages{}
+	_ = json.NewDecoder(conversationRequest(textChatHandler.GetMessagesByConversationID, blue, match.TeamChatID["blue"]).Body).Decode(&messages)
+	if len(messages) != 1 {
+		t.Fatalf("Expected the team chat message, got %v", messages)
+	}
+	messageRequest := httptest.NewRequest(http.MethodGet, "/messages/"+messages[0].ID, nil)
+	messageRequest = mux.SetURLVars(messageRequest, map[string]string{"id": messages[0].ID})
+	messageRequest = messageRequest.WithContext(context.WithValue(messageRequest.Context(), KeyUserID{}, red))
+	response = httptest.NewRecorder()
+	textChatHandler.GetMessageByID(response, messageRequest)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a message of the other team, but got %d", http.StatusForbidden, response.Code)
+	}
+
+	WithSpectatorDelay(0)(textChatHandler)
+	messages = data.Messages{}
+	_ = json.NewDecoder(gameRequest(textChatHandler.GetGameMessages, spectator).Body).Decode(&messages)
+	if len(messages) != 2 {
+		t.Errorf("Expected the all-chat and spectator messages without delay, got %v", messages)
+	}
+
+	if code := gameRequest(textChatHandler.GetGameConversations, "").Code; code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, but got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	db := newTextChatDB()
+	textChatHandler := NewTextChatHandler(db)
+	organizer := "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	member := "2aee2975-6b76-4340-b679-e81661b1cdb5"
+
+	conversation, err := db.AddConversation(context.Background(), &data.Conversation{UserID: []string{organizer, member}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendAt := time.Now().Add(10 * time.Minute)
+	message := &data.Message{UserID: organizer, ConversationID: conversation.ID, Text: "Tournament starts in 10 minutes", SendAt: &sendAt}
+	request := httptest.NewRequest(http.MethodPost, "/messages", nil)
+	request = request.WithContext(context.WithValue(context.WithValue(request.Context(), KeyMessage{}, message), KeyUserID{}, message.UserID))
+	response := httptest.NewRecorder()
+	textChatHandler.AddMessage(response, request)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusAccepted, response.Code)
+	}
+	scheduled := &data.ScheduledMessage{}
+	_ = json.NewDecoder(response.Body).Decode(scheduled)
+	if _, err = db.GetMessagesByConversationID(context.Background(), conversation.ID); err != data.ErrorMessageNotFound {
+		t.Errorf("Expected the message not to be sent before its send time, got %v", err)
+	}
+
+	list := func(userID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/users/"+organizer+"/scheduled-messages", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": organizer})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.GetScheduledMessagesByUserID(response, request)
+		return response
+	}
+	scheduledMessages := data.ScheduledMessages{}
+	_ = json.NewDecoder(list(organizer).Body).Decode(&scheduledMessages)
+	if len(scheduledMessages) != 1 || scheduledMessages[0].ID != scheduled.ID {
+		t.Errorf("Expected the pending scheduled message, got %v", scheduledMessages)
+	}
+	if code := list(member).Code; code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+
+	cancel := func(userID string) int {
+		request := httptest.NewRequest(http.MethodDelete, "/scheduled-messages/"+scheduled.ID, nil)
+		request = mux.SetURLVars(request, map[string]string{"id": scheduled.ID})
+		request = request.WithContext(context.WithValue(request.Context(), KeyUserID{}, userID))
+		response := httptest.NewRecorder()
+		textChatHandler.CancelScheduledMessage(response, request)
+		return response.Code
+	}
+	if code := cancel(member); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d, but got %d", http.StatusForbidden, code)
+	}
+	if code := cancel(organizer); code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, code)
+	}
+	if code := cancel(organizer); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestImportReadDeadline(t *testing.T) {
+	textChatHandler := NewTextChatHandler(newTextChatDB(), WithImportTimeout(5*time.Second))
+
+	// The other requests of the server have a short read timeout
+	server := httptest.NewUnstartedServer(http.HandlerFunc(textChatHandler.ImportRecords))
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.ConnContext = ConnContext
+	server.Start()
+	defer server.Close()
+
+	body, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte(`{"type":"conversation","conversation":{"id":"deadline-group","user_id":["deadline-a"],"created_on":"2020-05-01T18:00:00Z"}}` + "\n"))
+		time.Sleep(300 * time.Millisecond)
+		_, _ = writer.Write([]byte(`{"type":"message","message":{"id":"deadline-m1","user_id":"deadline-a","conversation_id":"deadline-group","text":"hi","created_on":"2020-05-01T18:01:00Z"}}` + "\n"))
+		_ = writer.Close()
+	}()
+
+	response, err := http.Post(server.URL, "application/x-ndjson", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	report := importer.Report{}
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil || response.StatusCode != http.StatusOK || !report.Completed || report.Messages != 1 {
+		t.Errorf("Expected the slow import to complete, got %d %+v, %v", response.StatusCode, report, err)
+	}
 }
@@ -2,8 +2,6 @@ package handlers
 
 import (
 	"net/http"
-
-	"github.com/Ubivius/microservice-text-chat/pkg/data"
 )
 
 // LivenessCheck determine when the application needs to be restarted
@@ -11,7 +9,7 @@ func (textChatHandler *TextChatHandler) LivenessCheck(responseWriter http.Respon
 	responseWriter.WriteHeader(http.StatusOK)
 }
 
-//ReadinessCheck verifies that the application is ready to accept requests
+// ReadinessCheck verifies that the application is ready to accept requests
 func (textChatHandler *TextChatHandler) ReadinessCheck(responseWriter http.ResponseWriter, request *http.Request) {
 	err := textChatHandler.db.PingDB()
 
@@ -21,7 +19,7 @@ func (textChatHandler *TextChatHandler) ReadinessCheck(responseWriter http.Respo
 		return
 	}
 
-	readinessProbeMicroserviceUser := data.MicroserviceUserPath + "/health/ready"
+	readinessProbeMicroserviceUser := textChatHandler.userServiceURL + "/health/ready"
 	_, err = http.Get(readinessProbeMicroserviceUser)
 
 	if err != nil {
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// KeyUserID is a key used for the ID of the user making the request inside context
+type KeyUserID struct{}
+
+// MiddlewareUserIdentity adds the ID of the user making the request to the context
+// The ID is the subject of the bearer token, the token itself is verified by the authentication middleware
+func (textChatHandler *TextChatHandler) MiddlewareUserIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		userID := tokenSubject(request.Header.Get("Authorization"))
+		if userID != "" {
+			ctx := context.WithValue(request.Context(), KeyUserID{}, userID)
+			request = request.WithContext(ctx)
+		}
+		next.ServeHTTP(responseWriter, request)
+	})
+}
+
+// tokenSubject returns the subject of a bearer JWT, or an empty string
+func tokenSubject(authorization string) string {
+	parts := strings.Split(strings.TrimPrefix(authorization, "Bearer "), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	claims := struct {
+		Subject string `json:"sub"`
+	}{}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// getRequestUserID returns the ID of the user making the request
+func getRequestUserID(request *http.Request) string {
+	userID, _ := request.Context().Value(KeyUserID{}).(string)
+	return userID
+}
+
+// canRead returns true when the user making the request is a member of the conversation, spectators included
+// Requests from the internal router come from trusted services and can read every conversation
+func canRead(request *http.Request, conversation *data.Conversation) bool {
+	return isInternalRequest(request) || conversation.HasUser(getRequestUserID(request))
+}
+
+// hasPermission returns true when the user making the request has the permission in the conversation
+// Requests from the internal router come from trusted services and have every permission
+func hasPermission(request *http.Request, conversation *data.Conversation, permission data.Permission) bool {
+	return isInternalRequest(request) || conversation.Can(getRequestUserID(request), permission)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/importer"
+	"go.opentelemetry.io/otel"
+)
+
+// ImportRecords imports the NDJSON conversations and messages of the body with their original IDs and timestamps
+// The resume_from query parameter is the first line imported, the report of an import stopped by an error tells where to resume it
+func (textChatHandler *TextChatHandler) ImportRecords(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "importRecords")
+	defer span.End()
+
+	resumeFrom := 1
+	if value := request.URL.Query().Get("resume_from"); value != "" {
+		line, err := strconv.Atoi(value)
+		if err != nil || line < 1 {
+			log.Error(err, "Invalid import resume line", "resume_from", value)
+			http.Error(responseWriter, "Invalid resume_from, expected a line number", http.StatusBadRequest)
+			return
+		}
+		resumeFrom = line
+	}
+	log.Info("ImportRecords request", "resume_from", resumeFrom)
+
+	// The body is read while it is imported, so the import has a longer deadline than the other internal requests
+	if conn, ok := request.Context().Value(KeyConn{}).(net.Conn); ok {
+		err := conn.SetReadDeadline(time.Now().Add(textChatHandler.importTimeout))
+		if err != nil {
+			log.Error(err, "Error extending the read deadline of the import")
+		}
+	}
+
+	report, err := importer.NewImporter(textChatHandler.db).Import(request.Context(), request.Body, resumeFrom)
+	if err != nil {
+		log.Error(err, "Import stopped", "resume_from", report.ResumeFrom)
+		// The report tells where to resume the import
+		responseWriter.Header().Set("Content-Type", "application/json")
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+	}
+	err = json.NewEncoder(responseWriter).Encode(report)
+	if err != nil {
+		log.Error(err, "Error serializing import report")
+	}
+}
+
+// ConnContext puts the connection of the requests inside their context, it is the ConnContext of the internal server
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, KeyConn{}, conn)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+)
+
+// StartMatch creates the conversations of a match from its roster
+func (textChatHandler *TextChatHandler) StartMatch(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "startMatch")
+	defer span.End()
+	roster := request.Context().Value(KeyMatchRoster{}).(*data.MatchRoster)
+	log.Info("StartMatch request", "match_id", roster.MatchID)
+
+	match, err := textChatHandler.matches.Start(request.Context(), roster)
+	writeMatchResult(responseWriter, match, err)
+}
+
+// GetMatch returns the conversations of a match
+func (textChatHandler *TextChatHandler) GetMatch(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getMatch")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("GetMatch request", "match_id", id)
+
+	match, err := textChatHandler.matches.Get(request.Context(), id)
+	writeMatchResult(responseWriter, match, err)
+}
+
+// JoinMatch adds a player to the all-chat and to its team conversation
+func (textChatHandler *TextChatHandler) JoinMatch(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "joinMatch")
+	defer span.End()
+	id := getTextChatID(request)
+	player := request.Context().Value(KeyMatchPlayer{}).(*data.MatchPlayer)
+	log.Info("JoinMatch request", "match_id", id, "user_id", player.UserID, "team", player.Team)
+
+	match, err := textChatHandler.matches.Join(request.Context(), id, player)
+	writeMatchResult(responseWriter, match, err)
+}
+
+// SwapTeam moves a player of a match to another team conversation
+func (textChatHandler *TextChatHandler) SwapTeam(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "swapTeam")
+	defer span.End()
+	id := getTextChatID(request)
+	player := request.Context().Value(KeyMatchPlayer{}).(*data.MatchPlayer)
+	log.Info("SwapTeam request", "match_id", id, "user_id", player.UserID, "team", player.Team)
+
+	match, err := textChatHandler.matches.SwapTeam(request.Context(), id, player)
+	writeMatchResult(responseWriter, match, err)
+}
+
+// LeaveMatch removes a player from every conversation of a match
+func (textChatHandler *TextChatHandler) LeaveMatch(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "leaveMatch")
+	defer span.End()
+	id := getTextChatID(request)
+	userID := mux.Vars(request)["userID"]
+	log.Info("LeaveMatch request", "match_id", id, "user_id", userID)
+
+	err := textChatHandler.matches.Leave(request.Context(), id, userID)
+	if err == nil {
+		responseWriter.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeMatchResult(responseWriter, nil, err)
+}
+
+// EndMatch archives the conversations of a match, the transcript is returned with transcript=true
+func (textChatHandler *TextChatHandler) EndMatch(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "endMatch")
+	defer span.End()
+	id := getTextChatID(request)
+	withTranscript, _ := strconv.ParseBool(request.URL.Query().Get("transcript"))
+	log.Info("EndMatch request", "match_id", id, "transcript", withTranscript)
+
+	transcript, err := textChatHandler.matches.End(request.Context(), id, withTranscript)
+	writeMatchResult(responseWriter, transcript, err)
+}
+
+// writeMatchResult writes the result of a match operation or maps its error to a status
+func writeMatchResult(responseWriter http.ResponseWriter, result interface{}, err error) {
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(result)
+		if err != nil {
+			log.Error(err, "Error serializing match")
+		}
+		return
+	case data.ErrorMatchNotFound:
+		log.Error(err, "Match not found")
+		http.Error(responseWriter, "Match not found", http.StatusNotFound)
+		return
+	case data.ErrorTeamNotFound:
+		log.Error(err, "Team not found")
+		http.Error(responseWriter, "Team not found", http.StatusBadRequest)
+		return
+	case data.ErrorUserNotFound:
+		log.Error(err, "User is not a player of the match")
+		http.Error(responseWriter, "User is not a player of the match", http.StatusBadRequest)
+		return
+	case data.ErrorGameNotFound:
+		log.Error(err, "GameID doesn't exist")
+		http.Error(responseWriter, "GameID doesn't exist", http.StatusBadRequest)
+		return
+	case data.ErrorConversationArchived:
+		log.Error(err, "Match already ended")
+		http.Error(responseWriter, "Match already ended", http.StatusConflict)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Match conversations changed concurrently")
+		http.Error(responseWriter, "Match was modified, try again", http.StatusConflict)
+		return
+	default:
+		log.Error(err, "Error updating match")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -40,6 +40,34 @@ func (textChatHandler *TextChatHandler) MiddlewareMessageValidation(next http.Ha
 	})
 }
 
+// MiddlewareSystemMessageValidation is used to validate incoming system and bot message JSONS
+func (textChatHandler *TextChatHandler) MiddlewareSystemMessageValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		message := &data.Message{}
+		err := json.NewDecoder(request.Body).Decode(message)
+		if err != nil {
+			log.Error(err, "Error deserializing system message")
+			http.Error(responseWriter, "Error reading message", http.StatusBadRequest)
+			return
+		}
+
+		// validate the message
+		err = message.ValidateSystemMessage()
+		if err != nil {
+			log.Error(err, "Error validating system message")
+			http.Error(responseWriter, fmt.Sprintf("Error validating message: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Add the message to the context
+		ctx := context.WithValue(request.Context(), KeyMessage{}, message)
+		newRequest := request.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware or the final handler
+		next.ServeHTTP(responseWriter, newRequest)
+	})
+}
+
 // MiddlewareConversatonValidation is used to validate incoming conversation JSONS
 func (textChatHandler *TextChatHandler) MiddlewareConversationValidation(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
@@ -68,3 +96,124 @@ func (textChatHandler *TextChatHandler) MiddlewareConversationValidation(next ht
 		next.ServeHTTP(responseWriter, newRequest)
 	})
 }
+
+// MiddlewareConversationPatchValidation is used to validate incoming conversation patch JSONS
+// The patched conversation is validated by the handler
+func (textChatHandler *TextChatHandler) MiddlewareConversationPatchValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		patch := &data.ConversationPatch{}
+		err := json.NewDecoder(request.Body).Decode(patch)
+		if err != nil {
+			log.Error(err, "Error deserializing conversation patch")
+			http.Error(responseWriter, "Error reading conversation", http.StatusBadRequest)
+			return
+		}
+
+		// validate the patch
+		err = patch.ValidateConversationPatch()
+		if err != nil {
+			log.Error(err, "Error validating conversation patch")
+			http.Error(responseWriter, fmt.Sprintf("Error validating conversation: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Add the patch to the context
+		ctx := context.WithValue(request.Context(), KeyConversationPatch{}, patch)
+		newRequest := request.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware or the final handler
+		next.ServeHTTP(responseWriter, newRequest)
+	})
+}
+
+// MiddlewareRoleChangeValidation is used to validate incoming role change JSONS
+func (textChatHandler *TextChatHandler) MiddlewareRoleChangeValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		roleChange := &data.RoleChange{}
+		err := json.NewDecoder(request.Body).Decode(roleChange)
+		if err != nil {
+			log.Error(err, "Error deserializing role change")
+			http.Error(responseWriter, "Error reading role", http.StatusBadRequest)
+			return
+		}
+
+		// validate the role change
+		err = roleChange.ValidateRoleChange()
+		if err != nil {
+			log.Error(err, "Error validating role change")
+			http.Error(responseWriter, fmt.Sprintf("Error validating role: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Add the role change to the context
+		ctx := context.WithValue(request.Context(), KeyRoleChange{}, roleChange)
+		newRequest := request.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware or the final handler
+		next.ServeHTTP(responseWriter, newRequest)
+	})
+}
+
+// MiddlewareMatchRosterValidation is used to validate incoming match roster JSONS
+func (textChatHandler *TextChatHandler) MiddlewareMatchRosterValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		roster := &data.MatchRoster{}
+		err := json.NewDecoder(request.Body).Decode(roster)
+		if err != nil {
+			log.Error(err, "Error deserializing match roster")
+			http.Error(responseWriter, "Error reading match roster", http.StatusBadRequest)
+			return
+		}
+
+		// validate the match roster
+		err = roster.ValidateMatchRoster()
+		if err != nil {
+			log.Error(err, "Error validating match roster")
+			http.Error(responseWriter, fmt.Sprintf("Error validating match roster: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Add the match roster to the context
+		ctx := context.WithValue(request.Context(), KeyMatchRoster{}, roster)
+		newRequest := request.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware or the final handler
+		next.ServeHTTP(responseWriter, newRequest)
+	})
+}
+
+// MiddlewareMatchPlayerValidation is used to validate incoming match player JSONS
+func (textChatHandler *TextChatHandler) MiddlewareMatchPlayerValidation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		player := &data.MatchPlayer{}
+		err := json.NewDecoder(request.Body).Decode(player)
+		if err != nil {
+			log.Error(err, "Error deserializing match player")
+			http.Error(responseWriter, "Error reading match player", http.StatusBadRequest)
+			return
+		}
+
+		// validate the match player
+		err = player.ValidateMatchPlayer()
+		if err != nil {
+			log.Error(err, "Error validating match player")
+			http.Error(responseWriter, fmt.Sprintf("Error validating match player: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Add the match player to the context
+		ctx := context.WithValue(request.Context(), KeyMatchPlayer{}, player)
+		newRequest := request.WithContext(ctx)
+
+		// Call the next handler, which can be another middleware or the final handler
+		next.ServeHTTP(responseWriter, newRequest)
+	})
+}
+
+// MiddlewareInternal marks the requests received on the internal router
+func (textChatHandler *TextChatHandler) MiddlewareInternal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), KeyInternal{}, true)
+		next.ServeHTTP(responseWriter, request.WithContext(ctx))
+	})
+}
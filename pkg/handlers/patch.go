@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.opentelemetry.io/otel"
+)
+
+// PatchConversation updates the metadata of a conversation
+// The update is rejected when the conversation changed since the version sent by the client
+func (textChatHandler *TextChatHandler) PatchConversation(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "patchConversation")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("PatchConversation request", "id", id)
+	patch := request.Context().Value(KeyConversationPatch{}).(*data.ConversationPatch)
+
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionRename) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil && conversation.Version != *patch.Version {
+		err = data.ErrorConversationConflict
+	}
+	var previousTitle string
+	if err == nil {
+		previousTitle = conversation.Title
+		conversation.ApplyPatch(patch)
+		err = conversation.ValidateConversation()
+		if err != nil {
+			log.Error(err, "Error validating conversation", "id", id)
+			http.Error(responseWriter, fmt.Sprintf("Error validating conversation: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = textChatHandler.db.UpdateConversation(request.Context(), conversation)
+	}
+
+	switch err {
+	case nil:
+		if conversation.Title != previousTitle {
+			textChatHandler.announceRename(request, conversation)
+		}
+		err = json.NewEncoder(responseWriter).Encode(conversation)
+		if err != nil {
+			log.Error(err, "Error serializing conversation")
+		}
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't change the conversation", "id", id)
+		http.Error(responseWriter, "Only moderators can change the conversation", http.StatusForbidden)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation version doesn't match", "id", id, "version", *patch.Version)
+		http.Error(responseWriter, "Conversation was modified, fetch it and try again", http.StatusConflict)
+		return
+	default:
+		log.Error(err, "Error updating conversation", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// announceRename posts a system message with the new title of the conversation
+func (textChatHandler *TextChatHandler) announceRename(request *http.Request, conversation *data.Conversation) {
+	text := "The conversation was renamed"
+	if conversation.Title != "" {
+		text = fmt.Sprintf("The conversation was renamed to %s", conversation.Title)
+	}
+	if userID := getRequestUserID(request); userID != "" {
+		text = fmt.Sprintf("%s by @%s", text, userID)
+	}
+
+	message := data.NewSystemMessage(conversation.ID, text)
+	err := textChatHandler.db.AddSystemMessage(request.Context(), message)
+	if err != nil {
+		log.Error(err, "Error announcing rename", "id", conversation.ID)
+		return
+	}
+	textChatHandler.messageCreated(message)
+}
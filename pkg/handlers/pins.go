@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+)
+
+// GetPinnedMessages returns the pinned messages of a conversation, in the order they were pinned
+func (textChatHandler *TextChatHandler) GetPinnedMessages(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getPinnedMessages")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("GetPinnedMessages request", "id", id)
+
+	conversation, err := textChatHandler.readableConversation(request, id)
+	switch err {
+	case nil:
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Pinned messages requested by a user that is not a member of the conversation", "id", id)
+		http.Error(responseWriter, "Only the members of the conversation can read its pinned messages", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error fetching conversation", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	messages := data.Messages{}
+	for _, messageID := range conversation.PinnedMessageID {
+		message, err := textChatHandler.db.GetMessageByID(request.Context(), messageID)
+		if err != nil {
+			// The message was deleted while it was being unpinned
+			log.Error(err, "Error fetching pinned message", "id", messageID)
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	err = json.NewEncoder(responseWriter).Encode(messages)
+	if err != nil {
+		log.Error(err, "Error serializing pinned messages")
+	}
+}
+
+// PinMessage pins a message of the conversation
+func (textChatHandler *TextChatHandler) PinMessage(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "pinMessage")
+	defer span.End()
+	id := getTextChatID(request)
+	messageID := mux.Vars(request)["messageID"]
+	log.Info("PinMessage request", "id", id, "message_id", messageID)
+
+	textChatHandler.updatePins(responseWriter, request, id, messageID, func(conversation *data.Conversation) error {
+		message, err := textChatHandler.db.GetMessageByID(request.Context(), messageID)
+		if err != nil {
+			return err
+		}
+		if message.ConversationID != conversation.ID {
+			return data.ErrorMessageNotFound
+		}
+		return conversation.Pin(messageID)
+	})
+}
+
+// UnpinMessage removes a message from the pinned messages of the conversation
+func (textChatHandler *TextChatHandler) UnpinMessage(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "unpinMessage")
+	defer span.End()
+	id := getTextChatID(request)
+	messageID := mux.Vars(request)["messageID"]
+	log.Info("UnpinMessage request", "id", id, "message_id", messageID)
+
+	textChatHandler.updatePins(responseWriter, request, id, messageID, func(conversation *data.Conversation) error {
+		if !conversation.Unpin(messageID) {
+			return data.ErrorMessageNotFound
+		}
+		return nil
+	})
+}
+
+// updatePins applies a change to the pinned messages when the user is allowed to pin messages
+func (textChatHandler *TextChatHandler) updatePins(responseWriter http.ResponseWriter, request *http.Request, id string, messageID string, change func(conversation *data.Conversation) error) {
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionPin) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		err = change(conversation)
+	}
+	if err == nil {
+		err = textChatHandler.db.UpdateConversation(request.Context(), conversation)
+	}
+
+	switch err {
+	case nil:
+		responseWriter.WriteHeader(http.StatusNoContent)
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorMessageNotFound:
+		log.Error(err, "Message not found in conversation", "id", id, "message_id", messageID)
+		http.Error(responseWriter, "Message not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't pin messages", "id", id)
+		http.Error(responseWriter, "Only moderators can pin messages", http.StatusForbidden)
+		return
+	case data.ErrorPinLimit:
+		log.Error(err, "Too many pinned messages", "id", id)
+		http.Error(responseWriter, "Too many pinned messages", http.StatusBadRequest)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation changed while updating pins", "id", id)
+		http.Error(responseWriter, "Conversation was modified, try again", http.StatusConflict)
+		return
+	default:
+		log.Error(err, "Error updating pins", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
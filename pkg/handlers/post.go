@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/Ubivius/microservice-text-chat/pkg/commands"
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/mentions"
 	"go.opentelemetry.io/otel"
 )
 
@@ -14,10 +18,75 @@ func (textChatHandler *TextChatHandler) AddMessage(responseWriter http.ResponseW
 	defer span.End()
 	log.Info("AddMessage request")
 	message := request.Context().Value(KeyMessage{}).(*data.Message)
+	message.ID = ""
 
-	err := textChatHandler.db.AddMessage(request.Context(), message)
+	// System and bot messages can only be sent through the internal router
+	if !message.IsUserMessage() || message.Sender != nil {
+		log.Error(data.ErrorMessageKind, "Non user message sent on public endpoint", "kind", message.Kind)
+		http.Error(responseWriter, "Only user messages can be sent", http.StatusBadRequest)
+		return
+	}
+
+	// Users can only send messages as themselves, so the permissions are checked against the user of the token
+	if !isInternalRequest(request) && message.UserID != getRequestUserID(request) {
+		log.Error(data.ErrorPermissionDenied, "Message sent as another user", "user_id", message.UserID)
+		http.Error(responseWriter, "Messages can only be sent as the authenticated user", http.StatusForbidden)
+		return
+	}
+
+	// Messages sent to a channel of a game are sent to the conversation of the channel visible to the user
+	err := textChatHandler.resolveChannel(request, message)
+	if err != nil {
+		log.Error(err, "Channel not found", "game_id", message.GameID, "channel", message.Channel)
+		http.Error(responseWriter, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	// Messages starting with the command prefix are interpreted by the command registry
+	if commands.IsCommand(message.Text) {
+		textChatHandler.executeCommand(responseWriter, request, message)
+		return
+	}
+	message.Text = commands.Unescape(message.Text)
+	textChatHandler.richtext.Format(message)
+
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), message.ConversationID)
+	if err == nil && !conversation.Can(message.UserID, data.PermissionSendMessage) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		setMessageChannel(message, conversation)
+		err = textChatHandler.mentions.Resolve(request.Context(), message, conversation)
+	}
+	if err == nil {
+		err = textChatHandler.attachments.Resolve(request.Context(), message)
+	}
+
+	// Messages with a future send time are sent later by the dispatcher with the ID of the scheduled message
+	var scheduled *data.ScheduledMessage
+	if err == nil && message.IsScheduled(time.Now()) {
+		scheduled = data.NewScheduledMessage(message)
+		err = textChatHandler.db.AddScheduledMessage(request.Context(), scheduled)
+	} else if err == nil {
+		err = textChatHandler.db.AddMessage(request.Context(), message)
+	}
+	if err == nil {
+		err = textChatHandler.attachments.Link(request.Context(), message)
+		if err != nil {
+			textChatHandler.discardMessage(request.Context(), message, scheduled)
+		}
+	}
 	switch err {
 	case nil:
+		if scheduled != nil {
+			responseWriter.WriteHeader(http.StatusAccepted)
+			err = json.NewEncoder(responseWriter).Encode(scheduled)
+			if err != nil {
+				log.Error(err, "Error serializing scheduled message")
+			}
+			return
+		}
+		textChatHandler.messageCreated(message)
 		responseWriter.WriteHeader(http.StatusNoContent)
 		return
 	case data.ErrorConversationNotFound:
@@ -28,6 +97,30 @@ func (textChatHandler *TextChatHandler) AddMessage(responseWriter http.ResponseW
 		log.Error(err, "UserID doesn't exist")
 		http.Error(responseWriter, "UserID doesn't exist", http.StatusBadRequest)
 		return
+	case data.ErrorConversationArchived:
+		log.Error(err, "Conversation is archived")
+		http.Error(responseWriter, "Conversation is archived and read-only", http.StatusForbidden)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't send messages in the conversation")
+		http.Error(responseWriter, "User can't send messages in this conversation", http.StatusForbidden)
+		return
+	case data.ErrorUserMuted:
+		log.Error(err, "User is muted")
+		http.Error(responseWriter, "User is muted in this conversation", http.StatusForbidden)
+		return
+	case mentions.ErrorMentionNotAllowed:
+		log.Error(err, "Mention not allowed")
+		http.Error(responseWriter, "Only moderators can mention everyone or the team", http.StatusForbidden)
+		return
+	case data.ErrorAttachmentNotFound, data.ErrorAttachmentUnavailable:
+		log.Error(err, "Attachment can't be used")
+		http.Error(responseWriter, "Attachment not found or already used", http.StatusBadRequest)
+		return
+	case data.ErrorAttachmentLinked:
+		log.Error(err, "Attachment claimed by another message")
+		http.Error(responseWriter, "Attachment already used in another message", http.StatusConflict)
+		return
 	default:
 		log.Error(err, "Error adding message")
 		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
@@ -35,6 +128,70 @@ func (textChatHandler *TextChatHandler) AddMessage(responseWriter http.ResponseW
 	}
 }
 
+// discardMessage removes a stored message whose attachments were claimed by another message in the meantime
+// The attachments the message already claimed are deleted with it
+func (textChatHandler *TextChatHandler) discardMessage(ctx context.Context, message *data.Message, scheduled *data.ScheduledMessage) {
+	var err error
+	if scheduled != nil {
+		err = textChatHandler.db.CancelScheduledMessage(ctx, scheduled.ID)
+	} else {
+		err = textChatHandler.db.DeleteMessage(ctx, message.ID)
+	}
+	if err == nil {
+		err = textChatHandler.attachments.CleanupMessage(ctx, message.ID)
+	}
+	if err != nil {
+		log.Error(err, "Error discarding message", "messageID", message.ID)
+	}
+}
+
+// AddSystemMessage creates a new system or bot message from the received JSON
+// The sender is a service identity, so it is not validated against the user service
+func (textChatHandler *TextChatHandler) AddSystemMessage(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "addSystemMessage")
+	defer span.End()
+	log.Info("AddSystemMessage request")
+	message := request.Context().Value(KeyMessage{}).(*data.Message)
+	textChatHandler.richtext.Format(message)
+
+	err := textChatHandler.resolveChannel(request, message)
+	if err == nil {
+		var conversation *data.Conversation
+		conversation, err = textChatHandler.db.GetConversationByID(request.Context(), message.ConversationID)
+		if err == nil {
+			setMessageChannel(message, conversation)
+		}
+	}
+	if err == nil {
+		err = textChatHandler.db.AddSystemMessage(request.Context(), message)
+	}
+	switch err {
+	case nil:
+		textChatHandler.messageCreated(message)
+		err = json.NewEncoder(responseWriter).Encode(message)
+		if err != nil {
+			log.Error(err, "Error serializing message")
+		}
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found")
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorChannelNotFound:
+		log.Error(err, "Channel not found")
+		http.Error(responseWriter, "Channel not found", http.StatusNotFound)
+		return
+	case data.ErrorConversationArchived:
+		log.Error(err, "Conversation is archived")
+		http.Error(responseWriter, "Conversation is archived and read-only", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error adding system message")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // AddConversation creates a new message from the received JSON
 func (textChatHandler *TextChatHandler) AddConversation(responseWriter http.ResponseWriter, request *http.Request) {
 	_, span := otel.Tracer("text-chat").Start(request.Context(), "addConversation")
@@ -42,6 +199,21 @@ func (textChatHandler *TextChatHandler) AddConversation(responseWriter http.Resp
 	log.Info("AddConversation request")
 	conversation := request.Context().Value(KeyConversation{}).(*data.Conversation)
 
+	// Game conversations are created by the game server through the internal router
+	if conversation.IsGameBound() && !isInternalRequest(request) {
+		log.Error(data.ErrorConversationType, "Game conversation created on public endpoint", "type", conversation.Type)
+		http.Error(responseWriter, "Game conversations are managed by the game server", http.StatusForbidden)
+		return
+	}
+
+	// The user creating a group conversation is its owner, roles and IDs are only set by the game server
+	if !isInternalRequest(request) {
+		conversation.ID = ""
+	}
+	if !isInternalRequest(request) || conversation.Roles == nil {
+		conversation.InitRoles(getRequestUserID(request))
+	}
+
 	conversation, err := textChatHandler.db.AddConversation(request.Context(), conversation)
 
 	switch err {
@@ -59,9 +231,65 @@ func (textChatHandler *TextChatHandler) AddConversation(responseWriter http.Resp
 		log.Error(err, "GameID doesn't exist")
 		http.Error(responseWriter, "GameID doesn't exist", http.StatusBadRequest)
 		return
+	case data.ErrorConversationExists:
+		log.Error(err, "Conversation already exists")
+		http.Error(responseWriter, "Conversation already exists", http.StatusConflict)
+		return
 	default:
 		log.Error(err, "Error adding conversation")
 		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
+
+// UploadAttachment stores the file of a multipart form and returns the attachment to reference in a message
+// The uploader is the user making the request
+func (textChatHandler *TextChatHandler) UploadAttachment(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "uploadAttachment")
+	defer span.End()
+	log.Info("UploadAttachment request")
+
+	// The multipart envelope is allowed on top of the maximum attachment size
+	request.Body = http.MaxBytesReader(responseWriter, request.Body, data.MaxAttachmentSize+(1<<20))
+	file, header, err := request.FormFile("file")
+	if err != nil {
+		log.Error(err, "Error reading attachment")
+		http.Error(responseWriter, "Error reading attachment", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	userID := getRequestUserID(request)
+	if userID == "" {
+		log.Error(data.ErrorUserNotFound, "Attachment uploaded without user identity")
+		http.Error(responseWriter, "Missing user identity", http.StatusUnauthorized)
+		return
+	}
+	if header.Size > data.MaxAttachmentSize {
+		log.Error(data.ErrorAttachmentTooLarge, "Attachment too large", "size", header.Size)
+		http.Error(responseWriter, "Attachment too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	attachment, err := textChatHandler.attachments.Upload(request.Context(), userID, header.Filename, file)
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(attachment)
+		if err != nil {
+			log.Error(err, "Error serializing attachment")
+		}
+		return
+	case data.ErrorAttachmentTooLarge:
+		log.Error(err, "Attachment too large")
+		http.Error(responseWriter, "Attachment too large", http.StatusRequestEntityTooLarge)
+		return
+	case data.ErrorAttachmentType:
+		log.Error(err, "Attachment type not allowed")
+		http.Error(responseWriter, "Attachment type not allowed", http.StatusUnsupportedMediaType)
+		return
+	default:
+		log.Error(err, "Error uploading attachment")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/Ubivius/microservice-text-chat/pkg/data"
@@ -14,12 +15,50 @@ func (textChatHandler *TextChatHandler) AddUserToConversation(responseWriter htt
 	log.Info("Add User to Conversation request")
 	conversation := request.Context().Value(KeyConversation{}).(*data.Conversation)
 
-	err := textChatHandler.db.AddUserToConversation(request.Context(), conversation)
+	// The members of game conversations are managed by the game server through the internal router
+	existing, err := textChatHandler.db.GetConversationByID(request.Context(), conversation.ID)
+	if err == nil && !isInternalRequest(request) {
+		if existing.IsGameBound() {
+			log.Error(data.ErrorConversationType, "Game conversation members changed on public endpoint", "id", conversation.ID)
+			http.Error(responseWriter, "Game conversations are managed by the game server", http.StatusForbidden)
+			return
+		}
+		// The members of a direct conversation can't be changed, whatever the permissions of the user
+		err = existing.ValidateMemberChange(conversation.UserID)
+		if err == nil {
+			err = checkMemberChanges(getRequestUserID(request), existing, conversation.UserID)
+		}
+	}
+	if err == nil {
+		// The change only applies to the conversation the permissions were checked against
+		conversation.Version = existing.Version
+		err = textChatHandler.db.AddUserToConversation(request.Context(), conversation)
+	}
 
 	switch err {
 	case nil:
 		responseWriter.WriteHeader(http.StatusNoContent)
 		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't change the members of the conversation")
+		http.Error(responseWriter, "Permission denied", http.StatusForbidden)
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found")
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation changed while its members were changed", "id", conversation.ID)
+		http.Error(responseWriter, "Conversation was modified, fetch it and try again", http.StatusConflict)
+		return
+	case data.ErrorConversationType:
+		log.Error(err, "Members can't be changed for this conversation type")
+		http.Error(responseWriter, "Members can't be changed for this conversation type", http.StatusBadRequest)
+		return
+	case data.ErrorConversationFull:
+		log.Error(err, "Conversation is full")
+		http.Error(responseWriter, "Conversation is full", http.StatusBadRequest)
+		return
 	case data.ErrorUserNotFound:
 		log.Error(err, "A UserID doesn't exist")
 		http.Error(responseWriter, "A UserID doesn't exist", http.StatusBadRequest)
@@ -34,3 +73,103 @@ func (textChatHandler *TextChatHandler) AddUserToConversation(responseWriter htt
 		return
 	}
 }
+
+// checkMemberChanges checks that the user is allowed to add and remove the changed members
+// Every member can leave the conversation, but the owner can only be removed by leaving
+func checkMemberChanges(userID string, existing *data.Conversation, userIDs []string) error {
+	updated := &data.Conversation{UserID: userIDs}
+	for _, memberID := range userIDs {
+		if !existing.HasUser(memberID) && !existing.Can(userID, data.PermissionAddMember) {
+			return data.ErrorPermissionDenied
+		}
+	}
+	for _, memberID := range existing.UserID {
+		if updated.HasUser(memberID) || memberID == userID {
+			continue
+		}
+		if !existing.Can(userID, data.PermissionRemoveMember) || existing.RoleOf(memberID) == data.RoleOwner {
+			return data.ErrorPermissionDenied
+		}
+	}
+	return nil
+}
+
+// SetMemberRole changes the role of a member of the conversation
+func (textChatHandler *TextChatHandler) SetMemberRole(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "setMemberRole")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("Set member role request", "id", id)
+	roleChange := request.Context().Value(KeyRoleChange{}).(*data.RoleChange)
+
+	textChatHandler.updateRoles(responseWriter, request, id, func(conversation *data.Conversation) error {
+		return conversation.SetRole(roleChange.UserID, roleChange.Role)
+	})
+}
+
+// TransferOwnership makes a member the owner of the conversation
+func (textChatHandler *TextChatHandler) TransferOwnership(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "transferOwnership")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("Transfer ownership request", "id", id)
+	roleChange := request.Context().Value(KeyRoleChange{}).(*data.RoleChange)
+
+	textChatHandler.updateRoles(responseWriter, request, id, func(conversation *data.Conversation) error {
+		return conversation.TransferOwnership(roleChange.UserID)
+	})
+}
+
+// updateRoles applies a change to the roles of a conversation when the user is allowed to manage them
+func (textChatHandler *TextChatHandler) updateRoles(responseWriter http.ResponseWriter, request *http.Request, id string, change func(conversation *data.Conversation) error) {
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !hasPermission(request, conversation, data.PermissionManageRoles) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil && conversation.ConversationType() != data.ConversationTypeGroup && !isInternalRequest(request) {
+		err = data.ErrorConversationType
+	}
+	if err == nil {
+		err = change(conversation)
+	}
+	if err == nil {
+		err = textChatHandler.db.UpdateConversation(request.Context(), conversation)
+	}
+
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(conversation)
+		if err != nil {
+			log.Error(err, "Error serializing conversation")
+		}
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't manage the roles of the conversation", "id", id)
+		http.Error(responseWriter, "Only the owner can manage roles", http.StatusForbidden)
+		return
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found", "id", id)
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorConversationType:
+		log.Error(err, "Roles can't be changed for this conversation type", "id", id)
+		http.Error(responseWriter, "Roles can't be changed for this conversation type", http.StatusBadRequest)
+		return
+	case data.ErrorUserNotFound:
+		log.Error(err, "User is not a member of the conversation", "id", id)
+		http.Error(responseWriter, "User is not a member of the conversation", http.StatusBadRequest)
+		return
+	case data.ErrorConversationConflict:
+		log.Error(err, "Conversation changed while updating roles", "id", id)
+		http.Error(responseWriter, "Conversation was modified, try again", http.StatusConflict)
+		return
+	case data.ErrorInvalidRole:
+		log.Error(err, "Invalid role", "id", id)
+		http.Error(responseWriter, "Invalid role, use the owner endpoint to transfer ownership", http.StatusBadRequest)
+		return
+	default:
+		log.Error(err, "Error updating roles", "id", id)
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+	"go.opentelemetry.io/otel"
+)
+
+// GetRetentionReport returns the messages the retention policy would remove now, without removing them
+func (textChatHandler *TextChatHandler) GetRetentionReport(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getRetentionReport")
+	defer span.End()
+	log.Info("GetRetentionReport request")
+
+	report, err := retention.NewWorker(textChatHandler.db, nil, textChatHandler.retention, true).Run(request.Context(), time.Now())
+	if err != nil {
+		log.Error(err, "Error running retention dry-run")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = json.NewEncoder(responseWriter).Encode(report)
+	if err != nil {
+		log.Error(err, "Error serializing retention report")
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"go.opentelemetry.io/otel"
+)
+
+// GetScheduledMessagesByUserID returns the pending scheduled messages of a user, by send time
+// Users can only list their own scheduled messages
+func (textChatHandler *TextChatHandler) GetScheduledMessagesByUserID(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "getScheduledMessagesByUserId")
+	defer span.End()
+	id := getTextChatID(request)
+
+	log.Info("GetScheduledMessagesByUserID request for userID", "id", id)
+
+	if !isInternalRequest(request) && id != getRequestUserID(request) {
+		log.Error(data.ErrorPermissionDenied, "Scheduled messages requested for another user", "id", id)
+		http.Error(responseWriter, "Users can only list their own scheduled messages", http.StatusForbidden)
+		return
+	}
+
+	scheduledMessages, err := textChatHandler.db.GetScheduledMessagesByUserID(request.Context(), id)
+	switch err {
+	case nil:
+		err = json.NewEncoder(responseWriter).Encode(scheduledMessages)
+		if err != nil {
+			log.Error(err, "Error serializing scheduled messages")
+		}
+		return
+	default:
+		log.Error(err, "Error fetching scheduled messages")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelScheduledMessage cancels a pending scheduled message, only its author can cancel it
+func (textChatHandler *TextChatHandler) CancelScheduledMessage(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "cancelScheduledMessage")
+	defer span.End()
+	id := getTextChatID(request)
+	log.Info("CancelScheduledMessage request", "id", id)
+
+	scheduled, err := textChatHandler.db.GetScheduledMessageByID(request.Context(), id)
+	if err == nil && !isInternalRequest(request) && scheduled.UserID != getRequestUserID(request) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		err = textChatHandler.db.CancelScheduledMessage(request.Context(), id)
+	}
+
+	switch err {
+	case nil:
+		// The attachments of the message were linked when it was scheduled
+		err = textChatHandler.attachments.CleanupMessage(request.Context(), id)
+		if err != nil {
+			log.Error(err, "Error deleting attachments of scheduled message", "id", id)
+		}
+		responseWriter.WriteHeader(http.StatusNoContent)
+		return
+	case data.ErrorScheduledMessageNotFound:
+		log.Error(err, "Scheduled message not found or already sent")
+		http.Error(responseWriter, "Scheduled message not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "User can't cancel the scheduled message")
+		http.Error(responseWriter, "Only the author can cancel a scheduled message", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error cancelling scheduled message")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
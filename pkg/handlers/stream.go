@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+	"go.opentelemetry.io/otel"
+)
+
+// keepAliveInterval is the interval of the comments sent to keep idle streams open
+const keepAliveInterval = 30 * time.Second
+
+// StreamConversationEvents streams the events of a conversation to the client as server-sent events
+// Only the members of the conversation can stream it, the events are delayed for the spectators of the conversation
+func (textChatHandler *TextChatHandler) StreamConversationEvents(responseWriter http.ResponseWriter, request *http.Request) {
+	_, span := otel.Tracer("text-chat").Start(request.Context(), "streamConversationEvents")
+	defer span.End()
+	id := getTextChatID(request)
+
+	log.Info("StreamConversationEvents request for conversationID", "id", id)
+
+	conversation, err := textChatHandler.readableConversation(request, id)
+	switch err {
+	case nil:
+	case data.ErrorConversationNotFound:
+		log.Error(err, "Conversation not found")
+		http.Error(responseWriter, "Conversation not found", http.StatusNotFound)
+		return
+	case data.ErrorPermissionDenied:
+		log.Error(err, "Events requested by a user that is not a member of the conversation", "id", id)
+		http.Error(responseWriter, "Only the members of the conversation can stream its events", http.StatusForbidden)
+		return
+	default:
+		log.Error(err, "Error fetching conversation")
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	channel, unsubscribe := textChatHandler.events.Subscribe(id)
+	defer unsubscribe()
+	writeEventStream(responseWriter, request, textChatHandler.delayEvents(request, channel, conversation))
+}
+
+// writeEventStream writes the events of the channel to the client until the client disconnects
+func writeEventStream(responseWriter http.ResponseWriter, request *http.Request, channel <-chan events.Event) {
+	flusher, ok := responseWriter.(http.Flusher)
+	if !ok {
+		http.Error(responseWriter, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	responseWriter.Header().Set("Content-Type", "text/event-stream")
+	responseWriter.Header().Set("Cache-Control", "no-cache")
+	responseWriter.Header().Set("Connection", "keep-alive")
+	responseWriter.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	var err error
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(responseWriter, ": keep-alive\n\n")
+		case event, open := <-channel:
+			if !open {
+				return
+			}
+			var payload []byte
+			payload, err = json.Marshal(event)
+			if err != nil {
+				log.Error(err, "Error serializing event")
+				continue
+			}
+			_, err = fmt.Fprintf(responseWriter, "event: %s\ndata: %s\n\n", event.Type, payload)
+		}
+		if err != nil {
+			log.Error(err, "Error writing event stream")
+			return
+		}
+		flusher.Flush()
+	}
+}
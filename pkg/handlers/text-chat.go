@@ -2,8 +2,20 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/commands"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
 	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/erasure"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+	"github.com/Ubivius/microservice-text-chat/pkg/importer"
+	"github.com/Ubivius/microservice-text-chat/pkg/matches"
+	"github.com/Ubivius/microservice-text-chat/pkg/mentions"
+	"github.com/Ubivius/microservice-text-chat/pkg/retention"
+	"github.com/Ubivius/microservice-text-chat/pkg/richtext"
+	"github.com/Ubivius/microservice-text-chat/pkg/unfurl"
 	"github.com/gorilla/mux"
 )
 
@@ -13,12 +25,136 @@ type KeyMessage struct{}
 // KeyConversation is a key used for the Conversation object inside context
 type KeyConversation struct{}
 
+// KeyConversationPatch is a key used for the ConversationPatch object inside context
+type KeyConversationPatch struct{}
+
+// KeyMatchRoster is a key used for the MatchRoster object inside context
+type KeyMatchRoster struct{}
+
+// KeyMatchPlayer is a key used for the MatchPlayer object inside context
+type KeyMatchPlayer struct{}
+
+// KeyRoleChange is a key used for the RoleChange object inside context
+type KeyRoleChange struct{}
+
+// KeyInternal is a key used inside context to mark requests received on the internal router
+type KeyInternal struct{}
+
+// KeyConn is a key used for the connection of a request inside context
+type KeyConn struct{}
+
 type TextChatHandler struct {
-	db database.TextChatDB
+	db          database.TextChatDB
+	commands    *commands.Registry
+	mentions    *mentions.Resolver
+	users       mentions.UserDirectory
+	richtext    *richtext.Parser
+	attachments *attachments.Service
+	blobStore   attachments.BlobStore
+	events      *events.Broker
+	unfurler    *unfurl.Worker
+	matches     *matches.Service
+	eraser      *erasure.Eraser
+	// spectatorDelay is the delay of the messages seen by the spectators of a conversation
+	spectatorDelay time.Duration
+	retention      retention.Policy
+	erasurePolicy  string
+	importTimeout  time.Duration
+	// userServiceURL is the base URL of microservice-user, checked by the readiness probe
+	userServiceURL string
+}
+
+// Option configures optional dependencies of the TextChatHandler
+type Option func(textChatHandler *TextChatHandler)
+
+// WithBlobStore sets the blob store used for the content of the attachments
+func WithBlobStore(store attachments.BlobStore) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.blobStore = store
+	}
+}
+
+// WithEventBroker sets the broker delivering the events of the conversations to the clients
+func WithEventBroker(broker *events.Broker) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.events = broker
+	}
+}
+
+// WithUnfurlWorker sets the worker creating the link previews of the new messages
+func WithUnfurlWorker(worker *unfurl.Worker) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.unfurler = worker
+	}
+}
+
+// WithUserDirectory sets the directory of the usernames, used for the mentions and the authors of the transcripts
+func WithUserDirectory(directory mentions.UserDirectory) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.users = directory
+	}
+}
+
+// WithUserServiceURL sets the base URL of microservice-user, checked by the readiness probe and used for the usernames
+func WithUserServiceURL(url string) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.userServiceURL = url
+	}
+}
+
+// WithSpectatorDelay sets the delay of the messages and events seen by spectators, 0 disables the delay
+func WithSpectatorDelay(delay time.Duration) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.spectatorDelay = delay
+	}
 }
 
-func NewTextChatHandler(db database.TextChatDB) *TextChatHandler {
-	return &TextChatHandler{db}
+// WithRetentionPolicy sets the retention policy reported by the retention dry-run
+func WithRetentionPolicy(policy retention.Policy) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.retention = policy
+	}
+}
+
+// WithErasurePolicy sets the policy applied to the messages of an erased user when the erasure doesn't specify one
+func WithErasurePolicy(policy string) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.erasurePolicy = policy
+	}
+}
+
+// WithImportTimeout sets the time given to an import request to send its body
+func WithImportTimeout(timeout time.Duration) Option {
+	return func(textChatHandler *TextChatHandler) {
+		textChatHandler.importTimeout = timeout
+	}
+}
+
+func NewTextChatHandler(db database.TextChatDB, options ...Option) *TextChatHandler {
+	textChatHandler := &TextChatHandler{
+		db:        db,
+		commands:  commands.NewDefaultRegistry(db),
+		richtext:  richtext.NewDefaultParser(),
+		blobStore: attachments.NewLocalBlobStore(""),
+		events:    events.NewBroker(),
+		matches:   matches.NewService(db),
+
+		spectatorDelay: data.DefaultSpectatorDelay,
+		retention:      retention.DefaultPolicy,
+		erasurePolicy:  erasure.DefaultPolicy,
+		importTimeout:  importer.DefaultTimeout,
+	}
+	for _, option := range options {
+		option(textChatHandler)
+	}
+	// The usernames are fetched from microservice-user unless another directory is set
+	if textChatHandler.users == nil {
+		textChatHandler.users = mentions.NewHTTPUserDirectory(textChatHandler.userServiceURL)
+	}
+	textChatHandler.mentions = mentions.NewResolver(textChatHandler.users)
+	textChatHandler.attachments = attachments.NewService(db, textChatHandler.blobStore)
+	textChatHandler.eraser = erasure.NewEraser(db, textChatHandler.attachments, textChatHandler.erasurePolicy)
+	return textChatHandler
 }
 
 // getTextChatID extracts the conversation/message ID from the URL
@@ -29,3 +165,57 @@ func getTextChatID(request *http.Request) string {
 
 	return id
 }
+
+// messageCreated notifies the connected clients of a new message and schedules its link previews
+func (textChatHandler *TextChatHandler) messageCreated(message *data.Message) {
+	textChatHandler.events.PublishMessage(events.EventMessageCreated, message)
+	if textChatHandler.unfurler != nil {
+		textChatHandler.unfurler.Enqueue(message)
+	}
+}
+
+// isInternalRequest returns true when the request was received on the internal router
+func isInternalRequest(request *http.Request) bool {
+	internal, _ := request.Context().Value(KeyInternal{}).(bool)
+	return internal
+}
+
+// readableConversation returns the conversation when the user making the request can read it
+func (textChatHandler *TextChatHandler) readableConversation(request *http.Request, id string) (*data.Conversation, error) {
+	conversation, err := textChatHandler.db.GetConversationByID(request.Context(), id)
+	if err == nil && !canRead(request, conversation) {
+		err = data.ErrorPermissionDenied
+	}
+	return conversation, err
+}
+
+// visibleMessages removes the messages of the last spectator delay when the user making the request is a spectator of the conversation
+func (textChatHandler *TextChatHandler) visibleMessages(request *http.Request, conversation *data.Conversation, messages data.Messages) data.Messages {
+	if textChatHandler.spectatorDelay <= 0 || conversation.RoleOf(getRequestUserID(request)) != data.RoleSpectator {
+		return messages
+	}
+	return messages.CreatedBefore(time.Now().Add(-textChatHandler.spectatorDelay))
+}
+
+// spectatorConversations returns the IDs of the conversations where the user making the request is a spectator
+func spectatorConversations(request *http.Request, conversations ...*data.Conversation) map[string]bool {
+	userID := getRequestUserID(request)
+	spectating := map[string]bool{}
+	for _, conversation := range conversations {
+		if userID != "" && conversation.RoleOf(userID) == data.RoleSpectator {
+			spectating[conversation.ID] = true
+		}
+	}
+	return spectating
+}
+
+// delayEvents delays the events of the conversations where the user making the request is a spectator
+func (textChatHandler *TextChatHandler) delayEvents(request *http.Request, channel <-chan events.Event, conversations ...*data.Conversation) <-chan events.Event {
+	spectating := spectatorConversations(request, conversations...)
+	if len(spectating) == 0 || textChatHandler.spectatorDelay <= 0 {
+		return channel
+	}
+	return events.Delay(request.Context().Done(), channel, textChatHandler.spectatorDelay, func(event events.Event) bool {
+		return spectating[event.ConversationID]
+	})
+}
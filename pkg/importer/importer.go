@@ -0,0 +1,243 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// Errors of the records that can't be imported
+var (
+	ErrorRecordType      = fmt.Errorf("record must hold a conversation or a message")
+	ErrorRecordID        = fmt.Errorf("record has no id")
+	ErrorRecordTimestamp = fmt.Errorf("created_on is required and timestamps must be RFC3339")
+	ErrorAttachments     = fmt.Errorf("attachments can't be imported")
+	ErrorAuthorNotMember = fmt.Errorf("author is not a member of the conversation")
+)
+
+// Record types of an import
+const (
+	RecordConversation = "conversation"
+	RecordMessage      = "message"
+)
+
+// DefaultTimeout is the time given to an import request to send its body
+const DefaultTimeout = 10 * time.Minute
+
+// maxRecordSize is the size of the longest line of an import
+const maxRecordSize = 1 << 20
+
+// maxReportedErrors is the number of record errors kept in the report, the following ones are only counted
+const maxReportedErrors = 1000
+
+// Record is a line of an import, it holds a conversation or a message with its original ID and timestamps
+// The conversation of a message must exist or be imported on a previous line
+type Record struct {
+	Type         string             `json:"type"`
+	Conversation *data.Conversation `json:"conversation,omitempty"`
+	Message      *data.Message      `json:"message,omitempty"`
+}
+
+// RecordError is a record that was not imported
+type RecordError struct {
+	Line  int    `json:"line"`
+	Type  string `json:"type,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report is the result of an import
+// An import stopped by an error is resumed from ResumeFrom, records already imported are skipped so an import can be run again safely
+type Report struct {
+	Conversations int64         `json:"conversations"`
+	Messages      int64         `json:"messages"`
+	Skipped       int64         `json:"skipped"`
+	Failed        int64         `json:"failed"`
+	Errors        []RecordError `json:"errors"`
+	ResumeFrom    int           `json:"resume_from"`
+	Completed     bool          `json:"completed"`
+}
+
+// Importer imports conversations and messages from NDJSON, one record per line
+type Importer struct {
+	db database.TextChatDB
+}
+
+// NewImporter creates an importer storing the records in the database
+func NewImporter(db database.TextChatDB) *Importer {
+	return &Importer{db: db}
+}
+
+// Import stores the records of the input starting at the line resumeFrom, the first line is line 1
+// Invalid records are reported and skipped, the import stops on a database error or when the context is cancelled
+func (importer *Importer) Import(ctx context.Context, input io.Reader, resumeFrom int) (*Report, error) {
+	if resumeFrom < 1 {
+		resumeFrom = 1
+	}
+	report := &Report{Errors: []RecordError{}, ResumeFrom: resumeFrom}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line < resumeFrom || len(scanner.Bytes()) == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		default:
+		}
+
+		err := importer.importLine(ctx, scanner.Bytes(), line, report)
+		if err != nil {
+			log.Error(err, "Import stopped", "line", line)
+			return report, err
+		}
+		report.ResumeFrom = line + 1
+	}
+	err := scanner.Err()
+	if err != nil {
+		log.Error(err, "Error reading import", "line", line+1)
+		return report, err
+	}
+
+	report.Completed = true
+	log.Info("Import completed", "conversations", report.Conversations, "messages", report.Messages, "skipped", report.Skipped, "failed", report.Failed)
+	return report, nil
+}
+
+// importLine imports the record of a line, an invalid record is added to the report and only database errors are returned
+func (importer *Importer) importLine(ctx context.Context, line []byte, number int, report *Report) error {
+	record := &Record{}
+	err := json.Unmarshal(line, record)
+	if err == data.ErrorTimestamp {
+		err = ErrorRecordTimestamp
+	}
+	if err != nil {
+		report.fail(RecordError{Line: number, Error: err.Error()})
+		return nil
+	}
+
+	var id string
+	switch {
+	case record.Type == RecordConversation && record.Conversation != nil:
+		id = record.Conversation.ID
+		err = importer.importConversation(ctx, record.Conversation)
+		if err == nil {
+			report.Conversations++
+		}
+	case record.Type == RecordMessage && record.Message != nil:
+		id = record.Message.ID
+		err = importer.importMessage(ctx, record.Message)
+		if err == nil {
+			report.Messages++
+		}
+	default:
+		err = ErrorRecordType
+	}
+
+	switch err {
+	case nil:
+		return nil
+	case data.ErrorConversationExists, data.ErrorMessageExists:
+		// The record was imported by a previous run
+		report.Skipped++
+		return nil
+	}
+	if _, invalid := err.(recordError); invalid || isDomainError(err) {
+		report.fail(RecordError{Line: number, Type: record.Type, ID: id, Error: err.Error()})
+		return nil
+	}
+	return err
+}
+
+// importConversation validates and stores a conversation with its original timestamps
+func (importer *Importer) importConversation(ctx context.Context, conversation *data.Conversation) error {
+	if conversation.ID == "" {
+		return ErrorRecordID
+	}
+	err := conversation.ValidateConversation()
+	if err != nil {
+		return recordError{err}
+	}
+	if conversation.CreatedOn.IsZero() {
+		return ErrorRecordTimestamp
+	}
+	if conversation.UpdatedOn.IsZero() {
+		conversation.UpdatedOn = conversation.CreatedOn
+	}
+	if conversation.Archived && conversation.ArchivedOn == nil {
+		archivedOn := conversation.UpdatedOn
+		conversation.ArchivedOn = &archivedOn
+	}
+	// The last message is set by the imported messages
+	conversation.LastMessageOn = nil
+	conversation.DirectKey = ""
+	conversation.Version = 0
+	return importer.db.ImportConversation(ctx, conversation)
+}
+
+// importMessage validates and stores a message with its original timestamps
+func (importer *Importer) importMessage(ctx context.Context, message *data.Message) error {
+	if message.ID == "" {
+		return ErrorRecordID
+	}
+	if len(message.Attachments) > 0 {
+		return ErrorAttachments
+	}
+	err := message.ValidateMessage()
+	if err != nil {
+		return recordError{err}
+	}
+	if message.CreatedOn.IsZero() {
+		return ErrorRecordTimestamp
+	}
+	if message.UpdatedOn.IsZero() {
+		message.UpdatedOn = message.CreatedOn
+	}
+	// Messages of service accounts and erased users have no member as author
+	if message.Sender == nil && message.UserID != data.ErasedUserID {
+		conversation, err := importer.db.GetConversationByID(ctx, message.ConversationID)
+		if err != nil {
+			return err
+		}
+		if !conversation.HasUser(message.UserID) {
+			return ErrorAuthorNotMember
+		}
+	}
+	message.SetRenderMetadata()
+	return importer.db.ImportMessage(ctx, message)
+}
+
+// fail adds a record error to the report
+func (report *Report) fail(recordError RecordError) {
+	report.Failed++
+	if len(report.Errors) < maxReportedErrors {
+		report.Errors = append(report.Errors, recordError)
+	}
+}
+
+// recordError is a validation error of a record
+type recordError struct {
+	error
+}
+
+// isDomainError returns true when the error is caused by the content of the record instead of the database
+func isDomainError(err error) bool {
+	switch err {
+	case ErrorRecordType, ErrorRecordID, ErrorRecordTimestamp, ErrorAttachments, ErrorAuthorNotMember,
+		data.ErrorConversationNotFound, data.ErrorDirectConversationExists, data.ErrorConversationType,
+		data.ErrorConversationFull, data.ErrorGameNotFound, data.ErrorInvalidRole:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+const records = `{"type":"conversation","conversation":{"id":"import-lobby","type":"lobby","user_id":["import-a","import-b"],"game_id":"import-game","created_on":"2020-05-01T18:00:00Z"}}
+{"type":"message","message":{"id":"import-m1","user_id":"import-a","conversation_id":"import-lobby","text":"glhf","created_on":"2020-05-01T18:01:00-04:00"}}
+
+{"type":"message","message":{"id":"import-m2","user_id":"import-b","conversation_id":"import-unknown","text":"orphan","created_on":"2020-05-01T18:02:00Z"}}
+{"type":"message","message":{"id":"import-m3","user_id":"import-b","conversation_id":"import-lobby","text":"late","created_on":"yesterday"}}
+{"type":"reaction","reaction":{}}
+not json
+{"type":"message","message":{"id":"import-m4","user_id":"import-b","conversation_id":"import-lobby","text":"gg","created_on":"2020-05-01T23:00:00Z"}}
+`
+
+func TestImport(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	report, err := NewImporter(db).Import(ctx, strings.NewReader(records), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Completed || report.Conversations != 1 || report.Messages != 2 || report.Failed != 4 || report.ResumeFrom != 9 {
+		t.Errorf("Unexpected import report %+v", report)
+	}
+	lines := []int{}
+	for _, recordError := range report.Errors {
+		lines = append(lines, recordError.Line)
+	}
+	if len(lines) != 4 || lines[0] != 4 || report.Errors[0].Error != data.ErrorConversationNotFound.Error() || report.Errors[1].Error != ErrorRecordTimestamp.Error() {
+		t.Errorf("Unexpected record errors %+v", report.Errors)
+	}
+
+	// The original IDs and timestamps are kept
+	message, err := db.GetMessageByID(ctx, "import-m1")
+	if err != nil || !message.CreatedOn.Equal(time.Date(2020, 5, 1, 22, 1, 0, 0, time.UTC)) || message.UpdatedOn != message.CreatedOn {
+		t.Errorf("Expected the imported message with its timestamp, got %+v, %v", message, err)
+	}
+	conversation, _ := db.GetConversationByID(ctx, "import-lobby")
+	if !conversation.CreatedOn.Equal(time.Date(2020, 5, 1, 18, 0, 0, 0, time.UTC)) || conversation.LastMessageOn == nil || !conversation.LastMessageOn.Equal(time.Date(2020, 5, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the imported conversation with its last message, got %+v", conversation)
+	}
+
+	// Importing again skips the records already imported
+	report, err = NewImporter(db).Import(ctx, strings.NewReader(records), 1)
+	if err != nil || report.Conversations != 0 || report.Messages != 0 || report.Skipped != 3 {
+		t.Errorf("Expected the records to be skipped, got %+v, %v", report, err)
+	}
+}
+
+// failingDB fails the import of the messages like an unavailable database
+type failingDB struct {
+	database.TextChatDB
+}
+
+func (db failingDB) ImportMessage(ctx context.Context, message *data.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestImportResume(t *testing.T) {
+	ctx := context.Background()
+	input := `{"type":"conversation","conversation":{"id":"resume-group","user_id":["resume-a"],"created_on":"2020-05-01T18:00:00Z"}}
+{"type":"message","message":{"id":"resume-m1","user_id":"resume-a","conversation_id":"resume-group","text":"hi","created_on":"2020-05-01T18:01:00Z"}}
+`
+	report, err := NewImporter(failingDB{database.NewMockTextChat()}).Import(ctx, strings.NewReader(input), 1)
+	if err == nil || report.Completed || report.ResumeFrom != 2 || report.Conversations != 1 {
+		t.Fatalf("Expected the import to stop at the message, got %+v, %v", report, err)
+	}
+
+	report, err = NewImporter(database.NewMockTextChat()).Import(ctx, strings.NewReader(input), report.ResumeFrom)
+	if err != nil || !report.Completed || report.Conversations != 0 || report.Messages != 1 {
+		t.Errorf("Expected the import to resume at the message, got %+v, %v", report, err)
+	}
+}
+
+func TestImportAuthorMembership(t *testing.T) {
+	ctx := context.Background()
+	input := `{"type":"conversation","conversation":{"id":"member-group","user_id":["member-a"],"created_on":"2020-05-01T18:00:00Z"}}
+{"type":"message","message":{"id":"member-m1","user_id":"member-stranger","conversation_id":"member-group","text":"hi","created_on":"2020-05-01T18:01:00Z"}}
+{"type":"message","message":{"id":"member-m2","user_id":"deleted-user","conversation_id":"member-group","text":"bye","created_on":"2020-05-01T18:02:00Z"}}
+{"type":"message","message":{"id":"member-m3","conversation_id":"member-group","text":"Match started","kind":"system","sender":{"id":"game-server","type":"service"},"created_on":"2020-05-01T18:03:00Z"}}
+`
+	report, err := NewImporter(database.NewMockTextChat()).Import(ctx, strings.NewReader(input), 1)
+	if err != nil || report.Messages != 2 || report.Failed != 1 {
+		t.Fatalf("Expected only the message of the stranger to fail, got %+v, %v", report, err)
+	}
+	if report.Errors[0].Line != 2 || report.Errors[0].Error != ErrorAuthorNotMember.Error() {
+		t.Errorf("Unexpected record errors %+v", report.Errors)
+	}
+}
@@ -0,0 +1,256 @@
+package matches
+
+import (
+	"context"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+// maxUpdateAttempts is the number of times an update is retried when the conversation changed concurrently
+const maxUpdateAttempts = 3
+
+// Service manages the conversations of the matches for the game server
+// Every operation is idempotent by match ID, so the game server can safely retry its requests
+type Service struct {
+	db database.TextChatDB
+}
+
+// NewService creates a match service storing the conversations in the database
+func NewService(db database.TextChatDB) *Service {
+	return &Service{db: db}
+}
+
+// Start creates the all-chat of the match and a conversation per team of the roster
+// Starting a started match sets the members of the conversations to the roster
+func (service *Service) Start(ctx context.Context, roster *data.MatchRoster) (*data.Match, error) {
+	allChat := &data.Conversation{
+		ID:     data.MatchConversationID(roster.MatchID, ""),
+		Type:   data.ConversationTypeMatch,
+		GameID: roster.MatchID,
+		UserID: roster.Players(),
+		Roles:  map[string]string{},
+	}
+	spectatorChat := &data.Conversation{
+		ID:     data.SpectatorConversationID(roster.MatchID),
+		Type:   data.ConversationTypeSpectator,
+		GameID: roster.MatchID,
+		UserID: []string{},
+	}
+	// Spectators see the all-chat with a delay but can't write to the players, they talk in the spectator chat
+	for _, userID := range roster.Spectators {
+		if !allChat.HasUser(userID) {
+			allChat.UserID = append(allChat.UserID, userID)
+			allChat.Roles[userID] = data.RoleSpectator
+			spectatorChat.UserID = append(spectatorChat.UserID, userID)
+		}
+	}
+	for _, conversation := range []*data.Conversation{allChat, spectatorChat} {
+		err := service.ensureConversation(ctx, conversation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, team := range roster.TeamNames() {
+		teamChat := &data.Conversation{
+			ID:     data.MatchConversationID(roster.MatchID, team),
+			Type:   data.ConversationTypeTeam,
+			GameID: roster.MatchID,
+			Team:   team,
+			UserID: append([]string{}, roster.Teams[team]...),
+		}
+		err := service.ensureConversation(ctx, teamChat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("Match started", "match_id", roster.MatchID, "teams", len(roster.Teams))
+	return service.Get(ctx, roster.MatchID)
+}
+
+// Get returns the conversations of a match
+func (service *Service) Get(ctx context.Context, matchID string) (*data.Match, error) {
+	conversations, err := service.db.GetConversationsByGameID(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+	match := data.NewMatch(matchID, conversations)
+	if match.AllChatID == "" {
+		return nil, data.ErrorMatchNotFound
+	}
+	return match, nil
+}
+
+// Join adds a player to the all-chat and to the conversation of its team
+// A player already in another team is moved to the new team, a spectator joins the spectator chat instead of a team
+func (service *Service) Join(ctx context.Context, matchID string, player *data.MatchPlayer) (*data.Match, error) {
+	match, err := service.Get(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+	if match.Ended {
+		return nil, data.ErrorConversationArchived
+	}
+	if _, ok := match.TeamChatID[player.Team]; !ok && !player.Spectator {
+		return nil, data.ErrorTeamNotFound
+	}
+
+	// Players and spectators are both members of the all-chat, with a different role
+	err = service.update(ctx, match.AllChatID, func(conversation *data.Conversation) bool {
+		isSpectator := conversation.RoleOf(player.UserID) == data.RoleSpectator
+		if conversation.HasUser(player.UserID) && isSpectator == player.Spectator {
+			return false
+		}
+		if !conversation.HasUser(player.UserID) {
+			conversation.SetMembers(append(conversation.UserID, player.UserID))
+		}
+		conversation.SetSpectator(player.UserID, player.Spectator)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for team, id := range match.TeamChatID {
+		err = service.setMember(ctx, id, player.UserID, !player.Spectator && team == player.Team)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if match.SpectatorChatID != "" {
+		err = service.setMember(ctx, match.SpectatorChatID, player.UserID, player.Spectator)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return match, nil
+}
+
+// SwapTeam moves a player of the match to another team
+func (service *Service) SwapTeam(ctx context.Context, matchID string, player *data.MatchPlayer) (*data.Match, error) {
+	match, err := service.Get(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+	allChat, err := service.db.GetConversationByID(ctx, match.AllChatID)
+	if err != nil {
+		return nil, err
+	}
+	if !allChat.HasUser(player.UserID) {
+		return nil, data.ErrorUserNotFound
+	}
+	return service.Join(ctx, matchID, player)
+}
+
+// Leave removes a player from every conversation of the match
+func (service *Service) Leave(ctx context.Context, matchID string, userID string) error {
+	match, err := service.Get(ctx, matchID)
+	if err != nil {
+		return err
+	}
+	for _, id := range match.ConversationIDs() {
+		err = service.setMember(ctx, id, userID, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// End archives the conversations of the match and returns their messages when a transcript is requested
+func (service *Service) End(ctx context.Context, matchID string, withTranscript bool) (*data.MatchTranscript, error) {
+	match, err := service.Get(ctx, matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &data.MatchTranscript{Match: match}
+	if withTranscript {
+		result.Transcript = map[string]data.Messages{}
+	}
+	for _, id := range match.ConversationIDs() {
+		err = service.update(ctx, id, func(conversation *data.Conversation) bool {
+			if conversation.Archived {
+				return false
+			}
+			conversation.Archive()
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if withTranscript {
+			// A conversation without messages has an empty transcript
+			messages, err := service.db.GetMessagesByConversationID(ctx, id)
+			if err != nil && err != data.ErrorMessageNotFound {
+				return nil, err
+			}
+			if messages == nil {
+				messages = data.Messages{}
+			}
+			result.Transcript[id] = messages
+		}
+	}
+
+	match.Ended = true
+	log.Info("Match ended", "match_id", matchID)
+	return result, nil
+}
+
+// ensureConversation creates the conversation, or sets the members of the existing one
+func (service *Service) ensureConversation(ctx context.Context, conversation *data.Conversation) error {
+	_, err := service.db.AddConversation(ctx, conversation)
+	if err != data.ErrorConversationExists {
+		return err
+	}
+	return service.update(ctx, conversation.ID, func(existing *data.Conversation) bool {
+		if existing.Archived {
+			return false
+		}
+		// The roster is authoritative, the roles of the members are the roles of the roster
+		existing.SetMembers(conversation.UserID)
+		existing.Roles = conversation.Roles
+		return true
+	})
+}
+
+// setMember adds or removes a member of a conversation, nothing is changed when it is already the case
+func (service *Service) setMember(ctx context.Context, id string, userID string, member bool) error {
+	return service.update(ctx, id, func(conversation *data.Conversation) bool {
+		if conversation.HasUser(userID) == member {
+			return false
+		}
+		if member {
+			conversation.SetMembers(append(conversation.UserID, userID))
+			return true
+		}
+		var members []string
+		for _, memberID := range conversation.UserID {
+			if memberID != userID {
+				members = append(members, memberID)
+			}
+		}
+		conversation.SetMembers(members)
+		return true
+	})
+}
+
+// update applies a change to a conversation and retries when the conversation changed concurrently
+// The change returns false when the conversation is already up to date
+func (service *Service) update(ctx context.Context, id string, change func(conversation *data.Conversation) bool) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		conversation, err := service.db.GetConversationByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !change(conversation) {
+			return nil
+		}
+		err = service.db.UpdateConversation(ctx, conversation)
+		if err != data.ErrorConversationConflict {
+			return err
+		}
+	}
+	return data.ErrorConversationConflict
+}
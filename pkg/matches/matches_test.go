@@ -0,0 +1,187 @@
+package matches
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+func newRoster() *data.MatchRoster {
+	return &data.MatchRoster{
+		MatchID: "match-1",
+		Teams: map[string][]string{
+			"blue": {"alice", "bob"},
+			"red":  {"carol", "dave"},
+		},
+	}
+}
+
+func members(t *testing.T, db database.TextChatDB, id string) []string {
+	conversation, err := db.GetConversationByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conversation.UserID
+}
+
+func TestStartMatchIsIdempotent(t *testing.T) {
+	db := database.NewMockTextChat()
+	service := NewService(db)
+	ctx := context.Background()
+
+	match, err := service.Start(ctx, newRoster())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(match.TeamChatID) != 2 || len(members(t, db, match.AllChatID)) != 4 {
+		t.Fatalf("Expected an all-chat with every player and 2 team conversations, got %+v", match)
+	}
+
+	// A retry of the game server returns the same conversations
+	retry, err := service.Start(ctx, newRoster())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.AllChatID != match.AllChatID || retry.TeamChatID["red"] != match.TeamChatID["red"] {
+		t.Errorf("Expected the same conversations on retry, got %+v and %+v", match, retry)
+	}
+	conversations, _ := db.GetConversationsByGameID(ctx, "match-1")
+	if len(conversations) != 4 {
+		t.Errorf("Expected 4 conversations for the match, got %d", len(conversations))
+	}
+}
+
+func TestMatchPlayers(t *testing.T) {
+	db := database.NewMockTextChat()
+	service := NewService(db)
+	ctx := context.Background()
+	roster := newRoster()
+	roster.MatchID = "match-2"
+
+	match, err := service.Start(ctx, roster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Joining twice has the same result
+	for i := 0; i < 2; i++ {
+		_, err = service.Join(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Team: "blue"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(members(t, db, match.AllChatID)) != 5 || len(members(t, db, match.TeamChatID["blue"])) != 3 {
+		t.Error("Expected the player to join the all-chat and the blue team")
+	}
+
+	_, err = service.SwapTeam(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Team: "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members(t, db, match.TeamChatID["blue"])) != 2 || len(members(t, db, match.TeamChatID["red"])) != 3 {
+		t.Error("Expected the player to move to the red team")
+	}
+
+	_, err = service.SwapTeam(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Team: "green"})
+	if err != data.ErrorTeamNotFound {
+		t.Errorf("Expected %v, got %v", data.ErrorTeamNotFound, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = service.Leave(ctx, roster.MatchID, "erin")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(members(t, db, match.AllChatID)) != 4 || len(members(t, db, match.TeamChatID["red"])) != 2 {
+		t.Error("Expected the player to leave every conversation of the match")
+	}
+}
+
+func TestMatchSpectators(t *testing.T) {
+	db := database.NewMockTextChat()
+	service := NewService(db)
+	ctx := context.Background()
+	roster := newRoster()
+	roster.MatchID = "match-4"
+
+	match, err := service.Start(ctx, roster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = service.Join(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Spectator: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allChat, _ := db.GetConversationByID(ctx, match.AllChatID)
+	if allChat.RoleOf("erin") != data.RoleSpectator || len(members(t, db, match.SpectatorChatID)) != 1 {
+		t.Error("Expected the spectator to join the all-chat as spectator and the spectator chat")
+	}
+
+	// A spectator joining a team becomes a player
+	_, err = service.Join(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Team: "red"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allChat, _ = db.GetConversationByID(ctx, match.AllChatID)
+	if allChat.RoleOf("erin") != data.RoleMember || len(members(t, db, match.SpectatorChatID)) != 0 || len(members(t, db, match.TeamChatID["red"])) != 3 {
+		t.Error("Expected the spectator to leave the spectator chat and join the red team")
+	}
+
+	// A player becomes a spectator in an all-chat without roles, as MongoDB decodes an empty map of roles
+	allChat.Roles = nil
+	err = db.UpdateConversation(ctx, allChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Join(ctx, roster.MatchID, &data.MatchPlayer{UserID: "alice", Spectator: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allChat, _ = db.GetConversationByID(ctx, match.AllChatID)
+	if allChat.RoleOf("alice") != data.RoleSpectator {
+		t.Error("Expected the player to become a spectator")
+	}
+}
+
+func TestEndMatch(t *testing.T) {
+	db := database.NewMockTextChat()
+	service := NewService(db)
+	ctx := context.Background()
+	roster := newRoster()
+	roster.MatchID = "match-3"
+
+	match, err := service.Start(ctx, roster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AddMessage(ctx, &data.Message{UserID: "alice", ConversationID: match.AllChatID, Text: "gg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := service.End(ctx, roster.MatchID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Match.Ended || len(result.Transcript[match.AllChatID]) != 1 {
+		t.Errorf("Expected the match to end with its transcript, got %+v", result)
+	}
+
+	// Ending an ended match is a no-op, but players can't join it anymore
+	_, err = service.End(ctx, roster.MatchID, false)
+	if err != nil {
+		t.Errorf("Expected ending twice to succeed, got %v", err)
+	}
+	_, err = service.Join(ctx, roster.MatchID, &data.MatchPlayer{UserID: "erin", Team: "blue"})
+	if err != data.ErrorConversationArchived {
+		t.Errorf("Expected %v, got %v", data.ErrorConversationArchived, err)
+	}
+	err = db.AddMessage(ctx, &data.Message{UserID: "alice", ConversationID: match.TeamChatID["blue"], Text: "gg"})
+	if err != data.ErrorConversationArchived {
+		t.Errorf("Expected %v, got %v", data.ErrorConversationArchived, err)
+	}
+}
@@ -0,0 +1,61 @@
+package mentions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrorUsernameNotFound : The username of a user could not be fetched
+var ErrorUsernameNotFound = fmt.Errorf("username not found")
+
+// UserDirectory gives access to the usernames of the users
+type UserDirectory interface {
+	GetUsername(ctx context.Context, userID string) (string, error)
+}
+
+// HTTPUserDirectory fetches usernames from microservice-user
+type HTTPUserDirectory struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPUserDirectory creates a user directory backed by microservice-user
+func NewHTTPUserDirectory(baseURL string) *HTTPUserDirectory {
+	return &HTTPUserDirectory{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// GetUsername returns the username of the user
+func (directory *HTTPUserDirectory) GetUsername(ctx context.Context, userID string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, directory.baseURL+"/users/"+userID, nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := directory.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", ErrorUsernameNotFound
+	}
+
+	user := struct {
+		Username string `json:"username"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&user)
+	if err != nil {
+		return "", err
+	}
+	if user.Username == "" {
+		return "", ErrorUsernameNotFound
+	}
+	return user.Username, nil
+}
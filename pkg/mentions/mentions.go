@@ -0,0 +1,156 @@
+package mentions
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// ErrorMentionNotAllowed : The user is not allowed to use a group mention
+var ErrorMentionNotAllowed = fmt.Errorf("mention not allowed")
+
+// Group mentions keywords
+const (
+	everyoneKeyword = "everyone"
+	teamKeyword     = "team"
+)
+
+// maxConcurrentLookups is the number of usernames fetched at the same time from the user directory
+const maxConcurrentLookups = 8
+
+// A mention starts with @ at the start of the text or after a character that can't be part of a word,
+// so email addresses are not parsed as mentions
+var mentionRegex = regexp.MustCompile(`(^|[^\w@])(@[\w-]+)`)
+
+// Token is a mention found in a text, before it is resolved to a user
+type Token struct {
+	Offset int
+	Length int
+	Name   string
+}
+
+// Parse returns the mention tokens of a text
+// Offsets and lengths are counted in characters (unicode code points)
+func Parse(text string) []Token {
+	var tokens []Token
+	for _, match := range mentionRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[4], match[5]
+		tokens = append(tokens, Token{
+			Offset: utf8.RuneCountInString(text[:start]),
+			Length: utf8.RuneCountInString(text[start:end]),
+			Name:   text[start+1 : end],
+		})
+	}
+	return tokens
+}
+
+// Resolver resolves the mentions of a message against the members of its conversation
+type Resolver struct {
+	directory UserDirectory
+}
+
+// NewResolver creates a mention resolver using the user directory to match usernames
+func NewResolver(directory UserDirectory) *Resolver {
+	return &Resolver{directory: directory}
+}
+
+// Resolve fills the mentions and mentioned users of the message
+// Mentions that don't match a member of the conversation are left as plain text
+func (resolver *Resolver) Resolve(ctx context.Context, message *data.Message, conversation *data.Conversation) error {
+	message.Mentions = nil
+	message.MentionedUserID = nil
+
+	tokens := Parse(message.Text)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	targets := make(map[string]bool)
+	var usernames map[string]string
+	for _, token := range tokens {
+		mention := data.Mention{Offset: token.Offset, Length: token.Length}
+		name := strings.ToLower(token.Name)
+
+		switch {
+		case name == everyoneKeyword:
+			if !conversation.Can(message.UserID, data.PermissionModerate) {
+				return ErrorMentionNotAllowed
+			}
+			mention.Type = data.MentionTypeEveryone
+			addTargets(targets, conversation.UserID)
+		case name == teamKeyword:
+			// Team mentions are only available in conversations bound to a game, and notify every member like everyone
+			if conversation.GameID == "" {
+				continue
+			}
+			if !conversation.Can(message.UserID, data.PermissionModerate) {
+				return ErrorMentionNotAllowed
+			}
+			mention.Type = data.MentionTypeTeam
+			addTargets(targets, conversation.UserID)
+		case conversation.HasUser(token.Name):
+			mention.Type = data.MentionTypeUser
+			mention.UserID = token.Name
+			targets[token.Name] = true
+		default:
+			if usernames == nil {
+				usernames = resolver.usernames(ctx, conversation)
+			}
+			userID, ok := usernames[name]
+			if !ok {
+				continue
+			}
+			mention.Type = data.MentionTypeUser
+			mention.UserID = userID
+			targets[userID] = true
+		}
+		message.Mentions = append(message.Mentions, mention)
+	}
+
+	// Users are not notified of their own mentions
+	delete(targets, message.UserID)
+	for _, userID := range conversation.UserID {
+		if targets[userID] {
+			message.MentionedUserID = append(message.MentionedUserID, userID)
+		}
+	}
+	return nil
+}
+
+// usernames returns the user IDs of the members of the conversation by lowercase username
+// The usernames are fetched concurrently, at most maxConcurrentLookups at a time
+func (resolver *Resolver) usernames(ctx context.Context, conversation *data.Conversation) map[string]string {
+	usernames := make(map[string]string)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentLookups)
+	for _, userID := range conversation.UserID {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(userID string) {
+			defer wait.Done()
+			defer func() { <-slots }()
+			username, err := resolver.directory.GetUsername(ctx, userID)
+			if err != nil {
+				log.Error(err, "Error fetching username", "user_id", userID)
+				return
+			}
+			mutex.Lock()
+			usernames[strings.ToLower(username)] = userID
+			mutex.Unlock()
+		}(userID)
+	}
+	wait.Wait()
+	return usernames
+}
+
+func addTargets(targets map[string]bool, userIDs []string) {
+	for _, userID := range userIDs {
+		targets[userID] = true
+	}
+}
@@ -0,0 +1,154 @@
+package mentions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+const (
+	moderatorID = "a2181017-5c53-422b-b6bc-036b27c04fc8"
+	memberID    = "2aee2975-6b76-4340-b679-e81661b1cdb5"
+)
+
+type fakeDirectory map[string]string
+
+func (directory fakeDirectory) GetUsername(ctx context.Context, userID string) (string, error) {
+	username, ok := directory[userID]
+	if !ok {
+		return "", ErrorUsernameNotFound
+	}
+	return username, nil
+}
+
+func newConversation(gameID string) *data.Conversation {
+	return &data.Conversation{
+		ID:     "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		UserID: []string{moderatorID, memberID},
+		GameID: gameID,
+	}
+}
+
+func newResolver() *Resolver {
+	return NewResolver(fakeDirectory{moderatorID: "Alice", memberID: "Bob"})
+}
+
+func TestParse(t *testing.T) {
+	tokens := Parse("héllo @bob, mail me at bob@example.com @team")
+	if len(tokens) != 2 {
+		t.Fatalf("Expected 2 tokens but got %d", len(tokens))
+	}
+	if tokens[0].Name != "bob" || tokens[0].Offset != 6 || tokens[0].Length != 4 {
+		t.Errorf("Unexpected first token %+v", tokens[0])
+	}
+	if tokens[1].Name != "team" {
+		t.Errorf("Unexpected second token %+v", tokens[1])
+	}
+}
+
+func TestResolveUsernameAndUserID(t *testing.T) {
+	message := &data.Message{UserID: moderatorID, Text: "@BOB and @" + memberID + " and @nobody"}
+
+	err := newResolver().Resolve(context.Background(), message, newConversation(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Mentions) != 2 {
+		t.Fatalf("Expected 2 mentions but got %d", len(message.Mentions))
+	}
+	for _, mention := range message.Mentions {
+		if mention.UserID != memberID || mention.Type != data.MentionTypeUser {
+			t.Errorf("Unexpected mention %+v", mention)
+		}
+	}
+	if len(message.MentionedUserID) != 1 || message.MentionedUserID[0] != memberID {
+		t.Errorf("Expected only %s to be notified but got %v", memberID, message.MentionedUserID)
+	}
+}
+
+func TestResolveEveryoneRequiresModerator(t *testing.T) {
+	message := &data.Message{UserID: memberID, Text: "@everyone gg"}
+	err := newResolver().Resolve(context.Background(), message, newConversation(""))
+	if err != ErrorMentionNotAllowed {
+		t.Errorf("Expected mention not allowed but got %v", err)
+	}
+
+	message = &data.Message{UserID: moderatorID, Text: "@everyone gg"}
+	err = newResolver().Resolve(context.Background(), message, newConversation(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.MentionedUserID) != 1 || message.MentionedUserID[0] != memberID {
+		t.Errorf("Expected everyone except the sender to be notified but got %v", message.MentionedUserID)
+	}
+}
+
+func TestResolveTeamOnlyInGame(t *testing.T) {
+	message := &data.Message{UserID: memberID, Text: "@team push mid"}
+	err := newResolver().Resolve(context.Background(), message, newConversation(""))
+	if err != nil || len(message.Mentions) != 0 {
+		t.Errorf("Expected team mention to be ignored outside of a game, got %v %v", err, message.Mentions)
+	}
+
+	// Team mentions notify every member, so they require the same permission as everyone
+	err = newResolver().Resolve(context.Background(), message, newConversation("a2181017-5c53-422b-b6bc-036b27c04fc8"))
+	if err != ErrorMentionNotAllowed {
+		t.Errorf("Expected mention not allowed but got %v", err)
+	}
+
+	message = &data.Message{UserID: moderatorID, Text: "@team push mid"}
+	err = newResolver().Resolve(context.Background(), message, newConversation("a2181017-5c53-422b-b6bc-036b27c04fc8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Mentions) != 1 || message.Mentions[0].Type != data.MentionTypeTeam {
+		t.Errorf("Expected a team mention but got %v", message.Mentions)
+	}
+}
+
+// countingDirectory records the highest number of concurrent lookups
+type countingDirectory struct {
+	mutex   sync.Mutex
+	current int
+	highest int
+}
+
+func (directory *countingDirectory) GetUsername(ctx context.Context, userID string) (string, error) {
+	directory.mutex.Lock()
+	directory.current++
+	if directory.current > directory.highest {
+		directory.highest = directory.current
+	}
+	directory.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	directory.mutex.Lock()
+	directory.current--
+	directory.mutex.Unlock()
+	return "player-" + userID, nil
+}
+
+func TestResolveUsernamesConcurrently(t *testing.T) {
+	conversation := newConversation("")
+	for i := 0; i < 3*maxConcurrentLookups; i++ {
+		conversation.UserID = append(conversation.UserID, fmt.Sprintf("member-%d", i))
+	}
+	directory := &countingDirectory{}
+	message := &data.Message{UserID: moderatorID, Text: "@player-member-20 gg"}
+
+	err := NewResolver(directory).Resolve(context.Background(), message, conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.MentionedUserID) != 1 || message.MentionedUserID[0] != "member-20" {
+		t.Errorf("Expected member-20 to be notified but got %v", message.MentionedUserID)
+	}
+	if directory.highest < 2 || directory.highest > maxConcurrentLookups {
+		t.Errorf("Expected between 2 and %d concurrent lookups, got %d", maxConcurrentLookups, directory.highest)
+	}
+}
@@ -0,0 +1,139 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// ErrorInvalidRule : Retention rule specific errors
+var ErrorInvalidRule = fmt.Errorf("invalid retention rule")
+
+// Actions applied to the messages older than the retention of their rule
+const (
+	ActionPurge     = "purge"
+	ActionTombstone = "tombstone"
+)
+
+// AnyType selects the conversations of every type
+const AnyType = "*"
+
+// Rule is the retention of the messages of a conversation type, or of a kind of message in a conversation type
+// An empty Kind selects every kind of message
+type Rule struct {
+	ConversationType string
+	Kind             string
+	MaxAge           time.Duration
+	Action           string
+}
+
+// Policy is a set of retention rules, a message is removed as soon as it is older than one of the rules selecting it
+type Policy []Rule
+
+// DefaultPolicy keeps the game conversations 30 days, the direct conversations 1 year and the system messages 7 days
+// Group conversations are kept until they are deleted
+var DefaultPolicy = Policy{
+	{ConversationType: data.ConversationTypeLobby, MaxAge: 30 * 24 * time.Hour, Action: ActionPurge},
+	{ConversationType: data.ConversationTypeTeam, MaxAge: 30 * 24 * time.Hour, Action: ActionPurge},
+	{ConversationType: data.ConversationTypeMatch, MaxAge: 30 * 24 * time.Hour, Action: ActionPurge},
+	{ConversationType: data.ConversationTypeSpectator, MaxAge: 30 * 24 * time.Hour, Action: ActionPurge},
+	{ConversationType: data.ConversationTypeDirect, MaxAge: 365 * 24 * time.Hour, Action: ActionTombstone},
+	{ConversationType: AnyType, Kind: data.MessageKindSystem, MaxAge: 7 * 24 * time.Hour, Action: ActionPurge},
+}
+
+// conversationTypes are the types selected by AnyType
+var conversationTypes = []string{
+	data.ConversationTypeDirect,
+	data.ConversationTypeGroup,
+	data.ConversationTypeLobby,
+	data.ConversationTypeTeam,
+	data.ConversationTypeMatch,
+	data.ConversationTypeSpectator,
+}
+
+// ParsePolicy parses a comma separated list of rules formatted as type[/kind]=max-age[:action]
+// The type is a conversation type or * for every type, the max age is a Go duration and the action purges by default
+// For example "match=720h,direct=8760h:tombstone,*/system=168h"
+func ParsePolicy(value string) (Policy, error) {
+	policy := Policy{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		rule, err := parseRule(field)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, rule)
+	}
+	return policy, nil
+}
+
+func parseRule(field string) (Rule, error) {
+	rule := Rule{Action: ActionPurge}
+	parts := strings.SplitN(field, "=", 2)
+	if len(parts) != 2 {
+		return rule, fmt.Errorf("%w: %q", ErrorInvalidRule, field)
+	}
+
+	selector := strings.SplitN(parts[0], "/", 2)
+	rule.ConversationType = selector[0]
+	if len(selector) == 2 {
+		rule.Kind = selector[1]
+	}
+
+	retention := strings.SplitN(parts[1], ":", 2)
+	maxAge, err := time.ParseDuration(retention[0])
+	if err != nil || maxAge <= 0 {
+		return rule, fmt.Errorf("%w: %q", ErrorInvalidRule, field)
+	}
+	rule.MaxAge = maxAge
+	if len(retention) == 2 {
+		rule.Action = retention[1]
+	}
+	return rule, rule.validate()
+}
+
+// String formats the rule with the syntax of ParsePolicy
+func (rule Rule) String() string {
+	selector := rule.ConversationType
+	if rule.Kind != "" {
+		selector += "/" + rule.Kind
+	}
+	return selector + "=" + rule.MaxAge.String() + ":" + rule.Action
+}
+
+func (rule Rule) validate() error {
+	if rule.ConversationType != AnyType && !contains(conversationTypes, rule.ConversationType) {
+		return fmt.Errorf("%w: unknown conversation type %q", ErrorInvalidRule, rule.ConversationType)
+	}
+	switch rule.Kind {
+	case "", data.MessageKindUser, data.MessageKindSystem, data.MessageKindBot:
+	default:
+		return fmt.Errorf("%w: unknown message kind %q", ErrorInvalidRule, rule.Kind)
+	}
+	if rule.Action != ActionPurge && rule.Action != ActionTombstone {
+		return fmt.Errorf("%w: unknown action %q", ErrorInvalidRule, rule.Action)
+	}
+	return nil
+}
+
+// types returns the conversation types selected by the rule
+func (rule Rule) types() []string {
+	if rule.ConversationType == AnyType {
+		return conversationTypes
+	}
+	return []string{rule.ConversationType}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
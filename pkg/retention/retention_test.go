@@ -0,0 +1,81 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("match=720h, direct=8760h:tombstone,*/system=168h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy) != 3 || policy[1].Action != ActionTombstone || policy[2].Kind != data.MessageKindSystem || policy[0].MaxAge != 720*time.Hour {
+		t.Errorf("Unexpected policy %v", policy)
+	}
+
+	for _, value := range []string{"match", "match=forever", "guild=720h", "match=720h:archive", "match/whisper=720h"} {
+		if _, err = ParsePolicy(value); err == nil {
+			t.Errorf("Expected %q to be invalid", value)
+		}
+	}
+}
+
+func TestRetentionWorker(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+
+	match, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeMatch, UserID: []string{"a", "b"}, GameID: "retention-game"})
+	direct, _ := db.AddConversation(ctx, &data.Conversation{Type: data.ConversationTypeDirect, UserID: []string{"a", "b"}})
+	for i := 0; i < 3; i++ {
+		_ = db.AddMessage(ctx, &data.Message{UserID: "a", ConversationID: match.ID, Text: "gg"})
+	}
+	_ = db.AddMessage(ctx, &data.Message{UserID: "a", ConversationID: direct.ID, Text: "see you"})
+	_ = db.AddSystemMessage(ctx, &data.Message{ConversationID: direct.ID, Text: "a joined", Kind: data.MessageKindSystem, Sender: &data.Sender{ID: "game", Type: data.SenderTypeService}})
+
+	policy := Policy{
+		{ConversationType: data.ConversationTypeMatch, MaxAge: 30 * 24 * time.Hour, Action: ActionPurge},
+		{ConversationType: data.ConversationTypeDirect, MaxAge: 365 * 24 * time.Hour, Action: ActionTombstone},
+		{ConversationType: AnyType, Kind: data.MessageKindSystem, MaxAge: 7 * 24 * time.Hour, Action: ActionPurge},
+	}
+	later := time.Now().Add(40 * 24 * time.Hour)
+
+	// The dry-run only reports the messages
+	report, err := NewWorker(db, nil, policy, true).Run(ctx, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Rules[0].Messages != 3 || report.Rules[1].Messages != 0 || report.Rules[2].Messages != 1 {
+		t.Errorf("Unexpected dry-run report %+v", report)
+	}
+	messages, _ := db.GetMessagesByConversationID(ctx, match.ID)
+	if len(messages) != 3 {
+		t.Fatalf("Expected the dry-run to keep the messages, got %d", len(messages))
+	}
+
+	// The messages are purged in batches
+	worker := NewWorker(db, nil, policy, false)
+	worker.batchSize = 2
+	worker.batchDelay = 0
+	report, err = worker.Run(ctx, later)
+	if err != nil || report.Rules[0].Messages != 3 || report.Rules[2].Messages != 1 {
+		t.Fatalf("Unexpected report %+v: %v", report, err)
+	}
+	if _, err = db.GetMessagesByConversationID(ctx, match.ID); err != data.ErrorMessageNotFound {
+		t.Errorf("Expected the match messages to be purged, got %v", err)
+	}
+
+	// Direct messages are tombstoned after a year
+	report, err = worker.Run(ctx, time.Now().Add(400*24*time.Hour))
+	if err != nil || report.Rules[1].Messages != 1 {
+		t.Fatalf("Unexpected report %+v: %v", report, err)
+	}
+	messages, _ = db.GetMessagesByConversationID(ctx, direct.ID)
+	if len(messages) != 1 || !messages[0].IsTombstone() || messages[0].Text != "" {
+		t.Errorf("Expected a tombstone of the direct message, got %v", messages)
+	}
+}
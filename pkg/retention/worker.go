@@ -0,0 +1,225 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/attachments"
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// Defaults of the retention worker
+const (
+	DefaultInterval   = 24 * time.Hour
+	DefaultBatchSize  = 500
+	DefaultBatchDelay = time.Second
+)
+
+// conversationsPerQuery is the number of conversations selected by a single query of messages
+const conversationsPerQuery = 100
+
+// Metrics of the messages removed by the retention worker, by conversation type
+var (
+	PurgedMessages     = stats.Int64("retention_purged_messages", "The number of messages purged by the retention policy", stats.UnitDimensionless)
+	TombstonedMessages = stats.Int64("retention_tombstoned_messages", "The number of messages tombstoned by the retention policy", stats.UnitDimensionless)
+
+	conversationTypeKey = tag.MustNewKey("conversation_type")
+)
+
+// RegisterViews registers the views of the retention metrics with the metrics exporter
+func RegisterViews() error {
+	return view.Register(
+		&view.View{
+			Name:        "retention_purged_messages_total",
+			Measure:     PurgedMessages,
+			Description: "The number of messages purged by the retention policy",
+			TagKeys:     []tag.Key{conversationTypeKey},
+			Aggregation: view.Sum(),
+		},
+		&view.View{
+			Name:        "retention_tombstoned_messages_total",
+			Measure:     TombstonedMessages,
+			Description: "The number of messages tombstoned by the retention policy",
+			TagKeys:     []tag.Key{conversationTypeKey},
+			Aggregation: view.Sum(),
+		},
+	)
+}
+
+// RuleReport is the number of messages removed by a rule, or that would be removed in dry-run mode
+type RuleReport struct {
+	Rule     string `json:"rule"`
+	Messages int64  `json:"messages"`
+}
+
+// Report is the result of a retention run
+type Report struct {
+	DryRun bool         `json:"dry_run"`
+	Rules  []RuleReport `json:"rules"`
+}
+
+// Worker periodically removes the messages older than the retention policy
+// Messages are removed in batches with a delay between two batches, so a large backlog doesn't overload the database
+// In dry-run mode the worker only reports the messages it would remove
+type Worker struct {
+	db          database.TextChatDB
+	attachments *attachments.Service
+	policy      Policy
+	dryRun      bool
+	batchSize   int
+	batchDelay  time.Duration
+	interval    time.Duration
+	wait        sync.WaitGroup
+}
+
+// NewWorker creates a retention worker, the attachments of the removed messages are deleted with the attachment service
+func NewWorker(db database.TextChatDB, attachmentService *attachments.Service, policy Policy, dryRun bool) *Worker {
+	return &Worker{
+		db:          db,
+		attachments: attachmentService,
+		policy:      policy,
+		dryRun:      dryRun,
+		batchSize:   DefaultBatchSize,
+		batchDelay:  DefaultBatchDelay,
+		interval:    DefaultInterval,
+	}
+}
+
+// Start runs the worker until the context is cancelled
+func (worker *Worker) Start(ctx context.Context) {
+	worker.wait.Add(1)
+	go func() {
+		defer worker.wait.Done()
+		ticker := time.NewTicker(worker.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = worker.Run(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the worker is stopped
+func (worker *Worker) Wait() {
+	worker.wait.Wait()
+}
+
+// Run applies every rule of the policy to the messages older than their retention at the given time
+func (worker *Worker) Run(ctx context.Context, now time.Time) (*Report, error) {
+	report := &Report{DryRun: worker.dryRun, Rules: []RuleReport{}}
+	for _, rule := range worker.policy {
+		ruleReport := RuleReport{Rule: rule.String()}
+		for _, conversationType := range rule.types() {
+			count, err := worker.applyToType(ctx, rule, conversationType, now)
+			ruleReport.Messages += count
+			if err != nil {
+				log.Error(err, "Error applying retention rule", "conversation_type", conversationType, "kind", rule.Kind)
+				report.Rules = append(report.Rules, ruleReport)
+				return report, err
+			}
+		}
+		if ruleReport.Messages > 0 {
+			log.Info("Applied retention rule", "conversation_type", rule.ConversationType, "kind", rule.Kind, "action", rule.Action, "messages", ruleReport.Messages, "dry_run", worker.dryRun)
+		}
+		report.Rules = append(report.Rules, ruleReport)
+	}
+	return report, nil
+}
+
+// applyToType applies the rule to the conversations of a type
+func (worker *Worker) applyToType(ctx context.Context, rule Rule, conversationType string, now time.Time) (int64, error) {
+	conversations, err := worker.db.GetConversationsByType(ctx, conversationType)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for start := 0; start < len(conversations); start += conversationsPerQuery {
+		end := start + conversationsPerQuery
+		if end > len(conversations) {
+			end = len(conversations)
+		}
+		query := &data.RetentionQuery{Kind: rule.Kind, CreatedBefore: now.Add(-rule.MaxAge)}
+		for _, conversation := range conversations[start:end] {
+			query.ConversationID = append(query.ConversationID, conversation.ID)
+		}
+
+		count, err := worker.apply(ctx, rule, conversationType, query)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// apply removes the messages selected by the query in batches, or counts them in dry-run mode
+func (worker *Worker) apply(ctx context.Context, rule Rule, conversationType string, query *data.RetentionQuery) (int64, error) {
+	if worker.dryRun {
+		return worker.db.CountMessagesForRetention(ctx, query)
+	}
+
+	var total int64
+	for {
+		messages, err := worker.db.GetMessagesForRetention(ctx, query, worker.batchSize)
+		if err != nil || len(messages) == 0 {
+			return total, err
+		}
+
+		removed, err := worker.remove(ctx, rule, conversationType, messages)
+		total += removed
+		// A batch that removed nothing would be selected again
+		if err != nil || removed == 0 || len(messages) < worker.batchSize {
+			return total, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(worker.batchDelay):
+		}
+	}
+}
+
+// remove applies the action of the rule to a batch of messages and deletes their attachments
+func (worker *Worker) remove(ctx context.Context, rule Rule, conversationType string, messages data.Messages) (int64, error) {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	var removed int64
+	var err error
+	measure := PurgedMessages
+	if rule.Action == ActionTombstone {
+		measure = TombstonedMessages
+		removed, err = worker.db.TombstoneMessages(ctx, ids, time.Now())
+	} else {
+		removed, err = worker.db.PurgeMessages(ctx, ids)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, message := range messages {
+		if len(message.Attachments) == 0 || worker.attachments == nil {
+			continue
+		}
+		err = worker.attachments.CleanupMessage(ctx, message.ID)
+		if err != nil {
+			log.Error(err, "Error deleting attachments of removed message", "id", message.ID)
+		}
+	}
+
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(conversationTypeKey, conversationType)}, measure.M(removed))
+	return removed, nil
+}
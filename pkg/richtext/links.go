@@ -0,0 +1,46 @@
+package richtext
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultAllowedDomains are the domains that are linkable by default
+// Subdomains of an allowed domain are also allowed
+var DefaultAllowedDomains = []string{
+	"ubivius.com",
+	"github.com",
+	"youtube.com",
+	"youtu.be",
+	"twitch.tv",
+}
+
+// Allowlist decides which URLs can become links
+type Allowlist struct {
+	domains []string
+}
+
+// NewAllowlist creates an allowlist of linkable domains
+func NewAllowlist(domains []string) *Allowlist {
+	allowlist := &Allowlist{}
+	for _, domain := range domains {
+		allowlist.domains = append(allowlist.domains, strings.ToLower(strings.TrimPrefix(domain, ".")))
+	}
+	return allowlist
+}
+
+// Allowed returns the normalized URL and true when the URL is an http(s) URL on an allowed domain
+func (allowlist *Allowlist) Allowed(rawURL string) (string, bool) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.User != nil {
+		return "", false
+	}
+
+	host := strings.ToLower(parsedURL.Hostname())
+	for _, domain := range allowlist.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return parsedURL.String(), true
+		}
+	}
+	return "", false
+}
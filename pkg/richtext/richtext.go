@@ -0,0 +1,268 @@
+package richtext
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// MaxEntities is the maximum number of entities parsed from a single message
+const MaxEntities = 100
+
+// Parser converts the supported markdown subset into plain text and entities
+//
+// The supported syntax is **bold**, *italic* or _italic_, `code`, ||spoiler||,
+// [label](url) links and bare http(s) URLs. Links are only created for URLs on
+// allowed domains, any other markup is kept as plain text. A backslash escapes
+// the next markup character.
+type Parser struct {
+	allowlist *Allowlist
+}
+
+// NewParser creates a rich text parser with the linkable domains
+func NewParser(allowedDomains []string) *Parser {
+	return &Parser{allowlist: NewAllowlist(allowedDomains)}
+}
+
+// NewDefaultParser creates a rich text parser with the default linkable domains
+func NewDefaultParser() *Parser {
+	return NewParser(DefaultAllowedDomains)
+}
+
+// Format parses the text of the message, replacing it with its plain text and filling its entities
+func (parser *Parser) Format(message *data.Message) {
+	message.Text, message.Entities = parser.Parse(message.Text)
+}
+
+// Parse returns the plain text and the entities of a text
+// Offsets and lengths of the entities are counted in characters of the plain text
+func (parser *Parser) Parse(text string) (string, []data.Entity) {
+	state := &parseState{parser: parser}
+	plain := state.parse([]rune(text), 0)
+
+	// Nested entities are found before their parent, sort them so outer entities come first
+	sort.SliceStable(state.entities, func(i, j int) bool {
+		if state.entities[i].Offset != state.entities[j].Offset {
+			return state.entities[i].Offset < state.entities[j].Offset
+		}
+		return state.entities[i].Length > state.entities[j].Length
+	})
+	if len(state.entities) > MaxEntities {
+		state.entities = state.entities[:MaxEntities]
+	}
+	return string(plain), state.entities
+}
+
+type parseState struct {
+	parser   *Parser
+	entities []data.Entity
+}
+
+// delimiters that wrap formatted text, the order matters since ** must be matched before *
+var delimiters = []struct {
+	marker     string
+	entityType string
+}{
+	{"||", data.EntityTypeSpoiler},
+	{"**", data.EntityTypeBold},
+	{"*", data.EntityTypeItalic},
+	{"_", data.EntityTypeItalic},
+}
+
+const escapable = "\\*_`|[]()"
+
+// parse converts the runes into plain text, base is the offset of the runes in the whole plain text
+func (state *parseState) parse(runes []rune, base int) []rune {
+	var plain []rune
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		// Escaped markup character
+		if r == '\\' && i+1 < len(runes) && strings.ContainsRune(escapable, runes[i+1]) {
+			plain = append(plain, runes[i+1])
+			i += 2
+			continue
+		}
+
+		// Code spans are never parsed further
+		if r == '`' {
+			end := indexFrom(runes, i+1, "`")
+			if end > i+1 {
+				state.addEntity(data.EntityTypeCode, base+len(plain), end-i-1, "")
+				plain = append(plain, runes[i+1:end]...)
+				i = end + 1
+				continue
+			}
+		}
+
+		if r == '[' {
+			if next, ok := state.parseLink(runes, i, base, &plain); ok {
+				i = next
+				continue
+			}
+		}
+
+		if r == 'h' && (i == 0 || unicode.IsSpace(runes[i-1])) {
+			if next, ok := state.parseBareURL(runes, i, base, &plain); ok {
+				i = next
+				continue
+			}
+		}
+
+		if next, ok := state.parseDelimited(runes, i, base, &plain); ok {
+			i = next
+			continue
+		}
+
+		plain = append(plain, r)
+		i++
+	}
+	return plain
+}
+
+// parseDelimited parses a bold, italic or spoiler span starting at i
+func (state *parseState) parseDelimited(runes []rune, i int, base int, plain *[]rune) (int, bool) {
+	for _, delimiter := range delimiters {
+		marker := []rune(delimiter.marker)
+		if !hasPrefixAt(runes, i, delimiter.marker) {
+			continue
+		}
+		start := i + len(marker)
+		end := state.closingIndex(runes, start, delimiter.marker)
+		if end <= start {
+			return 0, false
+		}
+
+		offset := base + len(*plain)
+		inner := state.parse(runes[start:end], offset)
+		state.addEntity(delimiter.entityType, offset, len(inner), "")
+		*plain = append(*plain, inner...)
+		return end + len(marker), true
+	}
+	return 0, false
+}
+
+// closingIndex returns the index of the marker closing a span opened before start, or -1
+func (state *parseState) closingIndex(runes []rune, start int, marker string) int {
+	switch marker {
+	case "*":
+		// A single star closes italic, double stars are a nested bold span
+		for j := start; j < len(runes); j++ {
+			if runes[j] == '\\' {
+				j++
+				continue
+			}
+			if hasPrefixAt(runes, j, "**") {
+				j++
+				continue
+			}
+			if runes[j] == '*' {
+				return j
+			}
+		}
+		return -1
+	case "_":
+		// Underscores inside words, like in usernames, are not markup
+		if start >= 2 && isWordRune(runes[start-2]) {
+			return -1
+		}
+		for j := start; j < len(runes); j++ {
+			if runes[j] == '_' && (j+1 == len(runes) || !isWordRune(runes[j+1])) {
+				return j
+			}
+		}
+		return -1
+	default:
+		return indexFrom(runes, start, marker)
+	}
+}
+
+// parseLink parses a [label](url) link starting at i
+// Links to domains that are not allowed are kept as plain text
+func (state *parseState) parseLink(runes []rune, i int, base int, plain *[]rune) (int, bool) {
+	labelEnd := indexFrom(runes, i+1, "](")
+	if labelEnd <= i+1 {
+		return 0, false
+	}
+	urlEnd := indexFrom(runes, labelEnd+2, ")")
+	if urlEnd <= labelEnd+2 {
+		return 0, false
+	}
+
+	linkURL, ok := state.parser.allowlist.Allowed(string(runes[labelEnd+2 : urlEnd]))
+	if !ok {
+		return 0, false
+	}
+
+	offset := base + len(*plain)
+	label := state.parse(runes[i+1:labelEnd], offset)
+	state.addEntity(data.EntityTypeLink, offset, len(label), linkURL)
+	*plain = append(*plain, label...)
+	return urlEnd + 1, true
+}
+
+// parseBareURL detects an http(s) URL starting at i
+func (state *parseState) parseBareURL(runes []rune, i int, base int, plain *[]rune) (int, bool) {
+	if !hasPrefixAt(runes, i, "http://") && !hasPrefixAt(runes, i, "https://") {
+		return 0, false
+	}
+	end := i
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	// Trailing punctuation is part of the sentence, not of the URL
+	for end > i && strings.ContainsRune(".,;:!?)'\"", runes[end-1]) {
+		end--
+	}
+
+	rawURL := string(runes[i:end])
+	linkURL, ok := state.parser.allowlist.Allowed(rawURL)
+	if !ok {
+		return 0, false
+	}
+
+	state.addEntity(data.EntityTypeLink, base+len(*plain), end-i, linkURL)
+	*plain = append(*plain, runes[i:end]...)
+	return end, true
+}
+
+func (state *parseState) addEntity(entityType string, offset int, length int, url string) {
+	if length == 0 {
+		return
+	}
+	state.entities = append(state.entities, data.Entity{
+		Type:   entityType,
+		Offset: offset,
+		Length: length,
+		URL:    url,
+	})
+}
+
+// indexFrom returns the index of the marker in runes at or after start, or -1
+func indexFrom(runes []rune, start int, marker string) int {
+	for j := start; j < len(runes); j++ {
+		if hasPrefixAt(runes, j, marker) {
+			return j
+		}
+	}
+	return -1
+}
+
+func hasPrefixAt(runes []rune, i int, prefix string) bool {
+	markerRunes := []rune(prefix)
+	if i+len(markerRunes) > len(runes) {
+		return false
+	}
+	for j, r := range markerRunes {
+		if runes[i+j] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,75 @@
+package richtext
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+func TestParseFormatting(t *testing.T) {
+	parser := NewDefaultParser()
+
+	text, entities := parser.Parse("**GG** *wp* `/roll` ||it was close||")
+	if text != "GG wp /roll it was close" {
+		t.Errorf("Unexpected plain text %q", text)
+	}
+	expected := []data.Entity{
+		{Type: data.EntityTypeBold, Offset: 0, Length: 2},
+		{Type: data.EntityTypeItalic, Offset: 3, Length: 2},
+		{Type: data.EntityTypeCode, Offset: 6, Length: 5},
+		{Type: data.EntityTypeSpoiler, Offset: 12, Length: 12},
+	}
+	if !reflect.DeepEqual(entities, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, entities)
+	}
+}
+
+func TestParseNestedAndEscaped(t *testing.T) {
+	parser := NewDefaultParser()
+
+	text, entities := parser.Parse(`*très **bien*** \*not italic\* my_user_name`)
+	if text != "très bien *not italic* my_user_name" {
+		t.Errorf("Unexpected plain text %q", text)
+	}
+	expected := []data.Entity{
+		{Type: data.EntityTypeItalic, Offset: 0, Length: 9},
+		{Type: data.EntityTypeBold, Offset: 5, Length: 4},
+	}
+	if !reflect.DeepEqual(entities, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, entities)
+	}
+}
+
+func TestParseUnterminatedMarkupIsPlainText(t *testing.T) {
+	text, entities := NewDefaultParser().Parse("2 * 3 = 6 and **oops")
+	if text != "2 * 3 = 6 and **oops" || len(entities) != 0 {
+		t.Errorf("Expected unterminated markup to be kept as is, got %q %+v", text, entities)
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	parser := NewDefaultParser()
+
+	text, entities := parser.Parse("see [the repo](https://github.com/Ubivius) or https://www.youtube.com/watch?v=1.")
+	if text != "see the repo or https://www.youtube.com/watch?v=1." {
+		t.Errorf("Unexpected plain text %q", text)
+	}
+	expected := []data.Entity{
+		{Type: data.EntityTypeLink, Offset: 4, Length: 8, URL: "https://github.com/Ubivius"},
+		{Type: data.EntityTypeLink, Offset: 16, Length: 33, URL: "https://www.youtube.com/watch?v=1"},
+	}
+	if !reflect.DeepEqual(entities, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, entities)
+	}
+}
+
+func TestParseLinksNotAllowed(t *testing.T) {
+	parser := NewDefaultParser()
+
+	raw := "[free skins](http://evil.example.com) javascript:alert(1) https://github.com.evil.io"
+	text, entities := parser.Parse(raw)
+	if text != raw || len(entities) != 0 {
+		t.Errorf("Expected links to disallowed domains to be plain text, got %q %+v", text, entities)
+	}
+}
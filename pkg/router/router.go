@@ -16,13 +16,24 @@ func New(textChatHandler *handlers.TextChatHandler) *mux.Router {
 	router := mux.NewRouter()
 	router.Use(otelmux.Middleware("text-chat"))
 	router.Use(metrics.RequestCountMiddleware)
+	router.Use(textChatHandler.MiddlewareUserIdentity)
 
 	// Get Router
 	getRouter := router.Methods(http.MethodGet).Subrouter()
 	getRouter.Use(tokenValidation.Middleware)
 	getRouter.HandleFunc("/messages/{id:[0-9a-z-]+}", textChatHandler.GetMessageByID)
 	getRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}", textChatHandler.GetConversationByID)
+	getRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/events", textChatHandler.StreamConversationEvents)
+	getRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/pins", textChatHandler.GetPinnedMessages)
+	getRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/export", textChatHandler.ExportConversation)
 	getRouter.HandleFunc("/messages/conversation/{id:[0-9a-z-]+}", textChatHandler.GetMessagesByConversationID)
+	getRouter.HandleFunc("/users/{id:[0-9a-z-]+}/mentions", textChatHandler.GetMentionsByUserID)
+	getRouter.HandleFunc("/users/{id:[0-9a-z-]+}/conversations", textChatHandler.GetConversationsByUserID)
+	getRouter.HandleFunc("/users/{id:[0-9a-z-]+}/scheduled-messages", textChatHandler.GetScheduledMessagesByUserID)
+	getRouter.HandleFunc("/attachments/{id:[0-9a-z-]+}", textChatHandler.GetAttachmentByID)
+	getRouter.HandleFunc("/games/{id:[0-9a-z-]+}/conversations", textChatHandler.GetGameConversations)
+	getRouter.HandleFunc("/games/{id:[0-9a-z-]+}/messages", textChatHandler.GetGameMessages)
+	getRouter.HandleFunc("/games/{id:[0-9a-z-]+}/events", textChatHandler.StreamGameEvents)
 
 	//Health Check
 	healthRouter := router.Methods(http.MethodGet).Subrouter()
@@ -35,24 +46,51 @@ func New(textChatHandler *handlers.TextChatHandler) *mux.Router {
 	messagePostRouter.HandleFunc("/messages", textChatHandler.AddMessage)
 	messagePostRouter.Use(textChatHandler.MiddlewareMessageValidation)
 
+	// Attachment post router, the multipart body is validated by the handler
+	attachmentPostRouter := router.Methods(http.MethodPost).Subrouter()
+	attachmentPostRouter.Use(tokenValidation.Middleware)
+	attachmentPostRouter.HandleFunc("/attachments", textChatHandler.UploadAttachment)
+
 	// Conversation post router
 	conversationPostRouter := router.Methods(http.MethodPost).Subrouter()
 	conversationPostRouter.Use(tokenValidation.Middleware)
 	conversationPostRouter.HandleFunc("/conversations", textChatHandler.AddConversation)
 	conversationPostRouter.Use(textChatHandler.MiddlewareConversationValidation)
 
+	// Pin and archive post router, the requests have no body
+	actionPostRouter := router.Methods(http.MethodPost).Subrouter()
+	actionPostRouter.Use(tokenValidation.Middleware)
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/pins/{messageID:[0-9a-z-]+}", textChatHandler.PinMessage)
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/archive", textChatHandler.ArchiveConversation)
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/restore", textChatHandler.RestoreConversation)
+
 	// Delete router
 	deleteRouter := router.Methods(http.MethodDelete).Subrouter()
 	deleteRouter.Use(tokenValidation.Middleware)
 	deleteRouter.HandleFunc("/messages/{id:[0-9a-z-]+}", textChatHandler.DeleteMessage)
 	deleteRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}", textChatHandler.DeleteConversation)
+	deleteRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/pins/{messageID:[0-9a-z-]+}", textChatHandler.UnpinMessage)
+	deleteRouter.HandleFunc("/scheduled-messages/{id:[0-9a-z-]+}", textChatHandler.CancelScheduledMessage)
 
 	// Conversation put router
 	conversationPutRouter := router.Methods(http.MethodPut).Subrouter()
-	conversationPostRouter.Use(tokenValidation.Middleware)
+	conversationPutRouter.Use(tokenValidation.Middleware)
 	conversationPutRouter.HandleFunc("/conversations", textChatHandler.AddUserToConversation)
 	conversationPutRouter.Use(textChatHandler.MiddlewareConversationValidation)
 
+	// Role put router
+	rolePutRouter := router.Methods(http.MethodPut).Subrouter()
+	rolePutRouter.Use(tokenValidation.Middleware)
+	rolePutRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/roles", textChatHandler.SetMemberRole)
+	rolePutRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/owner", textChatHandler.TransferOwnership)
+	rolePutRouter.Use(textChatHandler.MiddlewareRoleChangeValidation)
+
+	// Conversation patch router
+	conversationPatchRouter := router.Methods(http.MethodPatch).Subrouter()
+	conversationPatchRouter.Use(tokenValidation.Middleware)
+	conversationPatchRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}", textChatHandler.PatchConversation)
+	conversationPatchRouter.Use(textChatHandler.MiddlewareConversationPatchValidation)
+
 	return router
 }
 
@@ -61,6 +99,12 @@ func NewInternalRouter(textChatHandler *handlers.TextChatHandler) *mux.Router {
 	router := mux.NewRouter()
 	router.Use(otelmux.Middleware("text-chat"))
 	router.Use(metrics.RequestCountMiddleware)
+	router.Use(textChatHandler.MiddlewareInternal)
+
+	// System message post router
+	systemMessagePostRouter := router.Methods(http.MethodPost).Subrouter()
+	systemMessagePostRouter.HandleFunc("/messages/system", textChatHandler.AddSystemMessage)
+	systemMessagePostRouter.Use(textChatHandler.MiddlewareSystemMessageValidation)
 
 	// Conversation post router
 	conversationPostRouter := router.Methods(http.MethodPost).Subrouter()
@@ -72,5 +116,63 @@ func NewInternalRouter(textChatHandler *handlers.TextChatHandler) *mux.Router {
 	conversationPutRouter.HandleFunc("/conversations", textChatHandler.AddUserToConversation)
 	conversationPutRouter.Use(textChatHandler.MiddlewareConversationValidation)
 
+	// Role put router
+	rolePutRouter := router.Methods(http.MethodPut).Subrouter()
+	rolePutRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/roles", textChatHandler.SetMemberRole)
+	rolePutRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/owner", textChatHandler.TransferOwnership)
+	rolePutRouter.Use(textChatHandler.MiddlewareRoleChangeValidation)
+
+	// Pin and archive routers, the requests have no body
+	actionPostRouter := router.Methods(http.MethodPost).Subrouter()
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/pins/{messageID:[0-9a-z-]+}", textChatHandler.PinMessage)
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/archive", textChatHandler.ArchiveConversation)
+	actionPostRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/restore", textChatHandler.RestoreConversation)
+	pinDeleteRouter := router.Methods(http.MethodDelete).Subrouter()
+	pinDeleteRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/pins/{messageID:[0-9a-z-]+}", textChatHandler.UnpinMessage)
+
+	// Conversation patch router
+	conversationPatchRouter := router.Methods(http.MethodPatch).Subrouter()
+	conversationPatchRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}", textChatHandler.PatchConversation)
+	conversationPatchRouter.Use(textChatHandler.MiddlewareConversationPatchValidation)
+
+	// Match routers, the conversations of a match are managed by the game server
+	matchGetRouter := router.Methods(http.MethodGet).Subrouter()
+	matchGetRouter.HandleFunc("/matches/{id:[0-9a-z-]+}", textChatHandler.GetMatch)
+
+	matchStartRouter := router.Methods(http.MethodPost).Subrouter()
+	matchStartRouter.HandleFunc("/matches", textChatHandler.StartMatch)
+	matchStartRouter.Use(textChatHandler.MiddlewareMatchRosterValidation)
+
+	matchPlayerPostRouter := router.Methods(http.MethodPost).Subrouter()
+	matchPlayerPostRouter.HandleFunc("/matches/{id:[0-9a-z-]+}/players", textChatHandler.JoinMatch)
+	matchPlayerPostRouter.Use(textChatHandler.MiddlewareMatchPlayerValidation)
+
+	matchPlayerPutRouter := router.Methods(http.MethodPut).Subrouter()
+	matchPlayerPutRouter.HandleFunc("/matches/{id:[0-9a-z-]+}/players", textChatHandler.SwapTeam)
+	matchPlayerPutRouter.Use(textChatHandler.MiddlewareMatchPlayerValidation)
+
+	matchPlayerDeleteRouter := router.Methods(http.MethodDelete).Subrouter()
+	matchPlayerDeleteRouter.HandleFunc("/matches/{id:[0-9a-z-]+}/players/{userID:[0-9a-z-]+}", textChatHandler.LeaveMatch)
+
+	matchEndRouter := router.Methods(http.MethodPost).Subrouter()
+	matchEndRouter.HandleFunc("/matches/{id:[0-9a-z-]+}/end", textChatHandler.EndMatch)
+
+	// Export router, for the data requests of the players and the archival of the transcripts
+	exportGetRouter := router.Methods(http.MethodGet).Subrouter()
+	exportGetRouter.HandleFunc("/users/{id:[0-9a-z-]+}/export", textChatHandler.ExportUserData)
+	exportGetRouter.HandleFunc("/conversations/{id:[0-9a-z-]+}/export", textChatHandler.ExportConversation)
+
+	// Retention router, reports the messages the retention policy would remove
+	retentionGetRouter := router.Methods(http.MethodGet).Subrouter()
+	retentionGetRouter.HandleFunc("/retention/report", textChatHandler.GetRetentionReport)
+
+	// Erasure router, removes the users deleted by microservice-user
+	erasurePostRouter := router.Methods(http.MethodPost).Subrouter()
+	erasurePostRouter.HandleFunc("/users/{id:[0-9a-z-]+}/erase", textChatHandler.EraseUser)
+
+	// Import router, for the migration of the conversations of other chat systems
+	importPostRouter := router.Methods(http.MethodPost).Subrouter()
+	importPostRouter.HandleFunc("/import", textChatHandler.ImportRecords)
+
 	return router
 }
@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+	"github.com/Ubivius/microservice-text-chat/pkg/unfurl"
+)
+
+// DefaultInterval is the interval between two deliveries of the due scheduled messages
+const DefaultInterval = 5 * time.Second
+
+// ClaimTimeout is the time after which a message claimed by a dispatcher that stopped before completing it is claimed again
+const ClaimTimeout = time.Minute
+
+// Dispatcher periodically sends the due scheduled messages
+// A message is claimed before being sent and keeps its scheduled ID once sent, so it is sent exactly once
+// even when a dispatcher stops in the middle of a delivery and another one retries it after a restart
+type Dispatcher struct {
+	db       database.TextChatDB
+	broker   *events.Broker
+	unfurler *unfurl.Worker
+	interval time.Duration
+	wait     sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher, the sent messages are published on the broker and unfurled when an unfurl worker is set
+func NewDispatcher(db database.TextChatDB, broker *events.Broker, unfurler *unfurl.Worker, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		broker:   broker,
+		unfurler: unfurler,
+		interval: interval,
+	}
+}
+
+// Start runs the dispatcher until the context is cancelled
+func (dispatcher *Dispatcher) Start(ctx context.Context) {
+	dispatcher.wait.Add(1)
+	go func() {
+		defer dispatcher.wait.Done()
+		ticker := time.NewTicker(dispatcher.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = dispatcher.Dispatch(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the dispatcher is stopped
+func (dispatcher *Dispatcher) Wait() {
+	dispatcher.wait.Wait()
+}
+
+// Dispatch sends the messages due at the given time and returns the number of messages sent
+func (dispatcher *Dispatcher) Dispatch(ctx context.Context, now time.Time) (int, error) {
+	claimed, err := dispatcher.db.ClaimScheduledMessages(ctx, now, now.Add(-ClaimTimeout))
+	if err != nil {
+		log.Error(err, "Error claiming scheduled messages")
+		return 0, err
+	}
+
+	sent := 0
+	for _, scheduled := range claimed {
+		status, reason := dispatcher.send(ctx, scheduled)
+		if status == data.ScheduleStatusSent {
+			sent++
+		}
+		// A message left claimed is retried after the claim timeout, and found already sent when it was
+		if status == data.ScheduleStatusSending {
+			continue
+		}
+		err = dispatcher.db.CompleteScheduledMessage(ctx, scheduled.ID, status, reason)
+		if err != nil {
+			log.Error(err, "Error completing scheduled message", "id", scheduled.ID)
+		}
+	}
+	if sent > 0 {
+		log.Info("Sent scheduled messages", "count", sent)
+	}
+	return sent, nil
+}
+
+// send adds the message of a scheduled message to its conversation through the same checks as a new message
+// A message already sent by a previous delivery is not sent again
+// The message fails when it can no longer be sent, and stays claimed to be retried on other errors
+func (dispatcher *Dispatcher) send(ctx context.Context, scheduled *data.ScheduledMessage) (string, string) {
+	message := scheduled.Message
+	message.ID = scheduled.ID
+	message.TTL = scheduled.TTL
+
+	conversation, err := dispatcher.db.GetConversationByID(ctx, message.ConversationID)
+	if err == nil && !conversation.Can(message.UserID, data.PermissionSendMessage) {
+		err = data.ErrorPermissionDenied
+	}
+	if err == nil {
+		err = dispatcher.db.AddMessage(ctx, message)
+	}
+	switch err {
+	case nil:
+		dispatcher.broker.PublishMessage(events.EventMessageCreated, message)
+		if dispatcher.unfurler != nil {
+			dispatcher.unfurler.Enqueue(message)
+		}
+		return data.ScheduleStatusSent, ""
+	case data.ErrorMessageExists:
+		log.Info("Scheduled message already sent", "id", scheduled.ID)
+		return data.ScheduleStatusSent, ""
+	case data.ErrorConversationNotFound, data.ErrorConversationArchived, data.ErrorPermissionDenied, data.ErrorUserMuted, data.ErrorUserNotFound:
+		log.Error(err, "Scheduled message can't be sent", "id", scheduled.ID)
+		return data.ScheduleStatusFailed, err.Error()
+	default:
+		log.Error(err, "Error sending scheduled message", "id", scheduled.ID)
+		return data.ScheduleStatusSending, ""
+	}
+}
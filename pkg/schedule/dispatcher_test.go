@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+)
+
+func newScheduledMessage(t *testing.T, db database.TextChatDB, sendAt time.Time) *data.ScheduledMessage {
+	conversation, err := db.AddConversation(context.Background(), &data.Conversation{UserID: []string{"organizer", "player"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduled := data.NewScheduledMessage(&data.Message{
+		UserID:         "organizer",
+		ConversationID: conversation.ID,
+		Text:           "Tournament starts in 10 minutes",
+		SendAt:         &sendAt,
+	})
+	err = db.AddScheduledMessage(context.Background(), scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scheduled
+}
+
+func TestDispatchDueMessages(t *testing.T) {
+	db := database.NewMockTextChat()
+	broker := events.NewBroker()
+	ctx := context.Background()
+	now := time.Now()
+	scheduled := newScheduledMessage(t, db, now.Add(time.Minute))
+
+	channel, unsubscribe := broker.Subscribe(scheduled.ConversationID)
+	defer unsubscribe()
+	dispatcher := NewDispatcher(db, broker, nil, DefaultInterval)
+
+	sent, err := dispatcher.Dispatch(ctx, now)
+	if err != nil || sent != 0 {
+		t.Fatalf("Expected no message before the send time, sent %d: %v", sent, err)
+	}
+
+	// The message is sent once with the ID of the scheduled message
+	for i := 0; i < 2; i++ {
+		_, err = dispatcher.Dispatch(ctx, now.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := <-channel
+	if event.Type != events.EventMessageCreated || event.Message.ID != scheduled.ID {
+		t.Errorf("Expected the scheduled message to be sent, got %+v", event)
+	}
+	messages, _ := db.GetMessagesByConversationID(ctx, scheduled.ConversationID)
+	if len(messages) != 1 {
+		t.Errorf("Expected the message to be sent once, got %d messages", len(messages))
+	}
+	scheduled, _ = db.GetScheduledMessageByID(ctx, scheduled.ID)
+	if scheduled.Status != data.ScheduleStatusSent {
+		t.Errorf("Expected status %s, got %s", data.ScheduleStatusSent, scheduled.Status)
+	}
+}
+
+func TestDispatchAfterRestart(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+	now := time.Now()
+	scheduled := newScheduledMessage(t, db, now)
+
+	// A dispatcher stopped after sending the message but before completing it
+	claimed, err := db.ClaimScheduledMessages(ctx, now, now.Add(-ClaimTimeout))
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Expected the message to be claimed, got %d: %v", len(claimed), err)
+	}
+	claimed[0].Message.ID = claimed[0].ID
+	err = db.AddMessage(ctx, claimed[0].Message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The message stays claimed until the claim timeout, then is completed without being sent again
+	dispatcher := NewDispatcher(db, events.NewBroker(), nil, DefaultInterval)
+	sent, _ := dispatcher.Dispatch(ctx, now.Add(time.Second))
+	if sent != 0 {
+		t.Errorf("Expected a claimed message to be left to its dispatcher, sent %d", sent)
+	}
+	sent, _ = dispatcher.Dispatch(ctx, now.Add(2*ClaimTimeout))
+	messages, _ := db.GetMessagesByConversationID(ctx, scheduled.ConversationID)
+	if sent != 1 || len(messages) != 1 {
+		t.Errorf("Expected the message to be completed without duplicate, sent %d with %d messages", sent, len(messages))
+	}
+}
+
+func TestDispatchFailsInArchivedConversation(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+	now := time.Now()
+	scheduled := newScheduledMessage(t, db, now)
+
+	conversation, _ := db.GetConversationByID(ctx, scheduled.ConversationID)
+	conversation.Archive()
+	err := db.UpdateConversation(ctx, conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewDispatcher(db, events.NewBroker(), nil, DefaultInterval)
+	_, _ = dispatcher.Dispatch(ctx, now)
+	scheduled, _ = db.GetScheduledMessageByID(ctx, scheduled.ID)
+	if scheduled.Status != data.ScheduleStatusFailed || scheduled.Error == "" {
+		t.Errorf("Expected the message to fail with its reason, got %+v", scheduled)
+	}
+}
+
+func TestDispatchKeepsTTL(t *testing.T) {
+	db := database.NewMockTextChat()
+	ctx := context.Background()
+	now := time.Now()
+	sendAt := now.Add(time.Minute)
+	conversation, _ := db.AddConversation(ctx, &data.Conversation{UserID: []string{"organizer", "player"}})
+	scheduled := data.NewScheduledMessage(&data.Message{UserID: "organizer", ConversationID: conversation.ID, Text: "Lobby code 1234", TTL: 60, SendAt: &sendAt})
+	err := db.AddScheduledMessage(ctx, scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The TTL of a message is not stored by MongoDB, only the TTL of the scheduled message is
+	scheduled.Message.TTL = 0
+
+	_, err = NewDispatcher(db, events.NewBroker(), nil, DefaultInterval).Dispatch(ctx, sendAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := db.GetMessageByID(ctx, scheduled.ID)
+	if err != nil || message.ExpiresAt == nil {
+		t.Fatalf("Expected the sent message to be ephemeral, got %+v: %v", message, err)
+	}
+}
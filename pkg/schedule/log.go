@@ -0,0 +1,7 @@
+package schedule
+
+import (
+	baselog "github.com/Ubivius/microservice-text-chat/pkg/log"
+)
+
+var log = baselog.MLog.WithName("schedule")
@@ -0,0 +1,74 @@
+package unfurl
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+type cacheEntry struct {
+	preview *data.Preview
+	expires time.Time
+}
+
+// Cache keeps the previews by URL for a limited time
+// A nil preview is cached for URLs that could not be unfurled, so they are not fetched again right away
+type Cache struct {
+	mutex      sync.Mutex
+	entries    map[string]cacheEntry
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+// NewCache creates a preview cache
+func NewCache(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		entries:    make(map[string]cacheEntry),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Get returns the cached preview of the URL and true when the URL is in the cache
+func (cache *Cache) Get(url string) (*data.Preview, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[url]
+	if !ok || cache.now().After(entry.expires) {
+		delete(cache.entries, url)
+		return nil, false
+	}
+	return entry.preview, true
+}
+
+// Set caches the preview of the URL
+func (cache *Cache) Set(url string, preview *data.Preview) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if len(cache.entries) >= cache.maxEntries {
+		cache.evict()
+	}
+	cache.entries[url] = cacheEntry{preview: preview, expires: cache.now().Add(cache.ttl)}
+}
+
+// evict removes the expired entries, or the entry expiring first when none is expired
+func (cache *Cache) evict() {
+	now := cache.now()
+	var oldestURL string
+	var oldest time.Time
+	for url, entry := range cache.entries {
+		if now.After(entry.expires) {
+			delete(cache.entries, url)
+			continue
+		}
+		if oldestURL == "" || entry.expires.Before(oldest) {
+			oldestURL, oldest = url, entry.expires
+		}
+	}
+	if len(cache.entries) >= cache.maxEntries {
+		delete(cache.entries, oldestURL)
+	}
+}
@@ -0,0 +1,208 @@
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+)
+
+// ErrorURLNotAllowed : The URL can't be fetched by the unfurl worker
+var ErrorURLNotAllowed = fmt.Errorf("url not allowed")
+
+// ErrorAddressNotAllowed : The URL resolves to a private or reserved address
+var ErrorAddressNotAllowed = fmt.Errorf("address not allowed")
+
+// ErrorNotHTML : The URL doesn't point to an HTML document
+var ErrorNotHTML = fmt.Errorf("content is not html")
+
+// FetcherConfig holds the limits of the sandboxed fetcher
+type FetcherConfig struct {
+	Timeout      time.Duration
+	MaxBodySize  int64
+	MaxRedirects int
+	// AllowedDomains restricts the fetched domains when it is not empty
+	AllowedDomains []string
+	// DeniedDomains are never fetched, even when they are allowed
+	DeniedDomains []string
+	// AllowPrivateNetworks disables the SSRF protection, it must only be used in tests
+	AllowPrivateNetworks bool
+	UserAgent            string
+}
+
+// DefaultFetcherConfig returns the default limits of the fetcher
+func DefaultFetcherConfig() FetcherConfig {
+	return FetcherConfig{
+		Timeout:      5 * time.Second,
+		MaxBodySize:  512 << 10,
+		MaxRedirects: 3,
+		UserAgent:    "UbiviusTextChatBot/1.0",
+	}
+}
+
+// Reserved networks that can't be reached by the fetcher, on top of loopback, link-local,
+// multicast and unspecified addresses
+var deniedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+)
+
+// Fetcher fetches the HTML of the URLs to unfurl with SSRF protection, size and time limits
+type Fetcher struct {
+	config FetcherConfig
+	client *http.Client
+}
+
+// NewFetcher creates a sandboxed fetcher
+func NewFetcher(config FetcherConfig) *Fetcher {
+	fetcher := &Fetcher{config: config}
+
+	// The address is checked after name resolution, right before connecting,
+	// so DNS rebinding and redirects can't reach a private address
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			return fetcher.checkAddress(address)
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   config.Timeout,
+		ResponseHeaderTimeout: config.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	fetcher.client = &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) > config.MaxRedirects {
+				return ErrorURLNotAllowed
+			}
+			return fetcher.checkURL(request.URL)
+		},
+	}
+	return fetcher
+}
+
+// Fetch downloads the document of the URL and returns its preview
+func (fetcher *Fetcher) Fetch(ctx context.Context, rawURL string) (*data.Preview, error) {
+	pageURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrorURLNotAllowed
+	}
+	err = fetcher.checkURL(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", fetcher.config.UserAgent)
+	request.Header.Set("Accept", "text/html")
+
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrorNotHTML
+	}
+
+	preview := parsePreview(io.LimitReader(response.Body, fetcher.config.MaxBodySize), response.Request.URL)
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// checkURL verifies the scheme, port and domain of the URL
+func (fetcher *Fetcher) checkURL(pageURL *url.URL) error {
+	if pageURL.Scheme != "http" && pageURL.Scheme != "https" || pageURL.User != nil {
+		return ErrorURLNotAllowed
+	}
+	if !fetcher.config.AllowPrivateNetworks && pageURL.Port() != "" && pageURL.Port() != "80" && pageURL.Port() != "443" {
+		return ErrorURLNotAllowed
+	}
+
+	host := strings.ToLower(pageURL.Hostname())
+	if matchesDomain(host, fetcher.config.DeniedDomains) {
+		return ErrorURLNotAllowed
+	}
+	if len(fetcher.config.AllowedDomains) > 0 && !matchesDomain(host, fetcher.config.AllowedDomains) {
+		return ErrorURLNotAllowed
+	}
+	return nil
+}
+
+// checkAddress verifies that the resolved address is a public address
+func (fetcher *Fetcher) checkAddress(address string) error {
+	if fetcher.config.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrorAddressNotAllowed
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isDeniedIP(ip) {
+		return ErrorAddressNotAllowed
+	}
+	return nil
+}
+
+func isDeniedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, network := range deniedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,106 @@
+package unfurl
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"golang.org/x/net/html"
+)
+
+// Maximum lengths of the preview fields, in characters
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+)
+
+// parsePreview reads the metadata of an HTML document
+// Open Graph properties are used first, then the standard title and description
+func parsePreview(body io.Reader, pageURL *url.URL) *data.Preview {
+	properties := make(map[string]string)
+	var title string
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(body)
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			return buildPreview(properties, title, pageURL)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				key, content := metaProperty(token)
+				if key != "" && properties[key] == "" {
+					properties[key] = content
+				}
+			case "title":
+				inTitle = title == ""
+			case "body":
+				// The metadata is in the head, the rest of the document is not needed
+				return buildPreview(properties, title, pageURL)
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			if token := tokenizer.Token(); token.Data == "title" {
+				inTitle = false
+			} else if token.Data == "head" {
+				return buildPreview(properties, title, pageURL)
+			}
+		}
+	}
+}
+
+func metaProperty(token html.Token) (string, string) {
+	var key, content string
+	for _, attribute := range token.Attr {
+		switch strings.ToLower(attribute.Key) {
+		case "property", "name":
+			key = strings.ToLower(attribute.Val)
+		case "content":
+			content = attribute.Val
+		}
+	}
+	return key, content
+}
+
+func buildPreview(properties map[string]string, title string, pageURL *url.URL) *data.Preview {
+	preview := &data.Preview{
+		Title:       firstNonEmpty(properties["og:title"], properties["twitter:title"], title),
+		Description: firstNonEmpty(properties["og:description"], properties["twitter:description"], properties["description"]),
+		SiteName:    properties["og:site_name"],
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptionLength)
+	preview.SiteName = truncate(preview.SiteName, maxTitleLength)
+
+	// Only absolute http(s) image URLs are kept
+	image := firstNonEmpty(properties["og:image"], properties["twitter:image"])
+	if imageURL, err := pageURL.Parse(image); image != "" && err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+		preview.ImageURL = imageURL.String()
+	}
+	return preview
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func truncate(value string, maxLength int) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if utf8.RuneCountInString(value) <= maxLength {
+		return value
+	}
+	return string([]rune(value)[:maxLength-1]) + "…"
+}
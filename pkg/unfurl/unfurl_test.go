@@ -0,0 +1,168 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+)
+
+const page = `<html><head>
+<title>Fallback title</title>
+<meta property="og:title" content="Ubivius patch notes">
+<meta name="description" content="Everything new in this season">
+<meta property="og:image" content="/images/cover.png">
+</head><body><meta property="og:title" content="ignored"></body></html>`
+
+func newTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(responseWriter, page)
+	})
+	mux.HandleFunc("/binary", func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/octet-stream")
+		fmt.Fprint(responseWriter, "binary")
+	})
+	mux.HandleFunc("/slow", func(responseWriter http.ResponseWriter, request *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	mux.HandleFunc("/redirect", func(responseWriter http.ResponseWriter, request *http.Request) {
+		http.Redirect(responseWriter, request, "http://denied.example.com/", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestFetcher() *Fetcher {
+	config := DefaultFetcherConfig()
+	config.AllowPrivateNetworks = true
+	config.Timeout = 100 * time.Millisecond
+	config.DeniedDomains = []string{"example.com"}
+	return NewFetcher(config)
+}
+
+func TestFetchPreview(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	preview, err := newTestFetcher().Fetch(context.Background(), server.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Title != "Ubivius patch notes" || preview.Description != "Everything new in this season" {
+		t.Errorf("Unexpected preview %+v", preview)
+	}
+	if preview.ImageURL != server.URL+"/images/cover.png" {
+		t.Errorf("Expected absolute image URL but got %s", preview.ImageURL)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	fetcher := newTestFetcher()
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/binary"); err != ErrorNotHTML {
+		t.Errorf("Expected non html content to be rejected but got %v", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/slow"); err == nil {
+		t.Error("Expected slow response to time out")
+	}
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/redirect"); !errors.Is(err, ErrorURLNotAllowed) {
+		t.Errorf("Expected redirect to a denied domain to be rejected but got %v", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), "ftp://files.ubivius.com/"); err != ErrorURLNotAllowed {
+		t.Errorf("Expected non http scheme to be rejected but got %v", err)
+	}
+}
+
+func TestSSRFProtection(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	fetcher := NewFetcher(DefaultFetcherConfig())
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/page"); err == nil {
+		t.Error("Expected local test server to be unreachable")
+	}
+
+	for _, address := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "::1", "fd00::1", "0.0.0.0"} {
+		if fetcher.checkAddress(net.JoinHostPort(address, "80")) != ErrorAddressNotAllowed {
+			t.Errorf("Expected %s to be denied", address)
+		}
+	}
+	if fetcher.checkAddress("93.184.216.34:443") != nil {
+		t.Error("Expected public address to be allowed")
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(time.Minute, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", &data.Preview{URL: "a"})
+	cache.Set("b", nil)
+	if preview, ok := cache.Get("b"); !ok || preview != nil {
+		t.Error("Expected failed unfurl to be cached")
+	}
+	cache.Set("c", &data.Preview{URL: "c"})
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected cache to be bounded but got %d entries", len(cache.entries))
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get("c"); ok {
+		t.Error("Expected entry to expire")
+	}
+}
+
+func TestWorkerAttachesPreviewAndPublishesUpdate(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	db := database.NewMockTextChat()
+	message := &data.Message{
+		UserID:         "a2181017-5c53-422b-b6bc-036b27c04fc8",
+		ConversationID: "e2382ea2-b5fa-4506-aa9d-d338aa52af44",
+		Text:           "read this",
+		Entities:       []data.Entity{{Type: data.EntityTypeLink, Offset: 0, Length: 4, URL: server.URL + "/page"}},
+	}
+	err := db.AddMessage(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := events.NewBroker()
+	channel, unsubscribe := broker.Subscribe(message.ConversationID)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := NewWorker(db, broker, newTestFetcher())
+	worker.Start(ctx)
+	worker.Enqueue(message)
+
+	select {
+	case event := <-channel:
+		if event.Type != events.EventMessageUpdated || len(event.Message.Previews) != 1 || !strings.Contains(event.Message.Previews[0].Title, "patch notes") {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected a message update event")
+	}
+	cancel()
+	worker.Wait()
+
+	stored, err := db.GetMessageByID(context.Background(), message.ID)
+	if err != nil || len(stored.Previews) != 1 {
+		t.Errorf("Expected preview to be stored, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package unfurl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ubivius/microservice-text-chat/pkg/data"
+	"github.com/Ubivius/microservice-text-chat/pkg/database"
+	"github.com/Ubivius/microservice-text-chat/pkg/events"
+)
+
+// MaxPreviewsPerMessage is the maximum number of URLs unfurled for a single message
+const MaxPreviewsPerMessage = 3
+
+// Worker unfurls the URLs of the messages in the background and attaches the previews to the messages
+type Worker struct {
+	db      database.TextChatDB
+	broker  *events.Broker
+	fetcher *Fetcher
+	cache   *Cache
+	queue   chan *data.Message
+	workers int
+	wait    sync.WaitGroup
+}
+
+// NewWorker creates an unfurl worker, the previews are published as message updates on the broker
+func NewWorker(db database.TextChatDB, broker *events.Broker, fetcher *Fetcher) *Worker {
+	return &Worker{
+		db:      db,
+		broker:  broker,
+		fetcher: fetcher,
+		cache:   NewCache(time.Hour, 1000),
+		queue:   make(chan *data.Message, 100),
+		workers: 2,
+	}
+}
+
+// Start runs the worker until the context is cancelled
+func (worker *Worker) Start(ctx context.Context) {
+	for i := 0; i < worker.workers; i++ {
+		worker.wait.Add(1)
+		go func() {
+			defer worker.wait.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case message := <-worker.queue:
+					worker.unfurl(ctx, message)
+				}
+			}
+		}()
+	}
+}
+
+// Wait blocks until the worker is stopped
+func (worker *Worker) Wait() {
+	worker.wait.Wait()
+}
+
+// Enqueue schedules the unfurling of the links of a stored message
+// Messages are dropped when the queue is full, a missing preview is not an error
+func (worker *Worker) Enqueue(message *data.Message) {
+	if len(message.LinkURLs()) == 0 {
+		return
+	}
+	select {
+	case worker.queue <- message:
+	default:
+		log.Info("Unfurl queue full, skipping message", "message_id", message.ID)
+	}
+}
+
+func (worker *Worker) unfurl(ctx context.Context, message *data.Message) {
+	var previews []*data.Preview
+	for _, url := range message.LinkURLs() {
+		if len(previews) == MaxPreviewsPerMessage {
+			break
+		}
+		if preview := worker.preview(ctx, url); preview != nil {
+			previews = append(previews, preview)
+		}
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	err := worker.db.SetMessagePreviews(ctx, message.ID, previews)
+	if err != nil {
+		log.Error(err, "Error saving previews", "message_id", message.ID)
+		return
+	}
+
+	updated := *message
+	updated.Previews = previews
+	worker.broker.PublishMessage(events.EventMessageUpdated, &updated)
+}
+
+// preview returns the preview of the URL from the cache or fetches it
+func (worker *Worker) preview(ctx context.Context, url string) *data.Preview {
+	if preview, ok := worker.cache.Get(url); ok {
+		return preview
+	}
+
+	preview, err := worker.fetcher.Fetch(ctx, url)
+	if err != nil {
+		log.Info("Unable to unfurl URL", "url", url, "error", err.Error())
+		preview = nil
+	} else if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
+		preview = nil
+	}
+	worker.cache.Set(url, preview)
+	return preview
+}
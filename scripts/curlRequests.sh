@@ -5,9 +5,35 @@
 curl localhost:9090/messages/a2181017-5c53-422b-b6bc-036b27c04fc8
 curl localhost:9090/messages/conversation/a2181017-5c53-422b-b6bc-036b27c04fc8 # All messages from the conversation
 curl localhost:9090/messages -XPOST -d '{"user_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "conversation_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "text":"This is a message"}'
+curl localhost:9090/messages -XPOST -d '{"user_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "game_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "channel":"team", "text":"Push B"}'
+curl localhost:9090/messages -XPOST -d '{"user_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "conversation_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "text":"Tournament starts in 10 minutes", "send_at":"2030-01-01T20:50:00Z"}'
+curl localhost:9090/users/a2181017-5c53-422b-b6bc-036b27c04fc8/scheduled-messages
+curl localhost:9090/scheduled-messages/a2181017-5c53-422b-b6bc-036b27c04fc8 -XDELETE
+curl localhost:9090/games/a2181017-5c53-422b-b6bc-036b27c04fc8/messages
 curl localhost:9090/messages/a2181017-5c53-422b-b6bc-036b27c04fc8 -XDELETE
+curl localhost:9091/messages/system -XPOST -d '{"conversation_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "text":"Round 3 begins", "kind":"system", "sender":{"id":"game-server", "type":"service", "display_name":"Game"}}' # Internal router
 
 curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44
 curl localhost:9090/conversations -XPOST -d '{"user_id":["a2181017-5c53-422b-b6bc-036b27c04fc8", "e2382ea2-b5fa-4506-aa9d-d338aa52af44"], "game_id":""}'
 curl localhost:9090/conversations -XPUT -d '{"id": "e2382ea2-b5fa-4506-aa9d-d338aa52af44", "user_id":["a2181017-5c53-422b-b6bc-036b27c04fc8", "newUserID"], "game_id":"e2382ea2-b5fa-4506-aa9d-d338aa52af44"}'
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44 -XPATCH -d '{"title":"Raid team", "attributes":{"region":"eu-west"}, "version":0}'
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/roles -XPUT -d '{"user_id":"2aee2975-6b76-4340-b679-e81661b1cdb5", "role":"moderator"}'
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/owner -XPUT -d '{"user_id":"2aee2975-6b76-4340-b679-e81661b1cdb5"}'
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/pins/e2382ea2-b5fa-4506-aa9d-d338aa52af44 -XPOST
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/pins
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/pins/e2382ea2-b5fa-4506-aa9d-d338aa52af44 -XDELETE
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/archive -XPOST
+curl localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/restore -XPOST
+curl localhost:9090/users/a2181017-5c53-422b-b6bc-036b27c04fc8/conversations?include_archived=true
+curl "localhost:9090/conversations/e2382ea2-b5fa-4506-aa9d-d338aa52af44/export?format=txt&tz=America/Montreal"
 curl localhost:9090/conversations/a2181017-5c53-422b-b6bc-036b27c04fc8 -XDELETE
+
+curl localhost:9091/matches -XPOST -d '{"match_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "teams":{"blue":["a2181017-5c53-422b-b6bc-036b27c04fc8"], "red":["2aee2975-6b76-4340-b679-e81661b1cdb5"]}}' # Internal router
+curl localhost:9091/matches/a2181017-5c53-422b-b6bc-036b27c04fc8/players -XPUT -d '{"user_id":"a2181017-5c53-422b-b6bc-036b27c04fc8", "team":"red"}' # Internal router
+curl localhost:9091/matches/a2181017-5c53-422b-b6bc-036b27c04fc8/players -XPOST -d '{"user_id":"c6e6a2b2-bd25-4151-ace1-611accc15a50", "spectator":true}' # Internal router
+curl localhost:9091/matches/a2181017-5c53-422b-b6bc-036b27c04fc8/end?transcript=true -XPOST # Internal router
+curl localhost:9091/retention/report # Internal router
+curl localhost:9091/users/a2181017-5c53-422b-b6bc-036b27c04fc8/export # Internal router
+curl localhost:9091/conversations/a2181017-5c53-422b-b6bc-036b27c04fc8/export?format=ndjson # Internal router
+curl localhost:9091/users/a2181017-5c53-422b-b6bc-036b27c04fc8/erase?policy=anonymize -XPOST # Internal router
+curl localhost:9091/import -XPOST --data-binary @lobby-chat.ndjson # Internal router